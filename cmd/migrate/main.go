@@ -52,8 +52,8 @@ func main() {
 	if err != nil {
 		log.Fatalf("connecting to database: %v", err)
 	}
-	if err := db.AutoMigrate(ctx, pgDB); err != nil {
-		log.Fatalf("auto-migrating: %v", err)
+	if _, err := db.Migrate(ctx, pgDB); err != nil {
+		log.Fatalf("migrating schema: %v", err)
 	}
 
 	bqClient, err := bigquery.NewClient(ctx, *project)
@@ -117,15 +117,9 @@ func migrateAnalytics(ctx context.Context, bq *bigquery.Client, pgDB *gorm.DB, p
 			createdAt = row.Time.DateTime.In(time.UTC)
 		}
 
-		batch = append(batch, &db.WebVital{
-			CreatedAt: createdAt,
-			Service:   row.Service.StringVal,
-			Name:      row.Name,
-			Value:     row.Value,
-			Delta:     row.Delta,
-			VitalID:   row.ID,
-			Label:     row.Label.StringVal,
-		})
+		entry := db.WebVitalFromAnalytics(&row)
+		entry.CreatedAt = createdAt
+		batch = append(batch, entry)
 
 		if len(batch) >= 500 {
 			if err := pgDB.CreateInBatches(batch, 500).Error; err != nil {
@@ -194,7 +188,7 @@ func migrateReports(ctx context.Context, bq *bigquery.Client, pgDB *gorm.DB, pro
 		batch = append(batch, entries...)
 
 		if len(batch) >= 500 {
-			if err := pgDB.CreateInBatches(batch, 500).Error; err != nil {
+			if err := db.SaveReportToEntries(ctx, pgDB, batch); err != nil {
 				log.Fatalf("inserting reports batch: %v", err)
 			}
 			total += len(batch)
@@ -204,7 +198,7 @@ func migrateReports(ctx context.Context, bq *bigquery.Client, pgDB *gorm.DB, pro
 	}
 
 	if len(batch) > 0 {
-		if err := pgDB.CreateInBatches(batch, 500).Error; err != nil {
+		if err := db.SaveReportToEntries(ctx, pgDB, batch); err != nil {
 			log.Fatalf("inserting reports batch: %v", err)
 		}
 		total += len(batch)
@@ -263,7 +257,7 @@ func migrateReporting(ctx context.Context, bq *bigquery.Client, pgDB *gorm.DB, p
 		batch = append(batch, entry)
 
 		if len(batch) >= 500 {
-			if err := pgDB.CreateInBatches(batch, 500).Error; err != nil {
+			if err := db.SaveSecurityReportEntries(ctx, pgDB, batch); err != nil {
 				log.Fatalf("inserting reporting batch: %v", err)
 			}
 			total += len(batch)
@@ -273,7 +267,7 @@ func migrateReporting(ctx context.Context, bq *bigquery.Client, pgDB *gorm.DB, p
 	}
 
 	if len(batch) > 0 {
-		if err := pgDB.CreateInBatches(batch, 500).Error; err != nil {
+		if err := db.SaveSecurityReportEntries(ctx, pgDB, batch); err != nil {
 			log.Fatalf("inserting reporting batch: %v", err)
 		}
 		total += len(batch)
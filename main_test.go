@@ -1,12 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -14,15 +22,17 @@ import (
 
 	"github.com/icco/reportd/pkg/analytics"
 	"github.com/icco/reportd/pkg/db"
+	"github.com/icco/reportd/pkg/ingest"
+	"github.com/icco/reportd/pkg/noise"
 	"github.com/icco/reportd/pkg/reporting"
 	"github.com/icco/reportd/pkg/reportto"
+	"github.com/icco/reportd/pkg/sink"
 	"gorm.io/gorm"
 )
 
-// recordingWriters captures invocations of the BigQuery writer hooks so tests
-// can assert that the post-handler goroutines fire without performing any
-// real network I/O.
-type recordingWriters struct {
+// recordingSink captures published events so tests can assert that the
+// post handlers forward to sinks without performing any real network I/O.
+type recordingSink struct {
 	mu              sync.Mutex
 	reports         []*reportto.Report
 	analyticsRows   []*analytics.WebVital
@@ -32,39 +42,47 @@ type recordingWriters struct {
 	doneSecurityRpt chan struct{}
 }
 
-func newRecordingWriters() *recordingWriters {
-	return &recordingWriters{
+func newRecordingSink() *recordingSink {
+	return &recordingSink{
 		doneAnalytics:   make(chan struct{}, 8),
 		doneReport:      make(chan struct{}, 8),
 		doneSecurityRpt: make(chan struct{}, 8),
 	}
 }
 
-func (w *recordingWriters) writeReport(_ context.Context, r *reportto.Report) {
-	w.mu.Lock()
-	w.reports = append(w.reports, r)
-	w.mu.Unlock()
-	w.doneReport <- struct{}{}
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Write(_ context.Context, events []sink.Event) error {
+	for _, e := range events {
+		s.mu.Lock()
+		switch e.Kind {
+		case sink.KindReportTo:
+			s.reports = append(s.reports, e.ReportTo)
+		case sink.KindWebVital:
+			s.analyticsRows = append(s.analyticsRows, e.WebVital)
+		case sink.KindSecurityReport:
+			s.securityReports = append(s.securityReports, e.SecurityReport)
+		}
+		s.mu.Unlock()
+
+		switch e.Kind {
+		case sink.KindReportTo:
+			s.doneReport <- struct{}{}
+		case sink.KindWebVital:
+			s.doneAnalytics <- struct{}{}
+		case sink.KindSecurityReport:
+			s.doneSecurityRpt <- struct{}{}
+		}
+	}
+	return nil
 }
 
-func (w *recordingWriters) writeAnalytics(_ context.Context, r *analytics.WebVital) {
-	w.mu.Lock()
-	w.analyticsRows = append(w.analyticsRows, r)
-	w.mu.Unlock()
-	w.doneAnalytics <- struct{}{}
-}
-
-func (w *recordingWriters) writeSecurityReport(_ context.Context, r *reporting.SecurityReport) {
-	w.mu.Lock()
-	w.securityReports = append(w.securityReports, r)
-	w.mu.Unlock()
-	w.doneSecurityRpt <- struct{}{}
-}
+func (s *recordingSink) Close(context.Context) error { return nil }
 
 // newTestRouter wires up the same router as main() but against a fresh
-// per-test SQLite DB and recording BigQuery writers, so the test stays
+// per-test SQLite DB and a recording sink, so the test stays
 // hermetic and isolated from other test functions.
-func newTestRouter(t *testing.T) (http.Handler, *gorm.DB, *recordingWriters) {
+func newTestRouter(t *testing.T) (http.Handler, *gorm.DB, *recordingSink) {
 	t.Helper()
 	ctx := context.Background()
 	dsn := "sqlite://" + filepath.Join(t.TempDir(), "reportd.db")
@@ -72,11 +90,11 @@ func newTestRouter(t *testing.T) (http.Handler, *gorm.DB, *recordingWriters) {
 	if err != nil {
 		t.Fatalf("db.Connect: %v", err)
 	}
-	if err := db.AutoMigrate(ctx, pgDB); err != nil {
-		t.Fatalf("db.AutoMigrate: %v", err)
+	if _, err := db.Migrate(ctx, pgDB); err != nil {
+		t.Fatalf("db.Migrate: %v", err)
 	}
-	rec := newRecordingWriters()
-	router := newRouter(pgDB, rec.writeReport, rec.writeAnalytics, rec.writeSecurityReport)
+	rec := newRecordingSink()
+	router := newRouter(pgDB, routerConfig{Events: sink.NewFanout(rec)})
 	return router, pgDB, rec
 }
 
@@ -91,6 +109,18 @@ func do(t *testing.T, h http.Handler, method, target string, body io.Reader, con
 	return rr
 }
 
+// errorCode returns the code of an ingest JSON error response.
+func errorCode(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	var got struct {
+		Error ingest.Error `json:"error"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("error body: %v body=%s", err, rr.Body.String())
+	}
+	return got.Error.Code
+}
+
 // waitForSignal returns true if a value is received on ch within 2s.
 func waitForSignal(ch chan struct{}) bool {
 	select {
@@ -252,6 +282,29 @@ func TestGetReportsHandler(t *testing.T) {
 	if len(got) == 0 {
 		t.Errorf("expected non-empty counts, got %v", got)
 	}
+
+	q := url.Values{
+		"from":     {time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)},
+		"interval": {"hour"},
+		"tz":       {"Asia/Kolkata"},
+	}
+	rr = do(t, h, http.MethodGet, "/reports/svc?"+q.Encode(), nil, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `+05:30","day"`) {
+		t.Errorf("hourly in Kolkata: status = %d body = %s", rr.Code, rr.Body.String())
+	}
+
+	for _, query := range []string{
+		"interval=minute",
+		"tz=Nowhere/Else",
+		"from=2025-02-01&to=2025-01-01",
+		"from=2025-01-01&to=2025-06-01&interval=hour",
+		"to=soon",
+	} {
+		rr = do(t, h, http.MethodGet, "/reports/svc?"+query, nil, "")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rr.Code)
+		}
+	}
 }
 
 func TestGetAnalyticsHandler(t *testing.T) {
@@ -311,10 +364,11 @@ func TestApiVitalsHandler(t *testing.T) {
 		t.Fatalf("status = %d, want 200", rr.Code)
 	}
 	var got struct {
-		Averages []struct {
+		Percentile  string `json:"percentile"`
+		Percentiles []struct {
 			Name  string  `json:"name"`
 			Value float64 `json:"value"`
-		} `json:"averages"`
+		} `json:"percentiles"`
 		Summaries []struct {
 			Day     string  `json:"day"`
 			Service string  `json:"service"`
@@ -325,12 +379,25 @@ func TestApiVitalsHandler(t *testing.T) {
 	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
 		t.Fatalf("json: %v", err)
 	}
-	if len(got.Averages) != 1 || got.Averages[0].Name != "LCP" {
-		t.Errorf("averages = %+v, want one LCP", got.Averages)
+	if got.Percentile != "p75" {
+		t.Errorf("percentile = %q, want p75", got.Percentile)
+	}
+	if len(got.Percentiles) != 1 || got.Percentiles[0].Name != "LCP" {
+		t.Errorf("percentiles = %+v, want one LCP", got.Percentiles)
 	}
 	if len(got.Summaries) == 0 {
 		t.Errorf("summaries should be non-empty")
 	}
+
+	rr = do(t, h, http.MethodGet, "/api/vitals/svc?percentile=p95", nil, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"percentile":"p95"`) {
+		t.Errorf("p95: status = %d body = %s", rr.Code, rr.Body.String())
+	}
+
+	rr = do(t, h, http.MethodGet, "/api/vitals/svc?percentile=p99", nil, "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid percentile: status = %d, want 400", rr.Code)
+	}
 }
 
 func TestApiReportsHandler(t *testing.T) {
@@ -388,6 +455,185 @@ func TestApiReportsHandler(t *testing.T) {
 	}
 }
 
+func TestApiReportSearchHandler(t *testing.T) {
+	h, _, rec := newTestRouter(t)
+
+	rr := do(t, h, http.MethodGet, "/api/reports/bad.service/search", nil, "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid service: status = %d, want 400", rr.Code)
+	}
+
+	// Legacy CSP bodies carry no user agent, so the request's is stored.
+	body := `{"csp-report":{"document-uri":"https://example.com/","violated-directive":"script-src-elem","effective-directive":"script-src-elem","blocked-uri":"https://CDN.evil.com/script.js"}}`
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/reporting/svc", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/csp-report")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.4; rv:125.0) Gecko/20100101 Firefox/125.0")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("post: status = %d, want 204, body=%s", rr.Code, rr.Body.String())
+	}
+	waitForSignal(rec.doneSecurityRpt)
+
+	search := func(query string) db.ReportSearchPage {
+		t.Helper()
+		rr := do(t, h, http.MethodGet, "/api/reports/svc/search?"+query, nil, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("search %q: status = %d, want 200, body=%s", query, rr.Code, rr.Body.String())
+		}
+		var page db.ReportSearchPage
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("json: %v body=%s", err, rr.Body.String())
+		}
+		return page
+	}
+
+	page := search("ua_family=firefox&blocked_host=cdn.evil.com&directive=script-src-elem&document_url=" + url.QueryEscape("https://example.com/"))
+	if len(page.Reports) != 1 || page.Next != "" {
+		t.Fatalf("filtered search = %+v, want the one report", page)
+	}
+	if r := page.Reports[0]; r.Source != db.SourceReporting || r.BrowserFamily != "firefox" || r.ReportType != "csp-violation" {
+		t.Errorf("report = %+v", r)
+	}
+	if page := search("ua_family=chrome"); len(page.Reports) != 0 {
+		t.Errorf("ua_family=chrome = %+v, want none", page)
+	}
+	if page := search("to=2000-01-01"); len(page.Reports) != 0 {
+		t.Errorf("to=2000-01-01 = %+v, want none", page)
+	}
+
+	for _, query := range []string{"cursor=bogus", "limit=0", "from=yesterday"} {
+		rr := do(t, h, http.MethodGet, "/api/reports/svc/search?"+query, nil, "")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rr.Code)
+		}
+	}
+}
+
+func TestApiExportHandler(t *testing.T) {
+	h, pgDB, _ := newTestRouter(t)
+
+	base := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"LCP", "CLS", "LCP"} {
+		if err := pgDB.Create(&db.WebVital{CreatedAt: base.Add(time.Duration(i) * time.Hour), Service: "svc", Name: name, Value: float64(i), Label: "a,b"}).Error; err != nil {
+			t.Fatalf("seed web vital: %v", err)
+		}
+	}
+
+	rr := do(t, h, http.MethodGet, "/api/export/svc/web-vitals?format=csv&type=LCP", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("csv: status = %d, want 200, body=%s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("csv: content-type = %q, want text/csv", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename=svc-web-vitals.csv` {
+		t.Errorf("csv: content-disposition = %q", cd)
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	if len(records) != 3 || records[0][0] != "id" || !slices.Contains(records[0], "label") {
+		t.Fatalf("csv = %q, want a header and the two LCP rows", records)
+	}
+	label := slices.Index(records[0], "label")
+	if records[1][label] != "a,b" || records[2][slices.Index(records[0], "value")] != "2" {
+		t.Errorf("csv rows = %q", records[1:])
+	}
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/api/export/svc/web-vitals?from=2025-07-01T01:00:00Z", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("ndjson: status = %d, content-type = %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	dec := json.NewDecoder(rr.Body)
+	var names []string
+	for dec.More() {
+		var v db.WebVital
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("ndjson: %v", err)
+		}
+		names = append(names, v.Name)
+	}
+	if !slices.Equal(names, []string{"CLS", "LCP"}) {
+		t.Errorf("ndjson names = %q, want CLS then LCP", names)
+	}
+
+	req = httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/api/export/svc/reporting", nil)
+	req.Header.Set("Accept", "text/csv")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/csv") {
+		t.Errorf("accept csv: status = %d, content-type = %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if lines := strings.Count(rr.Body.String(), "\n"); lines != 1 {
+		t.Errorf("empty export = %q, want just the header", rr.Body.String())
+	}
+
+	for _, tc := range []struct {
+		target string
+		want   int
+	}{
+		{"/api/export/bad.service/web-vitals", http.StatusBadRequest},
+		{"/api/export/svc/issues", http.StatusNotFound},
+		{"/api/export/svc/web-vitals?format=xlsx", http.StatusBadRequest},
+		{"/api/export/svc/report-to?from=yesterday", http.StatusBadRequest},
+	} {
+		if rr := do(t, h, http.MethodGet, tc.target, nil, ""); rr.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.target, rr.Code, tc.want)
+		}
+	}
+
+	// A failure before any row is sent is a plain 500, not an aborted
+	// connection.
+	if err := pgDB.Migrator().DropTable(&db.WebVital{}); err != nil {
+		t.Fatal(err)
+	}
+	rr = do(t, h, http.MethodGet, "/api/export/svc/web-vitals?format=csv", nil, "")
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("Content-Disposition") != "" {
+		t.Errorf("failed export: status = %d, content-disposition = %q; want a 500 without an attachment", rr.Code, rr.Header().Get("Content-Disposition"))
+	}
+}
+
+func TestApiNELHandler(t *testing.T) {
+	h, pgDB, _ := newTestRouter(t)
+
+	rr := do(t, h, http.MethodGet, "/api/nel/bad.service", nil, "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid service: status = %d, want 400", rr.Code)
+	}
+
+	// NEL arrives on the legacy Report-To path.
+	body := `[{"type":"network-error","url":"https://example.com/","body":{"phase":"dns","type":"dns.name_not_resolved","sampling_fraction":1,"server_ip":""}}]`
+	rr = do(t, h, http.MethodPost, "/report/svc", strings.NewReader(body), "application/reports+json")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("post nel: status = %d, want 204, body=%s", rr.Code, rr.Body.String())
+	}
+
+	var entry db.ReportToEntry
+	if err := pgDB.Where("service = ?", "svc").First(&entry).Error; err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if entry.NELType != "dns.name_not_resolved" || entry.Phase != "dns" {
+		t.Errorf("stored nel_type/phase = %q/%q", entry.NELType, entry.Phase)
+	}
+
+	rr = do(t, h, http.MethodGet, "/api/nel/svc", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200, body=%s", rr.Code, rr.Body.String())
+	}
+	var got db.NELSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("json: %v body=%s", err, rr.Body.String())
+	}
+	if got.Errors != 1 || len(got.ByType) != 1 || got.ByType[0].Key != "dns.name_not_resolved" {
+		t.Errorf("summary = %+v, want one dns.name_not_resolved error", got)
+	}
+}
+
 func TestPostReportHandler(t *testing.T) {
 	h, pgDB, rec := newTestRouter(t)
 
@@ -397,8 +643,8 @@ func TestPostReportHandler(t *testing.T) {
 	}
 
 	rr = do(t, h, http.MethodPost, "/report/svc", strings.NewReader("not json"), "application/csp-report")
-	if rr.Code != http.StatusInternalServerError {
-		t.Errorf("malformed body: status = %d, want 500", rr.Code)
+	if rr.Code != http.StatusBadRequest || errorCode(t, rr) != ingest.CodeMalformedPayload {
+		t.Errorf("malformed body: status = %d, body=%s; want 400 %s", rr.Code, rr.Body.String(), ingest.CodeMalformedPayload)
 	}
 
 	body := `{"csp-report":{"document-uri":"https://example.com/","blocked-uri":"https://evil.com/","violated-directive":"script-src"}}`
@@ -429,8 +675,8 @@ func TestPostAnalyticsHandler(t *testing.T) {
 	}
 
 	rr = do(t, h, http.MethodPost, "/analytics/svc", strings.NewReader("not json"), "application/json")
-	if rr.Code != http.StatusInternalServerError {
-		t.Errorf("malformed body: status = %d, want 500", rr.Code)
+	if rr.Code != http.StatusBadRequest || errorCode(t, rr) != ingest.CodeMalformedPayload {
+		t.Errorf("malformed body: status = %d, body=%s; want 400 %s", rr.Code, rr.Body.String(), ingest.CodeMalformedPayload)
 	}
 
 	body := `{"id":"v1-abc","name":"LCP","value":2500,"delta":100,"label":"web-vital"}`
@@ -452,12 +698,455 @@ func TestPostAnalyticsHandler(t *testing.T) {
 	}
 }
 
+func TestApiVitalBreakdownHandlers(t *testing.T) {
+	h, _, rec := newTestRouter(t)
+
+	body := `{"id":"v4-1","name":"LCP","value":3000,"delta":3000,"navigationType":"back-forward-cache","attribution":{"element":"#hero","url":"https://example.com/hero.avif"}}`
+	rr := do(t, h, http.MethodPost, "/analytics/svc", strings.NewReader(body), "application/json")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("post: status = %d, want 204, body=%s", rr.Code, rr.Body.String())
+	}
+	waitForSignal(rec.doneAnalytics)
+
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{"/api/vitals/svc/ratings", `"rating":"needs-improvement"`},
+		{"/api/vitals/svc/navigation", `"key":"back-forward-cache"`},
+		{"/api/vitals/svc/attribution", `"key":"#hero"`},
+	} {
+		rr := do(t, h, http.MethodGet, tc.path, nil, "")
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200", tc.path, rr.Code)
+			continue
+		}
+		if !strings.Contains(rr.Body.String(), tc.want) {
+			t.Errorf("%s: body %s should contain %s", tc.path, rr.Body.String(), tc.want)
+		}
+	}
+
+	for _, path := range []string{"/api/vitals/bad.service/ratings", "/api/vitals/svc/navigation?percentile=p1"} {
+		if rr := do(t, h, http.MethodGet, path, nil, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", path, rr.Code)
+		}
+	}
+}
+
+func TestApiIssuesHandler(t *testing.T) {
+	h, _, rec := newTestRouter(t)
+
+	// The same violation from two pages, blocking two scripts on one
+	// origin, is one issue.
+	for _, page := range []string{"a", "b"} {
+		body := `{"type":"csp-violation","url":"https://example.com/` + page + `","body":{"document_uri":"https://example.com/` + page + `","blocked_uri":"https://evil.com/` + page + `.js","effective_directive":"script-src"}}`
+		rr := do(t, h, http.MethodPost, "/reporting/svc", strings.NewReader(body), "application/reports+json")
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("post: status = %d, want 204, body=%s", rr.Code, rr.Body.String())
+		}
+		waitForSignal(rec.doneSecurityRpt)
+	}
+
+	rr := do(t, h, http.MethodGet, "/api/issues/svc?sort=count", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	var got struct {
+		Issues []struct {
+			Title  string          `json:"title"`
+			Count  int64           `json:"count"`
+			Sample json.RawMessage `json:"sample"`
+		} `json:"issues"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Issues) != 1 || got.Issues[0].Count != 2 || got.Issues[0].Title != "script-src blocked https://evil.com" {
+		t.Errorf("issues = %+v, want one issue seen twice", got.Issues)
+	}
+	if len(got.Issues) == 1 && !strings.Contains(string(got.Issues[0].Sample), `"blocked_uri":"https://evil.com/a.js"`) {
+		t.Errorf("sample = %s, want the first report", got.Issues[0].Sample)
+	}
+
+	for _, path := range []string{"/api/issues/bad.service", "/api/issues/svc?sort=random", "/api/issues/svc?limit=0"} {
+		if rr := do(t, h, http.MethodGet, path, nil, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", path, rr.Code)
+		}
+	}
+}
+
+func TestAdminIssueHandler(t *testing.T) {
+	h, pgDB, rec := newTestRouter(t)
+	admin := newRouter(pgDB, routerConfig{Events: sink.NewFanout(rec), AdminToken: "secret"})
+
+	body := `{"type":"deprecation","url":"https://example.com/","body":{"id":"UnloadHandler"}}`
+	if rr := do(t, h, http.MethodPost, "/reporting/svc", strings.NewReader(body), "application/reports+json"); rr.Code != http.StatusNoContent {
+		t.Fatalf("post: status = %d, want 204", rr.Code)
+	}
+	waitForSignal(rec.doneSecurityRpt)
+
+	listIssues := func(query string) []db.Issue {
+		t.Helper()
+		rr := do(t, h, http.MethodGet, "/api/issues/svc"+query, nil, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("list%s: status = %d, want 200", query, rr.Code)
+		}
+		var got struct {
+			Issues []db.Issue `json:"issues"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got.Issues
+	}
+	issues := listIssues("")
+	if len(issues) != 1 || issues[0].Status != db.IssueOpen {
+		t.Fatalf("issues = %+v, want one open issue", issues)
+	}
+	fp := issues[0].Fingerprint
+
+	transition := func(action, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/admin/issues/svc/"+fp+"/"+action, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do(t, admin, http.MethodPost, "/admin/issues/svc/"+fp+"/resolve", nil, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d, want 401", rr.Code)
+	}
+	if rr := transition("resolve", ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"status":"resolved"`) {
+		t.Fatalf("resolve: status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+	if issues := listIssues(""); len(issues) != 0 {
+		t.Errorf("open issues after resolve = %+v, want none", issues)
+	}
+	if issues := listIssues("?status=resolved"); len(issues) != 1 {
+		t.Errorf("resolved issues = %+v, want one", issues)
+	}
+
+	rr := do(t, h, http.MethodGet, "/api/reports/svc", nil, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"recent_reports":[]`) {
+		t.Errorf("reports: status = %d, body = %s; want the resolved report hidden", rr.Code, rr.Body.String())
+	}
+	rr = do(t, h, http.MethodGet, "/api/reports/svc?include_triaged=true", nil, "")
+	if !strings.Contains(rr.Body.String(), "UnloadHandler") {
+		t.Errorf("reports?include_triaged: body = %s, want the resolved report", rr.Body.String())
+	}
+
+	if rr := transition("ignore", `{"for":"24h","events":10}`); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"ignore_until_count":11`) {
+		t.Errorf("ignore: status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+	if rr := transition("reopen", ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"status":"open"`) {
+		t.Errorf("reopen: status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+
+	for _, tc := range []struct {
+		action, body string
+		want         int
+	}{
+		{"ignore", `{"for":"-1h"}`, http.StatusBadRequest},
+		{"ignore", `{"until":"2000-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"ignore", `{"events":-1}`, http.StatusBadRequest},
+		{"ignore", `not json`, http.StatusBadRequest},
+		{"delete", "", http.StatusNotFound},
+	} {
+		if rr := transition(tc.action, tc.body); rr.Code != tc.want {
+			t.Errorf("%s %s: status = %d, want %d", tc.action, tc.body, rr.Code, tc.want)
+		}
+	}
+	fp = "0123456789abcdef0123456789abcdef"
+	if rr := transition("resolve", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown issue: status = %d, want 404", rr.Code)
+	}
+	if rr := do(t, h, http.MethodGet, "/api/issues/svc?status=closed", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("bad status: status = %d, want 400", rr.Code)
+	}
+}
+
+func TestApiVitalPagesHandler(t *testing.T) {
+	h, pgDB, rec := newTestRouter(t)
+
+	for _, tc := range []struct {
+		body    string
+		referer string
+	}{
+		{`{"id":"v1","name":"LCP","value":4000,"page":"https://example.com/posts/12?token=x"}`, ""},
+		{`{"id":"v2","name":"LCP","value":1000}`, "https://example.com/about"},
+		{`{"id":"v3","name":"LCP","value":2000,"page":"/blog/hello","route":"/blog/[slug]"}`, ""},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/analytics/svc", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tc.referer != "" {
+			req.Header.Set("Referer", tc.referer)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("post %s: status = %d, want 204", tc.body, rr.Code)
+		}
+		waitForSignal(rec.doneAnalytics)
+	}
+
+	var vitals []db.WebVital
+	if err := pgDB.Order("vital_id").Find(&vitals).Error; err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	want := []struct{ page, route string }{
+		{"https://example.com/posts/12", "/posts/:id"},
+		{"https://example.com/about", "/about"},
+		{"/blog/hello", "/blog/[slug]"},
+	}
+	if len(vitals) != len(want) {
+		t.Fatalf("got %d vitals, want %d", len(vitals), len(want))
+	}
+	for i, w := range want {
+		if vitals[i].Page != w.page || vitals[i].Route != w.route {
+			t.Errorf("vital %d: page=%q route=%q, want %q %q", i, vitals[i].Page, vitals[i].Route, w.page, w.route)
+		}
+	}
+
+	rr := do(t, h, http.MethodGet, "/api/vitals/svc/pages", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	var got struct {
+		Pages []db.VitalGroupPercentile `json:"pages"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	if len(got.Pages) != 3 || got.Pages[0].Key != "/posts/:id" {
+		t.Errorf("pages = %+v, want /posts/:id first", got.Pages)
+	}
+}
+
+func TestApiBrowsersHandler(t *testing.T) {
+	h, pgDB, rec := newTestRouter(t)
+
+	const (
+		iphoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+		firefoxUA = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.4; rv:125.0) Gecko/20100101 Firefox/125.0"
+	)
+	post := func(path, contentType, body, ua string, done chan struct{}) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User-Agent", ua)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("post %s: status = %d, want 204, body=%s", path, rr.Code, rr.Body.String())
+		}
+		waitForSignal(done)
+	}
+	post("/analytics/svc", "application/json", `{"id":"v1","name":"INP","value":300}`, iphoneUA, rec.doneAnalytics)
+	post("/analytics/svc", "application/json", `{"id":"v2","name":"INP","value":100}`, firefoxUA, rec.doneAnalytics)
+	post("/reporting/svc", "application/csp-report", `{"csp-report":{"document-uri":"https://example.com/","violated-directive":"img-src","blocked-uri":"data"}}`, firefoxUA, rec.doneSecurityRpt)
+
+	var vital db.WebVital
+	if err := pgDB.Where("vital_id = ?", "v1").First(&vital).Error; err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if vital.UserAgent != iphoneUA || vital.BrowserFamily != "safari" || vital.BrowserMajor != 17 || vital.OS != "ios" || vital.DeviceClass != "mobile" {
+		t.Errorf("stored agent = %+v", vital.Agent)
+	}
+
+	rr := do(t, h, http.MethodGet, "/api/vitals/svc/browsers?by=device", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("vitals: status = %d, want 200, body=%s", rr.Code, rr.Body.String())
+	}
+	var vitals struct {
+		By       string                    `json:"by"`
+		Browsers []db.VitalGroupPercentile `json:"browsers"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &vitals); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	if vitals.By != "device" || len(vitals.Browsers) != 2 || vitals.Browsers[0].Key != "desktop" || vitals.Browsers[1].Key != "mobile" || vitals.Browsers[1].Value != 300 {
+		t.Errorf("vitals by device = %+v", vitals)
+	}
+
+	rr = do(t, h, http.MethodGet, "/api/reports/svc/browsers?by=version&directive=img-src", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reports: status = %d, want 200, body=%s", rr.Code, rr.Body.String())
+	}
+	var reports struct {
+		Browsers []db.AgentReportCount `json:"browsers"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &reports); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	if len(reports.Browsers) != 1 || reports.Browsers[0].Key != "firefox 125" || reports.Browsers[0].Share != 1 {
+		t.Errorf("reports by version = %+v", reports.Browsers)
+	}
+
+	for _, path := range []string{"/api/vitals/svc/browsers?by=engine", "/api/reports/svc/browsers?by=engine", "/api/reports/bad.service/browsers"} {
+		if rr := do(t, h, http.MethodGet, path, nil, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", path, rr.Code)
+		}
+	}
+}
+
+func TestSinkSpecList(t *testing.T) {
+	tests := []struct {
+		specs, project string
+		want           []string
+	}{
+		{"", "", nil},
+		{"", "my-project", []string{"bigquery"}},
+		{"stdout", "my-project", []string{"stdout"}},
+		{"file:/tmp/a,webhook:https://example.com", "", []string{"file:/tmp/a", "webhook:https://example.com"}},
+	}
+	for _, tc := range tests {
+		if got := sinkSpecList(tc.specs, tc.project); !slices.Equal(got, tc.want) {
+			t.Errorf("sinkSpecList(%q, %q) = %q, want %q", tc.specs, tc.project, got, tc.want)
+		}
+	}
+}
+
+// TestRouterWithoutSinks covers SQL-only deployments: with no forwarding
+// configured the POST handlers still store rows and succeed.
+func TestRouterWithoutSinks(t *testing.T) {
+	ctx := context.Background()
+	pgDB, err := db.Connect(ctx, "sqlite://"+filepath.Join(t.TempDir(), "reportd.db"))
+	if err != nil {
+		t.Fatalf("db.Connect: %v", err)
+	}
+	if _, err := db.Migrate(ctx, pgDB); err != nil {
+		t.Fatalf("db.Migrate: %v", err)
+	}
+	h := newRouter(pgDB, routerConfig{})
+
+	rr := do(t, h, http.MethodPost, "/analytics/svc", strings.NewReader(`{"id":"v1","name":"LCP","value":1}`), "application/json")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("analytics: status = %d, want 204", rr.Code)
+	}
+	body, err := os.ReadFile(filepath.Join("pkg", "reporting", "examples", "csp.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = do(t, h, http.MethodPost, "/reporting/svc", bytes.NewReader(body), "application/reports+json")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("reporting: status = %d, want 204", rr.Code)
+	}
+
+	var count int64
+	if err := pgDB.Model(&db.WebVital{}).Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("web vitals = %d (err %v), want 1", count, err)
+	}
+}
+
+func TestPostIngestLimits(t *testing.T) {
+	_, pgDB, _ := newTestRouter(t)
+	h := newRouter(pgDB, routerConfig{MaxBodyBytes: 128})
+	body := `{"id":"v1-abc","name":"LCP","value":2500,"delta":100,"label":"web-vital"}`
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/analytics/svc", &gz)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("gzip: status = %d, want 204, body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = do(t, h, http.MethodPost, "/analytics/svc", strings.NewReader(body+strings.Repeat(" ", 128)), "application/json")
+	if rr.Code != http.StatusRequestEntityTooLarge || errorCode(t, rr) != ingest.CodeBodyTooLarge {
+		t.Errorf("oversize: status = %d, body=%s; want 413 %s", rr.Code, rr.Body.String(), ingest.CodeBodyTooLarge)
+	}
+
+	req = httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/reporting/svc", strings.NewReader("[]"))
+	req.Header.Set("Content-Type", "application/reports+json")
+	req.Header.Set("Content-Encoding", "zstd")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnsupportedMediaType || errorCode(t, rr) != ingest.CodeUnsupportedEncoding {
+		t.Errorf("zstd: status = %d, body=%s; want 415 %s", rr.Code, rr.Body.String(), ingest.CodeUnsupportedEncoding)
+	}
+}
+
+func TestPostIngestRateLimit(t *testing.T) {
+	_, pgDB, rec := newTestRouter(t)
+	h := newRouter(pgDB, routerConfig{Events: sink.NewFanout(rec), RateLimits: ingest.RateLimits{
+		Client:         1,
+		ClientBurst:    2,
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}})
+
+	post := func(service, client string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/reporting/"+service, strings.NewReader("[]"))
+		req.Header.Set("Content-Type", "application/reports+json")
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", client)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := range 2 {
+		if rr := post("svc", "203.0.113.1"); rr.Code != http.StatusNoContent {
+			t.Fatalf("request %d: status = %d, body=%s", i, rr.Code, rr.Body.String())
+		}
+	}
+	rr := post("svc", "203.0.113.1")
+	if rr.Code != http.StatusTooManyRequests || errorCode(t, rr) != ingest.CodeRateLimited || rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("over limit: status = %d, Retry-After = %q, body=%s; want 429 after 1s", rr.Code, rr.Header().Get("Retry-After"), rr.Body.String())
+	}
+	if rr := post("svc", "203.0.113.2"); rr.Code != http.StatusNoContent {
+		t.Errorf("other client: status = %d, want 204", rr.Code)
+	}
+	if rr := post("other", "203.0.113.1"); rr.Code != http.StatusNoContent {
+		t.Errorf("other service: status = %d, want 204", rr.Code)
+	}
+
+	// Malformed names are refused before they are throttled, so they
+	// use no tokens.
+	for range 3 {
+		if rr := post("bad.service", "203.0.113.3"); rr.Code != http.StatusBadRequest {
+			t.Errorf("malformed service: status = %d, want 400", rr.Code)
+		}
+	}
+	if rr := post("svc", "203.0.113.3"); rr.Code != http.StatusNoContent {
+		t.Errorf("after malformed names: status = %d, want 204", rr.Code)
+	}
+}
+
+func TestPostIngestRateLimitAcrossServices(t *testing.T) {
+	_, pgDB, rec := newTestRouter(t)
+	h := newRouter(pgDB, routerConfig{Events: sink.NewFanout(rec), RateLimits: ingest.RateLimits{
+		Client:           1,
+		ClientTotal:      1,
+		ClientTotalBurst: 2,
+	}})
+	post := func(service string) int {
+		t.Helper()
+		return do(t, h, http.MethodPost, "/reporting/"+service, strings.NewReader("[]"), "application/reports+json").Code
+	}
+	if one, two := post("one"), post("two"); one != http.StatusNoContent || two != http.StatusNoContent {
+		t.Fatalf("first two services: statuses = %d, %d; want 204", one, two)
+	}
+	if code := post("three"); code != http.StatusTooManyRequests {
+		t.Errorf("third service: status = %d, want 429", code)
+	}
+}
+
 func TestPostReportingHandler(t *testing.T) {
 	h, pgDB, rec := newTestRouter(t)
 
 	rr := do(t, h, http.MethodPost, "/reporting/svc", strings.NewReader("{}"), "application/json")
-	if rr.Code != http.StatusBadRequest {
-		t.Errorf("wrong content-type: status = %d, want 400", rr.Code)
+	if rr.Code != http.StatusUnsupportedMediaType || errorCode(t, rr) != ingest.CodeUnsupportedMediaType {
+		t.Errorf("wrong content-type: status = %d, body=%s; want 415 %s", rr.Code, rr.Body.String(), ingest.CodeUnsupportedMediaType)
 	}
 
 	rr = do(t, h, http.MethodPost, "/reporting/bad.service", strings.NewReader("{}"), "application/reports+json")
@@ -466,8 +1155,8 @@ func TestPostReportingHandler(t *testing.T) {
 	}
 
 	rr = do(t, h, http.MethodPost, "/reporting/svc", strings.NewReader("not json"), "application/reports+json")
-	if rr.Code != http.StatusInternalServerError {
-		t.Errorf("malformed body: status = %d, want 500", rr.Code)
+	if rr.Code != http.StatusBadRequest || errorCode(t, rr) != ingest.CodeMalformedPayload {
+		t.Errorf("malformed body: status = %d, body=%s; want 400 %s", rr.Code, rr.Body.String(), ingest.CodeMalformedPayload)
 	}
 
 	body := `{"type":"csp-violation","url":"https://example.com/","body":{"document_uri":"https://example.com/","blocked_uri":"https://evil.com/","effective_directive":"script-src","line_number":10,"column_number":5}}`
@@ -533,8 +1222,8 @@ func TestPostReportingHandlerLegacyCSP(t *testing.T) {
 
 	// Bodies without the csp-report envelope are rejected, not stored empty.
 	rr = do(t, h, http.MethodPost, "/reporting/svc", strings.NewReader("{}"), "application/csp-report")
-	if rr.Code != http.StatusInternalServerError {
-		t.Errorf("missing csp-report key: status = %d, want 500", rr.Code)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("missing csp-report key: status = %d, want 400", rr.Code)
 	}
 }
 
@@ -551,8 +1240,56 @@ func TestPostReportingHandlerContentTypes(t *testing.T) {
 
 	// Garbage content types are a client error.
 	rr = do(t, h, http.MethodPost, "/reporting/svc", strings.NewReader(body), ";;;")
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("unparseable content-type: status = %d, want 415", rr.Code)
+	}
+}
+
+func TestPostReportingHandlerBatch(t *testing.T) {
+	h, pgDB, rec := newTestRouter(t)
+
+	// Chrome batches deliveries into a single array; one malformed element
+	// must not drop its neighbours.
+	body := `[
+		{"type":"csp-violation","url":"https://example.com/","body":{"blocked_uri":"https://evil.com/","effective_directive":"script-src"}},
+		{"type":42},
+		{"type":"deprecation","url":"https://example.com/","body":{"id":"websql","message":"WebSQL is deprecated"}}
+	]`
+	rr := do(t, h, http.MethodPost, "/reporting/svc", strings.NewReader(body), "application/reports+json")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("batch: status = %d, want 204, body=%s", rr.Code, rr.Body.String())
+	}
+
+	var entries []db.SecurityReportEntry
+	if err := pgDB.Where("service = ?", "svc").Order("id").Find(&entries).Error; err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 security report rows, got %d", len(entries))
+	}
+	if entries[0].ReportType != "csp-violation" || entries[1].ReportType != "deprecation" {
+		t.Errorf("report types = %q, %q", entries[0].ReportType, entries[1].ReportType)
+	}
+	if strings.HasPrefix(entries[0].RawJSON, "[") {
+		t.Errorf("raw_json should hold the element, not the batch: %q", entries[0].RawJSON)
+	}
+
+	for range 2 {
+		if !waitForSignal(rec.doneSecurityRpt) {
+			t.Fatal("expected BQ writer to be invoked once per report")
+		}
+	}
+
+	// A batch with nothing usable is still a failure.
+	rr = do(t, h, http.MethodPost, "/reporting/svc", strings.NewReader(`[1, 2]`), "application/reports+json")
 	if rr.Code != http.StatusBadRequest {
-		t.Errorf("unparseable content-type: status = %d, want 400", rr.Code)
+		t.Errorf("all-bad batch: status = %d, want 400", rr.Code)
+	}
+
+	// Empty batches are accepted and store nothing.
+	rr = do(t, h, http.MethodPost, "/reporting/svc", strings.NewReader(`[]`), "application/reports+json")
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("empty batch: status = %d, want 204", rr.Code)
 	}
 }
 
@@ -589,4 +1326,391 @@ func TestRouteTagMiddleware(t *testing.T) {
 	if rr.Header().Get("reporting-endpoints") == "" {
 		t.Error("reporting-endpoints header should be set on every response")
 	}
+	if rr.Header().Get("nel") == "" {
+		t.Error("nel header should be set on every response")
+	}
+}
+
+func TestRetriesOption(t *testing.T) {
+	for in, want := range map[int]int{0: -1, -3: -1, 1: 1, 5: 5} {
+		if got := retriesOption(in); got != want {
+			t.Errorf("retriesOption(%d) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestAdminServiceHandlers(t *testing.T) {
+	_, pgDB, rec := newTestRouter(t)
+	h := newRouter(pgDB, routerConfig{Events: sink.NewFanout(rec), AdminToken: "secret", RequireRegistered: true})
+
+	admin := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequestWithContext(t.Context(), method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	post := func() *httptest.ResponseRecorder {
+		t.Helper()
+		body := `{"id":"v1-abc","name":"LCP","value":2500,"delta":100,"label":"web-vital","page":"https://example.com/"}`
+		return do(t, h, http.MethodPost, "/analytics/blog", strings.NewReader(body), "application/json")
+	}
+
+	if rr := post(); rr.Code != http.StatusNotFound || errorCode(t, rr) != ingest.CodeUnknownService {
+		t.Errorf("unregistered: status = %d, body=%s; want 404 %s", rr.Code, rr.Body.String(), ingest.CodeUnknownService)
+	}
+	if rr := do(t, h, http.MethodPost, "/analytics/bad.service", strings.NewReader("{}"), "application/json"); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid name: status = %d, want 400", rr.Code)
+	}
+
+	for _, tc := range []struct {
+		name, body string
+		want       int
+	}{
+		{"bad name", `{"name":"bad.service"}`, 400},
+		{"bad origin", `{"name":"blog","allowed_origins":["ftp://example.com"]}`, 400},
+		{"create", `{"name":"blog","display_name":"Blog","owner":"web","allowed_origins":["https://Example.com/","https://example.com"]}`, 201},
+		{"duplicate", `{"name":"blog"}`, 409},
+	} {
+		if rr := admin(http.MethodPost, "/admin/services", tc.body); rr.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d, body=%s", tc.name, rr.Code, tc.want, rr.Body.String())
+		}
+	}
+	if rr := post(); rr.Code != http.StatusNoContent {
+		t.Errorf("registered: status = %d, want 204, body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr := admin(http.MethodPatch, "/admin/services/blog", `{"display_name":"Engineering blog"}`)
+	var got db.Service
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); rr.Code != http.StatusOK || err != nil {
+		t.Fatalf("rename: status = %d, body=%s", rr.Code, rr.Body.String())
+	}
+	if got.DisplayName != "Engineering blog" || got.Owner != "web" || !slices.Equal(got.AllowedOrigins, []string{"https://example.com"}) {
+		t.Errorf("renamed service = %+v", got)
+	}
+	if rr := admin(http.MethodPatch, "/admin/services/blog", `{"name":"other"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("change name: status = %d, want 400", rr.Code)
+	}
+	if rr := admin(http.MethodPatch, "/admin/services/missing", `{"owner":"x"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("update missing: status = %d, want 404", rr.Code)
+	}
+
+	if rr := admin(http.MethodPost, "/admin/services/blog/archive", ""); rr.Code != http.StatusOK {
+		t.Fatalf("archive: status = %d, body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := post(); rr.Code != http.StatusNotFound {
+		t.Errorf("archived: status = %d, want 404", rr.Code)
+	}
+	if rr := do(t, h, http.MethodGet, "/services", nil, ""); strings.Contains(rr.Body.String(), "blog") {
+		t.Errorf("archived service listed: %s", rr.Body.String())
+	}
+	if rr := admin(http.MethodPost, "/admin/services/blog/delete", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown action: status = %d, want 404", rr.Code)
+	}
+
+	if rr := admin(http.MethodPost, "/admin/services/blog/restore", ""); rr.Code != http.StatusOK {
+		t.Fatalf("restore: status = %d, body=%s", rr.Code, rr.Body.String())
+	}
+	rr = admin(http.MethodGet, "/admin/services", "")
+	var list struct {
+		Services []db.Service `json:"services"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list.Services) != 1 || list.Services[0].ArchivedAt != nil {
+		t.Errorf("list: %s (err %v), want one active service", rr.Body.String(), err)
+	}
+}
+
+func TestOriginVerification(t *testing.T) {
+	_, pgDB, rec := newTestRouter(t)
+	s := &db.Service{Name: "blog", AllowedOrigins: db.StringList{"https://example.com", "*.example.org"}}
+	if err := db.CreateService(context.Background(), pgDB, s); err != nil {
+		t.Fatal(err)
+	}
+	h := newRouter(pgDB, routerConfig{Events: sink.NewFanout(rec)})
+
+	post := func(target, contentType, origin, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	csp := func(uri string) string {
+		return `{"csp-report":{"document-uri":"` + uri + `","blocked-uri":"https://evil.com/","violated-directive":"script-src"}}`
+	}
+	vital := `{"id":"v1","name":"LCP","value":100,"page":"https://example.com/"}`
+
+	for _, tc := range []struct {
+		name, target, contentType, origin, body string
+		want                                    int
+	}{
+		{"allowed origin", "/analytics/blog", "application/json", "https://example.com", vital, http.StatusNoContent},
+		{"forged origin", "/analytics/blog", "application/json", "https://evil.com", vital, http.StatusForbidden},
+		{"forged page", "/analytics/blog", "application/json", "", `{"id":"v2","name":"LCP","value":100,"page":"https://evil.com/"}`, http.StatusForbidden},
+		{"no page or origin", "/analytics/blog", "application/json", "", `{"id":"v3","name":"LCP","value":100}`, http.StatusForbidden},
+		{"wildcard subdomain", "/report/blog", "application/csp-report", "", csp("https://www.example.org/"), http.StatusNoContent},
+		{"wildcard apex", "/report/blog", "application/csp-report", "", csp("https://example.org/"), http.StatusForbidden},
+		{"other service", "/report/other", "application/csp-report", "https://evil.com", csp("https://evil.com/"), http.StatusNoContent},
+	} {
+		rr := post(tc.target, tc.contentType, tc.origin, tc.body)
+		if rr.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d, body=%s", tc.name, rr.Code, tc.want, rr.Body.String())
+			continue
+		}
+		if tc.want == http.StatusForbidden && errorCode(t, rr) != ingest.CodeOriginNotAllowed {
+			t.Errorf("%s: code = %q, want %q", tc.name, errorCode(t, rr), ingest.CodeOriginNotAllowed)
+		}
+	}
+
+	// A batch keeps its allowed reports and drops the forged one.
+	batch := `[
+		{"type":"csp-violation","url":"https://example.com/","body":{"blocked_uri":"https://evil.com/","effective_directive":"script-src"}},
+		{"type":"csp-violation","url":"https://evil.com/","body":{"blocked_uri":"https://evil.com/","effective_directive":"script-src"}}
+	]`
+	if rr := post("/reporting/blog", "application/reports+json", "", batch); rr.Code != http.StatusNoContent {
+		t.Fatalf("batch: status = %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var entries []db.SecurityReportEntry
+	if err := pgDB.Where("service = ?", "blog").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].URL != "https://example.com/" {
+		t.Errorf("stored %d reporting entries (%+v), want only the example.com one", len(entries), entries)
+	}
+}
+
+func TestIngestSampling(t *testing.T) {
+	_, pgDB, rec := newTestRouter(t)
+	h := newRouter(pgDB, routerConfig{Events: sink.NewFanout(rec), AdminToken: "secret"})
+	admin := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequestWithContext(t.Context(), method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := admin(http.MethodPost, "/admin/services", `{"name":"blog","sample_rates":{"web-vital":2}}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("rate above 1: status = %d, want 400", rr.Code)
+	}
+	rates := `{"name":"blog","sample_rates":{"web-vital":0.5,"deprecation":0.000001,"*":1}}`
+	if rr := admin(http.MethodPost, "/admin/services", rates); rr.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, body=%s", rr.Code, rr.Body.String())
+	}
+
+	const posted = 40
+	for i := range posted {
+		body := fmt.Sprintf(`{"id":"v%d","name":"LCP","value":100,"page":"https://example.com/"}`, i)
+		if rr := do(t, h, http.MethodPost, "/analytics/blog", strings.NewReader(body), "application/json"); rr.Code != http.StatusNoContent {
+			t.Fatalf("vital %d: status = %d, body=%s", i, rr.Code, rr.Body.String())
+		}
+	}
+	var vitals []db.WebVital
+	if err := pgDB.Where("service = ?", "blog").Find(&vitals).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(vitals) == 0 || len(vitals) == posted {
+		t.Errorf("stored %d of %d vitals at a sample rate of 0.5", len(vitals), posted)
+	}
+	for _, v := range vitals {
+		if v.SampleRate != 0.5 {
+			t.Errorf("vital %s sample_rate = %v, want 0.5", v.VitalID, v.SampleRate)
+		}
+	}
+
+	batch := `[
+		{"type":"deprecation","url":"https://example.com/","body":{"id":"websql"}},
+		{"type":"csp-violation","url":"https://example.com/","body":{"blocked_uri":"https://evil.com/","effective_directive":"script-src"}}
+	]`
+	if rr := do(t, h, http.MethodPost, "/reporting/blog", strings.NewReader(batch), "application/reports+json"); rr.Code != http.StatusNoContent {
+		t.Fatalf("batch: status = %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var entries []db.SecurityReportEntry
+	if err := pgDB.Where("service = ?", "blog").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ReportType != "csp-violation" || entries[0].SampleRate != 1 {
+		t.Errorf("stored %+v, want only the csp-violation at a sample rate of 1", entries)
+	}
+}
+
+func TestNoiseFiltering(t *testing.T) {
+	_, pgDB, rec := newTestRouter(t)
+	h := newRouter(pgDB, routerConfig{Events: sink.NewFanout(rec), AdminToken: "secret", NoiseRules: noise.Defaults()})
+	admin := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequestWithContext(t.Context(), method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := admin(http.MethodPost, "/admin/services", `{"name":"blog","noise_rules":[{"name":"everything","action":"drop"}]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("rule without patterns: status = %d, want 400", rr.Code)
+	}
+	rules := `{"name":"blog","noise_rules":[
+		{"name":"own-extension","action":"keep","blocked_uri":["chrome-extension://ourid/*"]},
+		{"name":"tag-manager","action":"tag","blocked_uri":["https://www.googletagmanager.com/*"]}
+	]}`
+	if rr := admin(http.MethodPost, "/admin/services", rules); rr.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, body=%s", rr.Code, rr.Body.String())
+	}
+
+	// A report made up only of noise is accepted but neither stored nor
+	// forwarded.
+	legacy := `{"csp-report":{"document-uri":"https://example.com/","effective-directive":"script-src-elem","blocked-uri":"chrome-extension://abcdef/inject.js"}}`
+	if rr := do(t, h, http.MethodPost, "/report/blog", strings.NewReader(legacy), "application/csp-report"); rr.Code != http.StatusNoContent {
+		t.Fatalf("legacy: status = %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var stored int64
+	if err := pgDB.Model(&db.ReportToEntry{}).Where("service = ?", "blog").Count(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Errorf("stored %d extension reports, want 0", stored)
+	}
+
+	batch := `[
+		{"type":"csp-violation","url":"https://example.com/","body":{"blockedURL":"about:blank","effectiveDirective":"frame-src"}},
+		{"type":"csp-violation","url":"https://example.com/","body":{"blockedURL":"inline","effectiveDirective":"script-src-elem","sourceFile":"moz-extension://1234/content.js"}},
+		{"type":"csp-violation","url":"https://example.com/","body":{"blockedURL":"chrome-extension://ourid/widget.js","effectiveDirective":"script-src-elem"}},
+		{"type":"csp-violation","url":"https://example.com/","body":{"blockedURL":"https://www.googletagmanager.com/gtm.js","effectiveDirective":"script-src-elem"}}
+	]`
+	if rr := do(t, h, http.MethodPost, "/report/blog", strings.NewReader(batch), "application/reports+json"); rr.Code != http.StatusNoContent {
+		t.Fatalf("batch: status = %d, body=%s", rr.Code, rr.Body.String())
+	}
+	waitForSignal(rec.doneReport)
+	var entries []db.ReportToEntry
+	if err := pgDB.Where("service = ?", "blog").Order("blocked_uri").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("stored %+v, want the kept extension and the tagged tag manager reports", entries)
+	}
+	if e := entries[0]; e.BlockedURI != "chrome-extension://ourid/widget.js" || e.NoiseRule != "" {
+		t.Errorf("kept report = %+v", e)
+	}
+	if e := entries[1]; e.BlockedURI != "https://www.googletagmanager.com/gtm.js" || e.NoiseRule != "tag-manager" {
+		t.Errorf("tagged report = %+v", e)
+	}
+	rec.mu.Lock()
+	forwarded := len(rec.reports[0].ReportTo)
+	rec.mu.Unlock()
+	if forwarded != 2 {
+		t.Errorf("forwarded %d reports, want 2", forwarded)
+	}
+
+	reporting := `[
+		{"type":"csp-violation","url":"https://example.com/","body":{"blocked_uri":"safari-web-extension://x/y.js","effective_directive":"script-src-elem"}},
+		{"type":"csp-violation","url":"https://example.com/","body":{"blocked_uri":"https://evil.com/x.js","effective_directive":"script-src-elem"}}
+	]`
+	if rr := do(t, h, http.MethodPost, "/reporting/blog", strings.NewReader(reporting), "application/reports+json"); rr.Code != http.StatusNoContent {
+		t.Fatalf("reporting: status = %d, body=%s", rr.Code, rr.Body.String())
+	}
+	waitForSignal(rec.doneSecurityRpt)
+	var security []db.SecurityReportEntry
+	if err := pgDB.Where("service = ?", "blog").Find(&security).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(security) != 1 || security[0].BlockedURI != "https://evil.com/x.js" {
+		t.Errorf("stored %+v, want only the evil.com report", security)
+	}
+}
+
+func TestAdminSpoolHandler(t *testing.T) {
+	ctx := context.Background()
+	pgDB, err := db.Connect(ctx, "sqlite://"+filepath.Join(t.TempDir(), "reportd.db"))
+	if err != nil {
+		t.Fatalf("db.Connect: %v", err)
+	}
+
+	// Without a token the admin API is not mounted.
+	rr := do(t, newRouter(pgDB, routerConfig{}), http.MethodGet, "/admin/spool", nil, "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("no token: status = %d, want 404", rr.Code)
+	}
+
+	events := sink.NewFanout(newRecordingSink())
+	if err := events.EnableSpool(sink.SpoolOptions{Dir: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = events.Close(context.Background()) })
+	h := newRouter(pgDB, routerConfig{Events: events, AdminToken: "secret"})
+
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/admin/spool", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", auth, rr.Code)
+		}
+	}
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/admin/spool", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	var got struct {
+		Records int64             `json:"records"`
+		Spools  []sink.SpoolStats `json:"spools"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Records != 0 || len(got.Spools) != 1 || got.Spools[0].Sink != "recording" {
+		t.Errorf("spool stats = %+v, want one empty recording spool", got)
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	ctx := context.Background()
+	pgDB, err := db.Connect(ctx, "sqlite://"+filepath.Join(t.TempDir(), "reportd.db"))
+	if err != nil {
+		t.Fatalf("db.Connect: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := migrateCommand(ctx, pgDB, []string{"status"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "0001_baseline\tpending") {
+		t.Errorf("status before up = %q, want baseline pending", out.String())
+	}
+
+	out.Reset()
+	if err := migrateCommand(ctx, pgDB, []string{"up"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "applied 0001_baseline") {
+		t.Errorf("up = %q, want baseline applied", out.String())
+	}
+
+	out.Reset()
+	if err := migrateCommand(ctx, pgDB, []string{"up"}, &out); err != nil || out.String() != "schema is up to date\n" {
+		t.Errorf("second up = %q, %v", out.String(), err)
+	}
+
+	out.Reset()
+	if err := migrateCommand(ctx, pgDB, []string{"status"}, &out); err != nil || strings.Contains(out.String(), "pending") {
+		t.Errorf("status after up = %q, %v; want nothing pending", out.String(), err)
+	}
+
+	for _, args := range [][]string{nil, {"down"}, {"up", "extra"}} {
+		if err := migrateCommand(ctx, pgDB, args, &out); err == nil {
+			t.Errorf("migrate %v: expected error", args)
+		}
+	}
 }
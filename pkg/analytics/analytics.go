@@ -9,6 +9,8 @@ import (
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
+
+	"github.com/icco/reportd/pkg/useragent"
 )
 
 // WebVital is a a version of https://web.dev/vitals/.
@@ -34,6 +36,29 @@ type WebVital struct {
 	// Type of metric (web-vital or custom).
 	Label bigquery.NullString `json:"label"`
 
+	// good, needs-improvement or poor. Filled from Google's thresholds by
+	// ParseAnalytics when the client omits it.
+	Rating bigquery.NullString `json:"rating"`
+
+	// How the page was reached: navigate, reload, back-forward,
+	// back-forward-cache, prerender or restore.
+	NavigationType bigquery.NullString `json:"navigationType"`
+
+	// Diagnostic detail sent by the web-vitals attribution build.
+	Attribution *Attribution `json:"attribution" bigquery:",nullable"`
+
+	// The page the metric was measured on, without query or fragment.
+	// Falls back to the beacon's Referer header when the client omits it.
+	Page bigquery.NullString `json:"page"`
+
+	// Page as a route template such as /posts/:id. Clients that know their
+	// router's template may send it; otherwise it is derived from Page.
+	Route bigquery.NullString `json:"route"`
+
+	// The browser that sent the beacon, parsed from its User-Agent
+	// header by the handler; any value in the body is replaced.
+	Agent *useragent.Agent `json:"agent,omitempty" bigquery:",nullable"`
+
 	// When we recorded this metric.
 	Time bigquery.NullDateTime
 
@@ -41,6 +66,100 @@ type WebVital struct {
 	Service bigquery.NullString
 }
 
+// Attribution is the union of the per-metric attribution objects sent by
+// the web-vitals attribution build; only the fields for Name are set.
+//
+// See https://github.com/GoogleChrome/web-vitals#attribution.
+type Attribution struct {
+	// LCP. web-vitals v5 renamed element to target.
+	Element              string  `json:"element,omitempty"`
+	LCPTarget            string  `json:"target,omitempty"`
+	URL                  string  `json:"url,omitempty"`
+	TimeToFirstByte      float64 `json:"timeToFirstByte,omitempty"`
+	ResourceLoadDelay    float64 `json:"resourceLoadDelay,omitempty"`
+	ResourceLoadDuration float64 `json:"resourceLoadDuration,omitempty"`
+	ElementRenderDelay   float64 `json:"elementRenderDelay,omitempty"`
+
+	// INP.
+	InteractionTarget  string  `json:"interactionTarget,omitempty"`
+	InteractionType    string  `json:"interactionType,omitempty"`
+	InteractionTime    float64 `json:"interactionTime,omitempty"`
+	InputDelay         float64 `json:"inputDelay,omitempty"`
+	ProcessingDuration float64 `json:"processingDuration,omitempty"`
+	PresentationDelay  float64 `json:"presentationDelay,omitempty"`
+
+	// CLS.
+	LargestShiftTarget string  `json:"largestShiftTarget,omitempty"`
+	LargestShiftTime   float64 `json:"largestShiftTime,omitempty"`
+	LargestShiftValue  float64 `json:"largestShiftValue,omitempty"`
+
+	// FCP.
+	FirstByteToFCP float64 `json:"firstByteToFCP,omitempty"`
+
+	// TTFB phase breakdown.
+	WaitingDuration    float64 `json:"waitingDuration,omitempty"`
+	CacheDuration      float64 `json:"cacheDuration,omitempty"`
+	DNSDuration        float64 `json:"dnsDuration,omitempty"`
+	ConnectionDuration float64 `json:"connectionDuration,omitempty"`
+	RequestDuration    float64 `json:"requestDuration,omitempty"`
+
+	// CLS, FCP and INP.
+	LoadState string `json:"loadState,omitempty"`
+}
+
+// Target returns the element blamed for the metric: the LCP element, the
+// INP interaction target or the CLS largest-shift target.
+func (a *Attribution) Target() string {
+	if a == nil {
+		return ""
+	}
+	switch {
+	case a.Element != "":
+		return a.Element
+	case a.LCPTarget != "":
+		return a.LCPTarget
+	case a.InteractionTarget != "":
+		return a.InteractionTarget
+	default:
+		return a.LargestShiftTarget
+	}
+}
+
+// Ratings.
+const (
+	RatingGood             = "good"
+	RatingNeedsImprovement = "needs-improvement"
+	RatingPoor             = "poor"
+)
+
+// thresholds are the (good, poor) boundaries for each Core Web Vital.
+//
+// See https://web.dev/articles/defining-core-web-vitals-thresholds.
+var thresholds = map[string][2]float64{
+	"CLS":  {0.1, 0.25},
+	"FCP":  {1800, 3000},
+	"FID":  {100, 300},
+	"INP":  {200, 500},
+	"LCP":  {2500, 4000},
+	"TTFB": {800, 1800},
+}
+
+// Rate returns the rating of value for the metric name, or "" for
+// metrics without published thresholds.
+func Rate(name string, value float64) string {
+	t, ok := thresholds[name]
+	switch {
+	case !ok:
+		return ""
+	case value <= t[0]:
+		return RatingGood
+	case value <= t[1]:
+		return RatingNeedsImprovement
+	default:
+		return RatingPoor
+	}
+}
+
 // Validate returns an error if Service is unset or empty.
 func (wv *WebVital) Validate() error {
 	if !wv.Service.Valid {
@@ -62,6 +181,12 @@ func ParseAnalytics(body, service string) (*WebVital, error) {
 		return nil, fmt.Errorf("could not unmarshal: %w", err)
 	}
 
+	if !data.Rating.Valid || data.Rating.StringVal == "" {
+		if r := Rate(data.Name, data.Value); r != "" {
+			data.Rating = bigquery.NullString{StringVal: r, Valid: true}
+		}
+	}
+
 	data.Time = bigquery.NullDateTime{DateTime: now, Valid: true}
 	data.Service = bigquery.NullString{StringVal: service, Valid: true}
 
@@ -109,9 +234,24 @@ func WriteAnalyticsToBigQuery(ctx context.Context, project, dataset, table strin
 	if err != nil {
 		return fmt.Errorf("connecting to bq: %w", err)
 	}
+	defer func() { _ = client.Close() }()
 
-	t := client.Dataset(dataset).Table(table)
-	ins := t.Inserter()
+	return InsertAnalytics(ctx, client, dataset, table, data)
+}
+
+// InsertAnalytics validates and streams data into dataset.table using an
+// existing client, so long-lived callers can reuse one connection. Rows
+// the table rejects are skipped and listed in a bigquery.PutMultiError;
+// the rest are still inserted.
+func InsertAnalytics(ctx context.Context, client *bigquery.Client, dataset, table string, data []*WebVital) error {
+	for _, d := range data {
+		if err := d.Validate(); err != nil {
+			return fmt.Errorf("validating data: %w", err)
+		}
+	}
+
+	ins := client.Dataset(dataset).Table(table).Inserter()
+	ins.SkipInvalidRows = true
 	if err := ins.Put(ctx, data); err != nil {
 		return fmt.Errorf("uploading to bq: %w", err)
 	}
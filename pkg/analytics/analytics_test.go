@@ -285,3 +285,83 @@ func TestWebVitalValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestParseAnalyticsAttribution(t *testing.T) {
+	body, err := os.ReadFile(filepath.Join("examples", "lcp-attribution.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ParseAnalytics(string(body), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Rating.StringVal != RatingNeedsImprovement {
+		t.Errorf("Rating = %q, want %q", data.Rating.StringVal, RatingNeedsImprovement)
+	}
+	if data.NavigationType.StringVal != "navigate" {
+		t.Errorf("NavigationType = %q, want navigate", data.NavigationType.StringVal)
+	}
+	if got := data.Attribution.Target(); got != "#hero > img.cover" {
+		t.Errorf("Target() = %q, want #hero > img.cover", got)
+	}
+	if data.Attribution.ResourceLoadDuration != 1840.2 {
+		t.Errorf("ResourceLoadDuration = %v, want 1840.2", data.Attribution.ResourceLoadDuration)
+	}
+
+	body, err = os.ReadFile(filepath.Join("examples", "inp-attribution.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ParseAnalytics(string(body), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The example omits rating, so it is derived from the INP thresholds.
+	if data.Rating.StringVal != RatingNeedsImprovement {
+		t.Errorf("derived Rating = %q, want %q", data.Rating.StringVal, RatingNeedsImprovement)
+	}
+	if got := data.Attribution.Target(); got != "button.menu-toggle" {
+		t.Errorf("Target() = %q, want button.menu-toggle", got)
+	}
+}
+
+func TestRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		want  string
+	}{
+		{"LCP", 2500, RatingGood},
+		{"LCP", 2501, RatingNeedsImprovement},
+		{"LCP", 4001, RatingPoor},
+		{"CLS", 0.05, RatingGood},
+		{"CLS", 0.3, RatingPoor},
+		{"INP", 350, RatingNeedsImprovement},
+		{"Next.js-hydration", 38, ""},
+	}
+	for _, tc := range tests {
+		if got := Rate(tc.name, tc.value); got != tc.want {
+			t.Errorf("Rate(%q, %v) = %q, want %q", tc.name, tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestAttributionTargetV5(t *testing.T) {
+	data, err := ParseAnalytics(`{"name":"LCP","value":900,"attribution":{"target":"main > img"}}`, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := data.Attribution.Target(); got != "main > img" {
+		t.Errorf("Target() = %q, want main > img", got)
+	}
+	if data.Rating.StringVal != RatingGood {
+		t.Errorf("derived Rating = %q, want %q", data.Rating.StringVal, RatingGood)
+	}
+}
+
+func TestAttributionTargetNil(t *testing.T) {
+	var a *Attribution
+	if got := a.Target(); got != "" {
+		t.Errorf("nil Target() = %q, want empty", got)
+	}
+}
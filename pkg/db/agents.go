@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// AgentDimension is the user agent attribute a breakdown groups by.
+type AgentDimension string
+
+// Supported dimensions.
+const (
+	AgentFamily  AgentDimension = "family"
+	AgentVersion AgentDimension = "version"
+	AgentOS      AgentDimension = "os"
+	AgentDevice  AgentDimension = "device"
+)
+
+// DefaultAgentDimension is the dimension used when none is given.
+const DefaultAgentDimension = AgentFamily
+
+// ParseAgentDimension accepts "family", "version", "os" or "device";
+// empty means DefaultAgentDimension.
+func ParseAgentDimension(s string) (AgentDimension, error) {
+	switch a := AgentDimension(strings.ToLower(strings.TrimSpace(s))); a {
+	case "":
+		return DefaultAgentDimension, nil
+	case AgentFamily, AgentVersion, AgentOS, AgentDevice:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unsupported breakdown %q: want family, version, os or device", s)
+	}
+}
+
+// expr is the SQL grouping key for a. Versions read as "firefox 125", or
+// the bare family when the major version is unknown.
+func (a AgentDimension) expr() string {
+	switch a {
+	case AgentVersion:
+		return "CASE WHEN browser_major > 0 THEN browser_family || ' ' || CAST(browser_major AS TEXT) ELSE browser_family END"
+	case AgentOS:
+		return "os"
+	case AgentDevice:
+		return "device_class"
+	default:
+		return "browser_family"
+	}
+}
+
+// agentWhere matches service's rows in r whose user agent was parsed.
+const agentWhere = "service = ? AND created_at >= ? AND created_at < ? AND browser_family != ''"
+
+// GetWebVitalsByAgent returns metric percentiles for service keyed by
+// dim over r, by default the trailing 28 days, so a regression can be
+// pinned to one browser. Rows stored without a user agent are skipped.
+func GetWebVitalsByAgent(ctx context.Context, d *gorm.DB, service string, p Percentile, dim AgentDimension, r TimeRange) ([]VitalGroupPercentile, error) {
+	r = r.window(0, 0, -28)
+	results, err := groupedVitalPercentiles(ctx, d, p, dim.expr(), agentWhere, service, r.From, r.To)
+	if err != nil {
+		return nil, fmt.Errorf("querying web vitals by %s: %w", dim, err)
+	}
+	return results, nil
+}
+
+// AgentReportFilter narrows GetReportsByAgent. Directive matches the
+// violated directive, falling back to the effective one. Empty fields
+// match everything.
+type AgentReportFilter struct {
+	ReportType string
+	Directive  string
+}
+
+// AgentReportCount is how many reports of one type came from one
+// browser, version, OS or device, and their share of that type.
+type AgentReportCount struct {
+	ReportType string  `json:"report_type"`
+	Key        string  `json:"key"`
+	Count      int64   `json:"count"`
+	Share      float64 `json:"share"`
+}
+
+// GetReportsByAgent returns report counts for service keyed by report
+// type and dim over r, by default the trailing month, merged across both
+// ingestion tables. Results are ordered by type, then most reports first.
+func GetReportsByAgent(ctx context.Context, d *gorm.DB, service string, dim AgentDimension, f AgentReportFilter, r TimeRange) ([]AgentReportCount, error) {
+	r = r.window(0, -1, 0)
+	key := dim.expr()
+
+	merged := map[[2]string]int64{}
+	for _, model := range []any{&ReportToEntry{}, &SecurityReportEntry{}} {
+		q := d.WithContext(ctx).
+			Model(model).
+			Select("report_type, "+key+" AS key, "+sampledCount+" AS count").
+			Where(agentWhere, service, r.From, r.To)
+		if f.ReportType != "" {
+			q = q.Where("report_type = ?", f.ReportType)
+		}
+		if f.Directive != "" {
+			q = q.Where(directiveExpr+" = ?", f.Directive)
+		}
+		var rows []AgentReportCount
+		if err := q.Group("report_type, " + key).Find(&rows).Error; err != nil {
+			return nil, fmt.Errorf("querying reports by %s: %w", dim, err)
+		}
+		for _, row := range rows {
+			merged[[2]string{row.ReportType, row.Key}] += row.Count
+		}
+	}
+
+	totals := map[string]int64{}
+	results := make([]AgentReportCount, 0, len(merged))
+	for k, count := range merged {
+		totals[k[0]] += count
+		results = append(results, AgentReportCount{ReportType: k[0], Key: k[1], Count: count})
+	}
+	for i := range results {
+		results[i].Share = float64(results[i].Count) / float64(totals[results[i].ReportType])
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.ReportType != b.ReportType {
+			return a.ReportType < b.ReportType
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Key < b.Key
+	})
+	return results, nil
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestParseAgentDimension(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    AgentDimension
+		wantErr bool
+	}{
+		{"", AgentFamily, false},
+		{"family", AgentFamily, false},
+		{" Version ", AgentVersion, false},
+		{"os", AgentOS, false},
+		{"device", AgentDevice, false},
+		{"browser", "", true},
+	} {
+		got, err := ParseAgentDimension(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ParseAgentDimension(%q) = %q, %v; want %q, error %v", tc.in, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+// agentBase is when the user agent breakdown fixtures were received.
+var agentBase = time.Date(2025, 8, 1, 12, 0, 0, 0, time.UTC)
+
+// seedAgentFixtures stores LCP samples from Chrome on Linux and Safari on
+// macOS, one without a user agent, and four reports:
+//
+//	report-to  csp-violation  script-src-elem  chrome
+//	reporting  csp-violation  script-src-elem  chrome
+//	reporting  csp-violation  img-src          firefox
+//	reporting  deprecation                     safari
+func seedAgentFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, v := range []struct {
+		ua    string
+		value float64
+	}{
+		{chromeUA, 100},
+		{chromeUA, 200},
+		{safariUA, 300},
+		{"", 999},
+	} {
+		wv := &WebVital{CreatedAt: agentBase, Service: service, Name: "LCP", Value: v.value, Agent: agentColumns(v.ua)}
+		if err := d.Create(wv).Error; err != nil {
+			t.Fatalf("seed web vital: %v", err)
+		}
+	}
+
+	rt := []*ReportToEntry{{
+		CreatedAt:         agentBase,
+		Service:           service,
+		ReportType:        reportTypeCSPViolation,
+		ViolatedDirective: "script-src-elem",
+		Agent:             Agent{UserAgent: chromeUA},
+		RawJSON:           "{}",
+	}}
+	if err := SaveReportToEntries(ctx, d, rt); err != nil {
+		t.Fatalf("SaveReportToEntries: %v", err)
+	}
+
+	sr := []*SecurityReportEntry{
+		{CreatedAt: agentBase, Service: service, ReportType: reportTypeCSPViolation, EffectiveDirective: "script-src-elem", Agent: Agent{UserAgent: chromeUA}, RawJSON: "{}"},
+		{CreatedAt: agentBase, Service: service, ReportType: reportTypeCSPViolation, EffectiveDirective: "img-src", Agent: Agent{UserAgent: firefoxUA}, RawJSON: "{}"},
+		{CreatedAt: agentBase, Service: service, ReportType: "deprecation", Agent: Agent{UserAgent: safariUA}, RawJSON: "{}"},
+	}
+	for _, e := range sr {
+		e.setIssue(namedIssueKey(e.ReportType, e.EffectiveDirective, ""))
+	}
+	if err := SaveSecurityReportEntries(ctx, d, sr); err != nil {
+		t.Fatalf("SaveSecurityReportEntries: %v", err)
+	}
+}
+
+func assertAgentBreakdowns(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	r := TimeRange{From: agentBase, To: agentBase.Add(time.Hour)}
+
+	vitals := func(dim AgentDimension) string {
+		t.Helper()
+		got, err := GetWebVitalsByAgent(ctx, d, service, P50, dim, r)
+		if err != nil {
+			t.Fatalf("GetWebVitalsByAgent(%s) error = %v", dim, err)
+		}
+		return fmt.Sprint(got)
+	}
+	if got, want := vitals(AgentFamily), "[{LCP chrome 2 150} {LCP safari 1 300}]"; got != want {
+		t.Errorf("GetWebVitalsByAgent(family) = %s, want %s", got, want)
+	}
+	if got, want := vitals(AgentVersion), "[{LCP chrome 124 2 150} {LCP safari 17 1 300}]"; got != want {
+		t.Errorf("GetWebVitalsByAgent(version) = %s, want %s", got, want)
+	}
+	if got, want := vitals(AgentOS), "[{LCP linux 2 150} {LCP macos 1 300}]"; got != want {
+		t.Errorf("GetWebVitalsByAgent(os) = %s, want %s", got, want)
+	}
+
+	reports := func(dim AgentDimension, f AgentReportFilter) string {
+		t.Helper()
+		got, err := GetReportsByAgent(ctx, d, service, dim, f, r)
+		if err != nil {
+			t.Fatalf("GetReportsByAgent(%s) error = %v", dim, err)
+		}
+		var out []string
+		for _, c := range got {
+			out = append(out, fmt.Sprintf("%s %s %d %.2f", c.ReportType, c.Key, c.Count, c.Share))
+		}
+		return strings.Join(out, ", ")
+	}
+	for _, tc := range []struct {
+		dim  AgentDimension
+		f    AgentReportFilter
+		want string
+	}{
+		{AgentFamily, AgentReportFilter{}, "csp-violation chrome 2 0.67, csp-violation firefox 1 0.33, deprecation safari 1 1.00"},
+		{AgentVersion, AgentReportFilter{ReportType: "deprecation"}, "deprecation safari 17 1 1.00"},
+		{AgentDevice, AgentReportFilter{Directive: "script-src-elem"}, "csp-violation desktop 2 1.00"},
+	} {
+		if got := reports(tc.dim, tc.f); got != tc.want {
+			t.Errorf("GetReportsByAgent(%s, %+v) = %s, want %s", tc.dim, tc.f, got, tc.want)
+		}
+	}
+}
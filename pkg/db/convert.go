@@ -1,65 +1,97 @@
 package db
 
 import (
+	"cmp"
 	"encoding/json"
+	"fmt"
 	"time"
 
+	"cloud.google.com/go/bigquery"
+
 	"github.com/icco/reportd/pkg/analytics"
+	"github.com/icco/reportd/pkg/noise"
 	"github.com/icco/reportd/pkg/reporting"
 	"github.com/icco/reportd/pkg/reportto"
+	"github.com/icco/reportd/pkg/useragent"
 )
 
 const (
-	reportTypeCSP          = "csp"           // legacy Report-To CSP type
-	reportTypeCSPViolation = "csp-violation" // Reporting API v1 CSP type
+	reportTypeCSP          = reportto.TypeCSP // legacy Report-To CSP type
+	reportTypeCSPViolation = "csp-violation"  // Reporting API v1 CSP type
+	reportTypeNEL          = "network-error"  // Network Error Logging, both APIs
 )
 
 // WebVitalFromAnalytics converts an analytics.WebVital to its DB row.
 func WebVitalFromAnalytics(wv *analytics.WebVital) *WebVital {
-	return &WebVital{
-		CreatedAt: time.Now(),
-		Service:   wv.Service.StringVal,
-		Name:      wv.Name,
-		Value:     wv.Value,
-		Delta:     wv.Delta,
-		VitalID:   wv.ID,
-		Label:     wv.Label.StringVal,
+	row := &WebVital{
+		CreatedAt:      time.Now(),
+		Service:        wv.Service.StringVal,
+		Name:           wv.Name,
+		Value:          wv.Value,
+		Delta:          wv.Delta,
+		VitalID:        wv.ID,
+		Label:          wv.Label.StringVal,
+		Rating:         wv.Rating.StringVal,
+		NavigationType: wv.NavigationType.StringVal,
+		Page:           wv.Page.StringVal,
+		Route:          wv.Route.StringVal,
+		Agent:          agentColumns(rawAgent(wv.Agent)),
+	}
+	if a := wv.Attribution; a != nil {
+		raw, _ := json.Marshal(a)
+		row.AttributionTarget = a.Target()
+		row.AttributionURL = a.URL
+		row.LoadState = a.LoadState
+		row.AttributionJSON = string(raw)
 	}
+	return row
 }
 
 // ReportToEntriesFromReport flattens r into ReportToEntry rows: one for
 // CSP/Expect-CT, one per item for Reporting-API arrays.
 func ReportToEntriesFromReport(r *reportto.Report) []*ReportToEntry {
 	now := time.Now()
+	received := civilTime(r.Time, now)
 	srv := r.Service.StringVal
 
 	if r.CSP != nil {
 		raw, _ := json.Marshal(r)
-		return []*ReportToEntry{{
+		c := r.CSP.CSPReport
+		entry := &ReportToEntry{
 			CreatedAt:          now,
+			ReceivedAt:         received,
+			OccurredAt:         received,
 			Service:            srv,
 			ReportType:         reportTypeCSP,
-			DocumentURI:        r.CSP.CSPReport.DocumentURI,
-			BlockedURI:         r.CSP.CSPReport.BlockedURI,
-			ViolatedDirective:  r.CSP.CSPReport.ViolatedDirective,
-			EffectiveDirective: r.CSP.CSPReport.EffectiveDirective,
-			OriginalPolicy:     r.CSP.CSPReport.OriginalPolicy,
-			SourceFile:         r.CSP.CSPReport.SourceFile,
-			LineNumber:         r.CSP.CSPReport.LineNumber,
-			ColumnNumber:       r.CSP.CSPReport.ColumnNumber,
-			StatusCode:         r.CSP.CSPReport.StatusCode,
+			DocumentURI:        c.DocumentURI,
+			BlockedURI:         c.BlockedURI,
+			ViolatedDirective:  c.ViolatedDirective,
+			EffectiveDirective: c.EffectiveDirective,
+			OriginalPolicy:     c.OriginalPolicy,
+			SourceFile:         c.SourceFile,
+			LineNumber:         c.LineNumber,
+			ColumnNumber:       c.ColumnNumber,
+			StatusCode:         c.StatusCode,
+			Agent:              Agent{UserAgent: rawAgent(r.Agent)},
 			RawJSON:            string(raw),
-		}}
+		}
+		entry.setIssue(cspIssueKey(c.ViolatedDirective, c.EffectiveDirective, c.BlockedURI, c.SourceFile))
+		return []*ReportToEntry{entry}
 	}
 
 	if r.ExpectCT != nil {
 		raw, _ := json.Marshal(r)
-		return []*ReportToEntry{{
+		entry := &ReportToEntry{
 			CreatedAt:  now,
+			ReceivedAt: received,
+			OccurredAt: received,
 			Service:    srv,
-			ReportType: "expect-ct",
+			ReportType: reportto.TypeExpectCT,
+			Agent:      Agent{UserAgent: rawAgent(r.Agent)},
 			RawJSON:    string(raw),
-		}}
+		}
+		entry.setIssue(namedIssueKey(entry.ReportType, r.ExpectCT.ExpectCTReport.Hostname, ""))
+		return []*ReportToEntry{entry}
 	}
 
 	var entries []*ReportToEntry
@@ -67,6 +99,8 @@ func ReportToEntriesFromReport(r *reportto.Report) []*ReportToEntry {
 		raw, _ := json.Marshal(rt)
 		entry := &ReportToEntry{
 			CreatedAt:          now,
+			ReceivedAt:         received,
+			OccurredAt:         civilTime(rt.OccurredAt, received),
 			Service:            srv,
 			ReportType:         rt.Type,
 			DocumentURI:        rt.Body.DocumentURL,
@@ -77,6 +111,7 @@ func ReportToEntriesFromReport(r *reportto.Report) []*ReportToEntry {
 			LineNumber:         int(rt.Body.LineNumber),
 			ColumnNumber:       int(rt.Body.ColumnNumber),
 			StatusCode:         int(rt.Body.StatusCode),
+			Agent:              Agent{UserAgent: cmp.Or(rt.UserAgent, rawAgent(r.Agent))},
 			RawJSON:            string(raw),
 		}
 		if rt.Body.Directive != "" {
@@ -85,18 +120,47 @@ func ReportToEntriesFromReport(r *reportto.Report) []*ReportToEntry {
 		if rt.URL != "" {
 			entry.DocumentURI = rt.URL
 		}
+		if rt.Type == reportTypeNEL {
+			entry.Phase = rt.Body.Phase
+			entry.NELType = rt.Body.Type
+			entry.ServerIP = rt.Body.ServerIP
+			entry.Protocol = rt.Body.Protocol
+			entry.Method = rt.Body.Method
+			entry.ElapsedTime = rt.Body.ElapsedTime
+			entry.SamplingFraction = rt.Body.SamplingFraction
+		}
+		entry.setIssue(reportToIssueKey(rt, entry))
 		entries = append(entries, entry)
 	}
 	return entries
 }
 
+// reportToIssueKey picks the fields that identify rt's problem.
+func reportToIssueKey(rt *reportto.Entry, entry *ReportToEntry) issueKey {
+	switch rt.Type {
+	case reportTypeCSPViolation:
+		return cspIssueKey(entry.ViolatedDirective, entry.EffectiveDirective, entry.BlockedURI, entry.SourceFile)
+	case reportTypeNEL:
+		return nelIssueKey(rt.Body.Type, rt.Body.Phase, entry.DocumentURI)
+	case "crash":
+		return namedIssueKey(rt.Type, rt.Body.Reason, "")
+	default:
+		return namedIssueKey(rt.Type, rt.Body.ID, rt.Body.Message)
+	}
+}
+
 // SecurityReportEntryFromReport projects sr into a SecurityReportEntry;
 // whichever typed body is set drives which fields are populated.
 func SecurityReportEntryFromReport(sr *reporting.SecurityReport) *SecurityReportEntry {
+	now := time.Now()
+	received := civilTime(sr.Time, now)
 	entry := &SecurityReportEntry{
-		CreatedAt:  time.Now(),
+		CreatedAt:  now,
+		ReceivedAt: received,
+		OccurredAt: civilTime(sr.OccurredAt, received),
 		Service:    sr.Service.StringVal,
 		ReportType: sr.ReportType.StringVal,
+		Agent:      Agent{UserAgent: rawAgent(sr.Agent)},
 		RawJSON:    sr.RawJSON,
 	}
 
@@ -144,7 +208,117 @@ func SecurityReportEntryFromReport(sr *reporting.SecurityReport) *SecurityReport
 		entry.SourceFile = sr.DocumentPolicy.Body.SourceFile
 		entry.LineNumber = int(sr.DocumentPolicy.Body.LineNumber)
 		entry.ColumnNumber = int(sr.DocumentPolicy.Body.ColumnNumber)
+	case sr.NEL != nil:
+		entry.URL = sr.NEL.URL
+		entry.StatusCode = int(sr.NEL.Body.StatusCode)
+		entry.Phase = sr.NEL.Body.Phase
+		entry.NELType = sr.NEL.Body.Type
+		entry.ServerIP = sr.NEL.Body.ServerIP
+		entry.Protocol = sr.NEL.Body.Protocol
+		entry.Method = sr.NEL.Body.Method
+		entry.ElapsedTime = sr.NEL.Body.ElapsedTime
+		entry.SamplingFraction = sr.NEL.Body.SamplingFraction
 	}
+	entry.setIssue(securityReportIssueKey(sr, entry))
 
 	return entry
 }
+
+// civilTime converts a parser's timestamp, which is civil time in the
+// local zone, back to an instant, or returns fallback if it is unset.
+func civilTime(dt bigquery.NullDateTime, fallback time.Time) time.Time {
+	if !dt.Valid {
+		return fallback
+	}
+	return dt.DateTime.In(time.Local)
+}
+
+// rawAgent returns the User-Agent a was parsed from, or "" if there was
+// none.
+func rawAgent(a *useragent.Agent) string {
+	if a == nil {
+		return ""
+	}
+	return a.Raw
+}
+
+// agentColumns parses ua into the columns Agent stores.
+func agentColumns(ua string) Agent {
+	a := useragent.Parse(ua)
+	if a == nil {
+		return Agent{}
+	}
+	return Agent{
+		UserAgent:     ua,
+		BrowserFamily: a.Family,
+		BrowserMajor:  a.Major,
+		OS:            a.OS,
+		DeviceClass:   a.Device,
+	}
+}
+
+// securityReportIssueKey picks the fields that identify sr's problem.
+func securityReportIssueKey(sr *reporting.SecurityReport, entry *SecurityReportEntry) issueKey {
+	reportType := entry.ReportType
+	switch {
+	case sr.CSP != nil:
+		return cspIssueKey(entry.ViolatedDirective, entry.EffectiveDirective, entry.BlockedURI, entry.SourceFile)
+	case sr.Deprecation != nil:
+		return namedIssueKey(reportType, sr.Deprecation.Body.ID.StringVal, entry.Message)
+	case sr.PermissionsPolicy != nil:
+		return namedIssueKey(reportType, sr.PermissionsPolicy.Body.FeatureID, entry.Message)
+	case sr.Intervention != nil:
+		return namedIssueKey(reportType, sr.Intervention.Body.ID, entry.Message)
+	case sr.Crash != nil:
+		return namedIssueKey(reportType, sr.Crash.Body.Reason, "")
+	case sr.COEP != nil:
+		b := sr.COEP.Body
+		origin := blockedOrigin(b.BlockedURL)
+		return issueKey{
+			fingerprint(reportType, b.Type, origin, b.Destination),
+			fmt.Sprintf("%s: %s blocked %s", reportType, b.Type, origin),
+		}
+	case sr.COOP != nil:
+		b := sr.COOP.Body
+		return issueKey{
+			fingerprint(reportType, b.Type, b.Property, b.EffectivePolicy),
+			fmt.Sprintf("%s: %s under %s", reportType, b.Type, b.EffectivePolicy),
+		}
+	case sr.DocumentPolicy != nil:
+		return namedIssueKey(reportType, sr.DocumentPolicy.Body.FeatureID, entry.Message)
+	case sr.NEL != nil:
+		return nelIssueKey(sr.NEL.Body.Type, sr.NEL.Body.Phase, entry.URL)
+	default:
+		return namedIssueKey(reportType, "", entry.Message)
+	}
+}
+
+// NoiseReport is what noise rules match e on.
+func (e *ReportToEntry) NoiseReport() noise.Report {
+	return noise.Report{
+		Type:       e.ReportType,
+		BlockedURI: e.BlockedURI,
+		SourceFile: e.SourceFile,
+		Directive:  cspDirective(e.ViolatedDirective, e.EffectiveDirective),
+		UserAgent:  e.UserAgent,
+	}
+}
+
+// NoiseReport is what noise rules match e on.
+func (e *SecurityReportEntry) NoiseReport() noise.Report {
+	return noise.Report{
+		Type:       e.ReportType,
+		BlockedURI: e.BlockedURI,
+		SourceFile: e.SourceFile,
+		Directive:  cspDirective(e.ViolatedDirective, e.EffectiveDirective),
+		UserAgent:  e.UserAgent,
+	}
+}
+
+func (e *ReportToEntry) setIssue(k issueKey) {
+	e.Fingerprint, e.issueTitle = k.fingerprint, k.title
+}
+
+func (e *SecurityReportEntry) setIssue(k issueKey) {
+	e.Fingerprint, e.issueTitle = k.fingerprint, k.title
+}
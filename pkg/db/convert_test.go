@@ -1,12 +1,16 @@
 package db
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
 	"github.com/icco/reportd/pkg/analytics"
 	"github.com/icco/reportd/pkg/reporting"
 	"github.com/icco/reportd/pkg/reportto"
+	"github.com/icco/reportd/pkg/useragent"
 )
 
 func nullStr(s string) bigquery.NullString {
@@ -23,6 +27,7 @@ func TestWebVitalFromAnalytics(t *testing.T) {
 		ID:      "v1-abc",
 		Label:   bigquery.NullString{StringVal: "web-vital", Valid: true},
 		Service: bigquery.NullString{StringVal: "mysite", Valid: true},
+		Agent:   useragent.Parse("Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"),
 	}
 
 	entry := WebVitalFromAnalytics(wv)
@@ -48,11 +53,48 @@ func TestWebVitalFromAnalytics(t *testing.T) {
 	if entry.CreatedAt.IsZero() {
 		t.Error("created_at should not be zero")
 	}
+	if entry.BrowserFamily != "safari" || entry.BrowserMajor != 17 || entry.OS != "ios" || entry.DeviceClass != "mobile" || entry.UserAgent == "" {
+		t.Errorf("expected the parsed agent columns, got %+v", entry.Agent)
+	}
+}
+
+func TestWebVitalFromAnalyticsAttribution(t *testing.T) {
+	wv := &analytics.WebVital{
+		Name:           "LCP",
+		Value:          3000,
+		Rating:         nullStr(analytics.RatingNeedsImprovement),
+		NavigationType: nullStr("back-forward-cache"),
+		Attribution: &analytics.Attribution{
+			Element:           "#hero",
+			URL:               "https://example.com/hero.avif",
+			ResourceLoadDelay: 120,
+		},
+		Service: nullStr("mysite"),
+	}
+
+	entry := WebVitalFromAnalytics(wv)
+
+	if entry.Rating != analytics.RatingNeedsImprovement {
+		t.Errorf("expected rating %q, got %q", analytics.RatingNeedsImprovement, entry.Rating)
+	}
+	if entry.NavigationType != "back-forward-cache" {
+		t.Errorf("expected navigation_type 'back-forward-cache', got %q", entry.NavigationType)
+	}
+	if entry.AttributionTarget != "#hero" {
+		t.Errorf("expected attribution_target '#hero', got %q", entry.AttributionTarget)
+	}
+	if entry.AttributionURL != "https://example.com/hero.avif" {
+		t.Errorf("expected attribution_url, got %q", entry.AttributionURL)
+	}
+	if !strings.Contains(entry.AttributionJSON, `"resourceLoadDelay":120`) {
+		t.Errorf("attribution_json missing breakdown: %s", entry.AttributionJSON)
+	}
 }
 
 func TestReportToEntryFromCSPReport(t *testing.T) {
 	r := &reportto.Report{
 		Service: bigquery.NullString{StringVal: "mysite", Valid: true},
+		Agent:   useragent.Parse("Mozilla/5.0 (Macintosh; Intel Mac OS X 14.4; rv:125.0) Gecko/20100101 Firefox/125.0"),
 		CSP: &reportto.CSPReport{
 			CSPReport: struct {
 				DocumentURI        string `json:"document-uri"`
@@ -99,6 +141,9 @@ func TestReportToEntryFromCSPReport(t *testing.T) {
 	if entry.LineNumber != 10 {
 		t.Errorf("expected line_number 10, got %d", entry.LineNumber)
 	}
+	if !strings.Contains(entry.UserAgent, "Firefox/125.0") {
+		t.Errorf("expected user_agent from the request, got %q", entry.UserAgent)
+	}
 	if entry.RawJSON == "" {
 		t.Error("RawJSON should not be empty")
 	}
@@ -126,8 +171,9 @@ func TestReportToEntryFromReportTo(t *testing.T) {
 		Service: bigquery.NullString{StringVal: "mysite", Valid: true},
 		ReportTo: []*reportto.Entry{
 			{
-				Type: "csp-violation",
-				URL:  "https://example.com/page",
+				Type:      "csp-violation",
+				URL:       "https://example.com/page",
+				UserAgent: "Mozilla/5.0 Firefox/125.0",
 				Body: struct {
 					AnticipatedRemoval float64 `json:"anticipatedRemoval,omitempty"`
 					Blocked            string  `json:"blocked,omitempty"`
@@ -188,11 +234,54 @@ func TestReportToEntryFromReportTo(t *testing.T) {
 	if entry.LineNumber != 42 {
 		t.Errorf("expected line_number 42, got %d", entry.LineNumber)
 	}
+	if entry.UserAgent != "Mozilla/5.0 Firefox/125.0" {
+		t.Errorf("expected user_agent, got %q", entry.UserAgent)
+	}
 	if entry.RawJSON == "" {
 		t.Error("RawJSON should not be empty")
 	}
 }
 
+func TestReportTimesFromReports(t *testing.T) {
+	received := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local)
+	occurred := received.Add(-time.Minute)
+	civilAt := func(at time.Time) bigquery.NullDateTime {
+		return bigquery.NullDateTime{DateTime: civil.DateTimeOf(at), Valid: true}
+	}
+
+	entries := ReportToEntriesFromReport(&reportto.Report{
+		Service: nullStr("mysite"),
+		Time:    civilAt(received),
+		ReportTo: []*reportto.Entry{
+			{Type: "deprecation", OccurredAt: civilAt(occurred)},
+			{Type: "deprecation"},
+		},
+	})
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	for i, want := range []time.Time{occurred, received} {
+		if e := entries[i]; !e.ReceivedAt.Equal(received) || !e.OccurredAt.Equal(want) {
+			t.Errorf("entry %d: received_at = %v, occurred_at = %v; want %v, %v", i, e.ReceivedAt, e.OccurredAt, received, want)
+		}
+	}
+
+	csp := ReportToEntriesFromReport(&reportto.Report{Service: nullStr("mysite"), Time: civilAt(received), CSP: &reportto.CSPReport{}})
+	if e := csp[0]; !e.ReceivedAt.Equal(received) || !e.OccurredAt.Equal(received) {
+		t.Errorf("csp: received_at = %v, occurred_at = %v; want both %v", e.ReceivedAt, e.OccurredAt, received)
+	}
+
+	sr := SecurityReportEntryFromReport(&reporting.SecurityReport{
+		ReportType: nullStr("deprecation"),
+		Service:    nullStr("mysite"),
+		Time:       civilAt(received),
+		OccurredAt: civilAt(occurred),
+	})
+	if !sr.ReceivedAt.Equal(received) || !sr.OccurredAt.Equal(occurred) {
+		t.Errorf("security report: received_at = %v, occurred_at = %v; want %v, %v", sr.ReceivedAt, sr.OccurredAt, received, occurred)
+	}
+}
+
 func TestSecurityReportEntryFromCSP(t *testing.T) {
 	sr := &reporting.SecurityReport{
 		ReportType: nullStr("csp-violation"),
@@ -234,6 +323,7 @@ func TestSecurityReportEntryFromDeprecation(t *testing.T) {
 	sr := &reporting.SecurityReport{
 		ReportType: nullStr("deprecation"),
 		RawJSON:    `{"type":"deprecation"}`,
+		Agent:      useragent.Parse("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"),
 		Service:    nullStr("mysite"),
 		Deprecation: &reporting.DeprecationReport{
 			URL: "https://example.com/",
@@ -256,6 +346,9 @@ func TestSecurityReportEntryFromDeprecation(t *testing.T) {
 	if entry.SourceFile != "db.js" {
 		t.Errorf("expected source_file 'db.js', got %q", entry.SourceFile)
 	}
+	if !strings.Contains(entry.UserAgent, "Chrome/124") {
+		t.Errorf("expected user_agent from the agent, got %q", entry.UserAgent)
+	}
 }
 
 func TestSecurityReportEntryFromCrash(t *testing.T) {
@@ -403,3 +496,63 @@ func TestSecurityReportEntryFromIntervention(t *testing.T) {
 		t.Errorf("expected source_file 'ads.js', got %q", entry.SourceFile)
 	}
 }
+
+func TestSecurityReportEntryFromNEL(t *testing.T) {
+	sr := &reporting.SecurityReport{
+		ReportType: nullStr(reportTypeNEL),
+		RawJSON:    `{"type":"network-error"}`,
+		Service:    nullStr("mysite"),
+		NEL: &reporting.NELReport{
+			URL: "https://example.com/data.json",
+			Body: reporting.NELReportBody{
+				SamplingFraction: 0.25,
+				ServerIP:         "192.0.2.1",
+				Protocol:         "h2",
+				Method:           "GET",
+				StatusCode:       502,
+				ElapsedTime:      1200,
+				Phase:            "application",
+				Type:             "http.error",
+			},
+		},
+	}
+
+	entry := SecurityReportEntryFromReport(sr)
+
+	if entry.URL != "https://example.com/data.json" {
+		t.Errorf("expected URL, got %q", entry.URL)
+	}
+	if entry.NELType != "http.error" || entry.Phase != "application" {
+		t.Errorf("nel_type/phase = %q/%q", entry.NELType, entry.Phase)
+	}
+	if entry.ServerIP != "192.0.2.1" || entry.Protocol != "h2" || entry.Method != "GET" {
+		t.Errorf("server_ip/protocol/method = %q/%q/%q", entry.ServerIP, entry.Protocol, entry.Method)
+	}
+	if entry.StatusCode != 502 || entry.ElapsedTime != 1200 || entry.SamplingFraction != 0.25 {
+		t.Errorf("status/elapsed/sampling = %d/%d/%v", entry.StatusCode, entry.ElapsedTime, entry.SamplingFraction)
+	}
+}
+
+func TestReportToEntryFromNEL(t *testing.T) {
+	body := `[{"type":"network-error","url":"https://example.com/data.json","body":{"elapsed_time":30076,"method":"GET","phase":"connection","protocol":"h2","sampling_fraction":1,"server_ip":"192.0.2.1","status_code":0,"type":"tcp.timed_out"}}]`
+	r, err := reportto.ParseReport(reportto.ContentTypeReports, body, "mysite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := ReportToEntriesFromReport(r)
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	entry := entries[0]
+
+	if entry.ReportType != reportTypeNEL {
+		t.Errorf("expected type %q, got %q", reportTypeNEL, entry.ReportType)
+	}
+	if entry.NELType != "tcp.timed_out" || entry.Phase != "connection" {
+		t.Errorf("nel_type/phase = %q/%q", entry.NELType, entry.Phase)
+	}
+	if entry.ServerIP != "192.0.2.1" || entry.ElapsedTime != 30076 || entry.SamplingFraction != 1 {
+		t.Errorf("server_ip/elapsed/sampling = %q/%d/%v", entry.ServerIP, entry.ElapsedTime, entry.SamplingFraction)
+	}
+}
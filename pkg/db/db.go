@@ -1,13 +1,17 @@
 // Package db owns the SQL persistence layer: GORM models, dialect
-// detection, and dashboard query helpers.
+// detection, schema migrations, and dashboard query helpers.
 package db
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/mattn/go-sqlite3"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -49,25 +53,58 @@ func dialector(databaseURL string) (gorm.Dialector, string, error) {
 		if dsn == "" {
 			return nil, "", errors.New("missing sqlite dsn")
 		}
-		return sqlite.Open(dsn), dialectSQLite, nil
+		return openSQLite(dsn), dialectSQLite, nil
 	}
 
 	if strings.HasPrefix(databaseURL, "file:") {
-		return sqlite.Open(databaseURL), dialectSQLite, nil
+		return openSQLite(databaseURL), dialectSQLite, nil
 	}
 
 	return postgres.Open(databaseURL), "postgres", nil
 }
 
-// AutoMigrate syncs the schema with the current models; safe on every startup.
-func AutoMigrate(ctx context.Context, db *gorm.DB) error {
-	if err := db.WithContext(ctx).AutoMigrate(
-		&WebVital{},
-		&ReportToEntry{},
-		&SecurityReportEntry{},
-	); err != nil {
-		return fmt.Errorf("auto-migrating: %w", err)
+// openSQLite returns a dialector for dsn whose connections store and
+// bind every time in UTC. SQLite keeps times as text and compares them as
+// such, so a row written with the host's offset would sort wrongly
+// against a bound UTC time.
+func openSQLite(dsn string) gorm.Dialector {
+	return sqlite.New(sqlite.Config{Conn: sql.OpenDB(utcConnector{dsn: dsn})})
+}
+
+// utcConnector opens SQLite connections that convert time arguments to
+// UTC.
+type utcConnector struct {
+	dsn string
+}
+
+// Connect implements driver.Connector.
+func (c utcConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
 	}
+	return utcConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
 
+// Driver implements driver.Connector.
+func (utcConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+type utcConn struct {
+	*sqlite3.SQLiteConn
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, converting
+// arguments as database/sql would and then any time to UTC.
+func (utcConn) CheckNamedValue(nv *driver.NamedValue) error {
+	v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	if t, ok := v.(time.Time); ok {
+		v = t.UTC()
+	}
+	nv.Value = v
 	return nil
 }
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Tables accepted by ExportColumns and ExportRows.
+const (
+	ExportWebVitals = "web-vitals"
+	ExportReportTo  = "report-to"
+	ExportReporting = "reporting"
+)
+
+// exportTable is a table ExportRows can stream, and the column its
+// ExportFilter.Type matches.
+type exportTable struct {
+	model      func() any
+	typeColumn string
+}
+
+var exportTables = map[string]exportTable{
+	ExportWebVitals: {func() any { return &WebVital{} }, "name"},
+	ExportReportTo:  {func() any { return &ReportToEntry{} }, "report_type"},
+	ExportReporting: {func() any { return &SecurityReportEntry{} }, "report_type"},
+}
+
+// ExportFilter selects the rows ExportRows streams. Type matches a web
+// vital's metric name or a report's type. Zero fields match everything.
+type ExportFilter struct {
+	Type     string
+	From, To time.Time
+}
+
+// ErrUnknownExportTable is returned for a table ExportRows cannot stream.
+var ErrUnknownExportTable = fmt.Errorf("unknown export table: want %s, %s or %s", ExportWebVitals, ExportReportTo, ExportReporting)
+
+// exportFields returns the stored columns of table's model, in
+// declaration order, leaving out the soft-delete marker.
+func exportFields(d *gorm.DB, table string) (exportTable, []*schema.Field, error) {
+	t, ok := exportTables[table]
+	if !ok {
+		return t, nil, ErrUnknownExportTable
+	}
+	stmt := &gorm.Statement{DB: d}
+	if err := stmt.Parse(t.model()); err != nil {
+		return t, nil, fmt.Errorf("parsing %s model: %w", table, err)
+	}
+	fields := make([]*schema.Field, 0, len(stmt.Schema.Fields))
+	for _, f := range stmt.Schema.Fields {
+		if f.DBName == "" || f.DBName == "deleted_at" {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return t, fields, nil
+}
+
+// ExportColumns returns the column names of table, in the order
+// ExportRows gives each row's record.
+func ExportColumns(d *gorm.DB, table string) ([]string, error) {
+	_, fields, err := exportFields(d, table)
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]string, 0, len(fields))
+	for _, f := range fields {
+		cols = append(cols, f.DBName)
+	}
+	return cols, nil
+}
+
+// ExportRows calls fn with each of service's rows in table matching f,
+// oldest first. row is the model (*WebVital, *ReportToEntry or
+// *SecurityReportEntry) and record its columns as text, in ExportColumns
+// order. Rows are read one at a time into the same row and record, so
+// memory stays flat however many match; fn must not keep either. An error
+// from fn stops the export and is returned.
+func ExportRows(ctx context.Context, d *gorm.DB, table, service string, f ExportFilter, fn func(row any, record []string) error) error {
+	t, fields, err := exportFields(d, table)
+	if err != nil {
+		return err
+	}
+
+	row := t.model()
+	q := d.WithContext(ctx).Model(row).Where("service = ?", service)
+	if f.Type != "" {
+		q = q.Where(t.typeColumn+" = ?", f.Type)
+	}
+	if !f.From.IsZero() {
+		q = q.Where("created_at >= ?", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		q = q.Where("created_at < ?", f.To.UTC())
+	}
+	rows, err := q.Order("created_at, id").Rows()
+	if err != nil {
+		return fmt.Errorf("exporting %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	v := reflect.ValueOf(row).Elem()
+	record := make([]string, len(fields))
+	for rows.Next() {
+		v.SetZero()
+		if err := d.ScanRows(rows, row); err != nil {
+			return fmt.Errorf("scanning %s row: %w", table, err)
+		}
+		for i, field := range fields {
+			value, _ := field.ValueOf(ctx, v)
+			record[i] = exportText(value)
+		}
+		if err := fn(row, record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("exporting %s: %w", table, err)
+	}
+	return nil
+}
+
+// exportText formats a column value for a CSV cell. Times are RFC 3339 in
+// UTC. Text that a spreadsheet would run as a formula gets a leading
+// quote, since report fields come from anyone who can post to reportd.
+func exportText(value any) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestExportText(t *testing.T) {
+	for _, tc := range []struct {
+		value any
+		want  string
+	}{
+		{time.Date(2025, 6, 1, 14, 0, 0, 500, time.FixedZone("CEST", 2*3600)), "2025-06-01T12:00:00.0000005Z"},
+		{2.5, "2.5"},
+		{-0.25, "-0.25"},
+		{1e-7, "0.0000001"},
+		{42, "42"},
+		{uint(7), "7"},
+		{"plain", "plain"},
+		{"", ""},
+		{"=HYPERLINK(\"https://evil.com\")", "'=HYPERLINK(\"https://evil.com\")"},
+		{"-1+1", "'-1+1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+	} {
+		if got := exportText(tc.value); got != tc.want {
+			t.Errorf("exportText(%#v) = %q, want %q", tc.value, got, tc.want)
+		}
+	}
+}
+
+// exportBase is when the oldest export fixture was received.
+var exportBase = time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+
+// seedExportFixtures stores three web vitals and a report in each report
+// table for service, an hour apart, and a soft-deleted vital that exports
+// must leave out.
+func seedExportFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	for i, name := range []string{"LCP", "CLS", "LCP"} {
+		v := &WebVital{CreatedAt: exportBase.Add(time.Duration(i) * time.Hour), Service: service, Name: name, Value: float64(i) + 0.5, Page: "/p"}
+		if err := d.Create(v).Error; err != nil {
+			t.Fatalf("seed web vital: %v", err)
+		}
+	}
+	deleted := &WebVital{CreatedAt: exportBase, Service: service, Name: "LCP", Value: 99}
+	if err := d.Create(deleted).Error; err != nil {
+		t.Fatalf("seed web vital: %v", err)
+	}
+	if err := d.Delete(deleted).Error; err != nil {
+		t.Fatalf("delete web vital: %v", err)
+	}
+	if err := d.Create(&ReportToEntry{CreatedAt: exportBase, Service: service, ReportType: "csp", BlockedURI: "inline", RawJSON: "{}"}).Error; err != nil {
+		t.Fatalf("seed report-to: %v", err)
+	}
+	if err := d.Create(&SecurityReportEntry{CreatedAt: exportBase, Service: service, ReportType: "deprecation", Message: "=cmd", RawJSON: "{}"}).Error; err != nil {
+		t.Fatalf("seed security report: %v", err)
+	}
+}
+
+func assertExport(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+
+	cols, err := ExportColumns(d, ExportWebVitals)
+	if err != nil {
+		t.Fatalf("ExportColumns() error = %v", err)
+	}
+	if cols[0] != "id" || cols[1] != "created_at" || slices.Contains(cols, "deleted_at") || !slices.Contains(cols, "attribution_json") {
+		t.Errorf("ExportColumns(%s) = %q", ExportWebVitals, cols)
+	}
+	column := func(name string) int {
+		t.Helper()
+		i := slices.Index(cols, name)
+		if i < 0 {
+			t.Fatalf("no %s column in %q", name, cols)
+		}
+		return i
+	}
+
+	var values []float64
+	var records [][]string
+	err = ExportRows(ctx, d, ExportWebVitals, service, ExportFilter{}, func(row any, record []string) error {
+		values = append(values, row.(*WebVital).Value)
+		records = append(records, slices.Clone(record))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportRows() error = %v", err)
+	}
+	if !slices.Equal(values, []float64{0.5, 1.5, 2.5}) {
+		t.Errorf("ExportRows(%s) values = %v, want oldest first without the deleted row", ExportWebVitals, values)
+	}
+	if len(records) == 3 {
+		r := records[1]
+		if r[column("created_at")] != "2025-07-01T01:00:00Z" || r[column("name")] != "CLS" || r[column("value")] != "1.5" || r[column("service")] != service || r[column("page")] != "/p" {
+			t.Errorf("ExportRows(%s) record = %q", ExportWebVitals, r)
+		}
+	}
+
+	count := func(table string, f ExportFilter) int {
+		t.Helper()
+		n := 0
+		if err := ExportRows(ctx, d, table, service, f, func(any, []string) error { n++; return nil }); err != nil {
+			t.Fatalf("ExportRows(%s) error = %v", table, err)
+		}
+		return n
+	}
+	if n := count(ExportWebVitals, ExportFilter{Type: "LCP"}); n != 2 {
+		t.Errorf("ExportRows(LCP) = %d rows, want 2", n)
+	}
+	if n := count(ExportWebVitals, ExportFilter{From: exportBase.Add(time.Hour), To: exportBase.Add(2 * time.Hour)}); n != 1 {
+		t.Errorf("ExportRows(one hour) = %d rows, want 1", n)
+	}
+	if n := count(ExportReportTo, ExportFilter{Type: "csp"}); n != 1 {
+		t.Errorf("ExportRows(%s) = %d rows, want 1", ExportReportTo, n)
+	}
+	if n := count(ExportReporting, ExportFilter{Type: "csp"}); n != 0 {
+		t.Errorf("ExportRows(%s, csp) = %d rows, want 0", ExportReporting, n)
+	}
+
+	reportCols, err := ExportColumns(d, ExportReporting)
+	if err != nil {
+		t.Fatalf("ExportColumns(%s) error = %v", ExportReporting, err)
+	}
+	var message string
+	err = ExportRows(ctx, d, ExportReporting, service, ExportFilter{}, func(row any, record []string) error {
+		if row.(*SecurityReportEntry).Message != "=cmd" {
+			t.Errorf("row message = %q, want it unescaped", row.(*SecurityReportEntry).Message)
+		}
+		message = record[slices.Index(reportCols, "message")]
+		return nil
+	})
+	if err != nil || message != "'=cmd" {
+		t.Errorf("ExportRows(%s) message = %q, %v; want the formula quoted", ExportReporting, message, err)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = ExportRows(ctx, d, ExportWebVitals, service, ExportFilter{}, func(any, []string) error { calls++; return stop })
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("ExportRows(stop) = %v after %d calls, want stop after 1", err, calls)
+	}
+
+	if err := ExportRows(ctx, d, "issues", service, ExportFilter{}, nil); !errors.Is(err, ErrUnknownExportTable) {
+		t.Errorf("ExportRows(issues) error = %v, want ErrUnknownExportTable", err)
+	}
+}
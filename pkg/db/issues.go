@@ -0,0 +1,346 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Issue is one distinct problem in a service: every report sharing a
+// fingerprint, with a running count and the first such report kept as a
+// sample. Status tracks triage; see triage.go.
+type Issue struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Service     string    `gorm:"uniqueIndex:idx_issues_service_fingerprint;not null" json:"service"`
+	Fingerprint string    `gorm:"uniqueIndex:idx_issues_service_fingerprint;size:32;not null" json:"fingerprint"`
+	ReportType  string    `gorm:"index" json:"report_type"`
+	Title       string    `json:"title"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `gorm:"index" json:"last_seen"`
+	Count       int64     `gorm:"not null" json:"count"`
+	// Sample is the JSON of the first report row filed under the issue.
+	Sample string `gorm:"type:text" json:"-"`
+
+	Status      string     `gorm:"index;not null;default:open" json:"status"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	RegressedAt *time.Time `json:"regressed_at,omitempty"`
+	// IgnoredUntil and IgnoreUntilCount end an ignore once a report
+	// arrives at or after that time, or once Count reaches that total.
+	IgnoredUntil     *time.Time `json:"ignored_until,omitempty"`
+	IgnoreUntilCount int64      `json:"ignore_until_count,omitempty"`
+}
+
+// MarshalJSON inlines Sample as a JSON object rather than a string.
+func (i Issue) MarshalJSON() ([]byte, error) {
+	type plain Issue
+	var sample json.RawMessage
+	if i.Sample != "" {
+		sample = json.RawMessage(i.Sample)
+	}
+	return json.Marshal(struct {
+		plain
+		Sample json.RawMessage `json:"sample"`
+	}{plain(i), sample})
+}
+
+// cspIssueType is the report type CSP issues are filed under, so legacy
+// "csp" and Reporting API "csp-violation" reports of one problem merge.
+const cspIssueType = reportTypeCSPViolation
+
+// issueKey is what a report contributes to its issue: the fingerprint
+// shared by every report of the same problem, and a title for it.
+type issueKey struct {
+	fingerprint string
+	title       string
+}
+
+// fingerprint hashes the fields that identify one underlying problem.
+// Parts are length-prefixed so ("ab", "c") and ("a", "bc") differ.
+func fingerprint(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		_, _ = fmt.Fprintf(h, "%d:%s", len(p), p)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// cspIssueKey identifies a CSP violation by directive, blocked origin and
+// source file, ignoring the page it happened on and the exact URL that
+// was blocked.
+func cspIssueKey(violated, effective, blocked, sourceFile string) issueKey {
+	directive, origin, file := cspDirective(violated, effective), blockedOrigin(blocked), stripQuery(sourceFile)
+	title := directive + " blocked " + origin
+	if file != "" {
+		title += " in " + file
+	}
+	return issueKey{fingerprint(cspIssueType, directive, origin, file), title}
+}
+
+// namedIssueKey identifies a report by the name the browser gives the
+// problem, such as a deprecation id or policy feature, falling back to
+// its message.
+func namedIssueKey(reportType, name, message string) issueKey {
+	if name == "" {
+		name = message
+	}
+	title := reportType
+	if name != "" {
+		title += ": " + name
+	}
+	return issueKey{fingerprint(reportType, name), title}
+}
+
+// nelIssueKey identifies a network error by type, phase and host.
+func nelIssueKey(nelType, phase, rawURL string) issueKey {
+	host := urlHost(rawURL)
+	return issueKey{
+		fingerprint(reportTypeNEL, nelType, phase, host),
+		fmt.Sprintf("%s during %s to %s", nelType, phase, host),
+	}
+}
+
+// cspDirective prefers the effective directive. CSP2 browsers only send
+// violated-directive, which may carry the policy's source list after the
+// directive name.
+func cspDirective(violated, effective string) string {
+	if effective != "" {
+		return effective
+	}
+	name, _, _ := strings.Cut(strings.TrimSpace(violated), " ")
+	return name
+}
+
+// blockedOrigin reduces a blocked URI to what stays stable across
+// reports: the origin for network URLs, the scheme for data:, blob: and
+// extension URLs, and keywords such as "inline" or "eval" unchanged.
+func blockedOrigin(raw string) string {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return raw
+	}
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+		if u.Host != "" {
+			return u.Scheme + "://" + u.Host
+		}
+	}
+	return u.Scheme
+}
+
+// stripQuery drops the query and fragment, which often carry cache
+// busters, from a script URL.
+func stripQuery(raw string) string {
+	if i := strings.IndexAny(raw, "?#"); i >= 0 {
+		return raw[:i]
+	}
+	return raw
+}
+
+// blockedHost is what search matches a blocked URI's host against: the
+// lower-cased host name of a network URL, or for anything else the
+// keyword or scheme blockedOrigin reduces it to.
+func blockedHost(raw string) string {
+	if u, err := url.Parse(strings.TrimSpace(raw)); err == nil && u.Host != "" {
+		return strings.ToLower(u.Hostname())
+	}
+	return blockedOrigin(raw)
+}
+
+// urlHost returns raw's host, or raw itself if it does not parse.
+func urlHost(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return raw
+}
+
+// newIssue starts the issue a stored report row belongs to. A row kept
+// at a sample rate counts as the 1/rate reports it stands for, matching
+// GetReportCounts.
+func newIssue(service, reportType, fp, title string, seen time.Time, rate float64, row any) *Issue {
+	if reportType == reportTypeCSP {
+		reportType = cspIssueType
+	}
+	raw, _ := json.Marshal(row)
+	seen = seen.UTC()
+	return &Issue{
+		Service:     service,
+		Fingerprint: fp,
+		ReportType:  reportType,
+		Title:       title,
+		FirstSeen:   seen,
+		LastSeen:    seen,
+		Count:       int64(math.Round(1 / rate)),
+		Sample:      string(raw),
+		Status:      IssueOpen,
+	}
+}
+
+// mergeIssues collapses issues with the same service and fingerprint,
+// keeping the earliest sample, so a batch upserts each key once.
+func mergeIssues(issues []*Issue) []*Issue {
+	type key struct{ service, fp string }
+	byKey := map[key]*Issue{}
+	var out []*Issue
+	for _, is := range issues {
+		if is.Fingerprint == "" {
+			continue
+		}
+		k := key{is.Service, is.Fingerprint}
+		cur, ok := byKey[k]
+		if !ok {
+			c := *is
+			byKey[k] = &c
+			out = append(out, &c)
+			continue
+		}
+		cur.Count += is.Count
+		if is.FirstSeen.Before(cur.FirstSeen) {
+			cur.FirstSeen = is.FirstSeen
+			cur.Sample = is.Sample
+		}
+		if is.LastSeen.After(cur.LastSeen) {
+			cur.LastSeen = is.LastSeen
+		}
+	}
+	return out
+}
+
+// recordIssues adds issues to the issues table, creating new ones and
+// bumping the count and last-seen time of existing ones. A report newer
+// than an issue's resolution marks it regressed, and one past the end of
+// an ignore reopens it.
+func recordIssues(ctx context.Context, d *gorm.DB, issues []*Issue) error {
+	issues = mergeIssues(issues)
+	if len(issues) == 0 {
+		return nil
+	}
+	// Sorted keys give concurrent batches a consistent lock order.
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Service != issues[j].Service {
+			return issues[i].Service < issues[j].Service
+		}
+		return issues[i].Fingerprint < issues[j].Fingerprint
+	})
+	err := d.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "service"}, {Name: "fingerprint"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"count":              gorm.Expr("issues.count + excluded.count"),
+			"last_seen":          gorm.Expr("CASE WHEN excluded.last_seen > issues.last_seen THEN excluded.last_seen ELSE issues.last_seen END"),
+			"status":             gorm.Expr("CASE WHEN "+regressesSQL+" THEN ? WHEN "+ignoreEndsSQL+" THEN ? ELSE issues.status END", IssueRegressed, IssueOpen),
+			"regressed_at":       gorm.Expr("CASE WHEN " + regressesSQL + " THEN excluded.last_seen ELSE issues.regressed_at END"),
+			"ignored_until":      gorm.Expr("CASE WHEN " + ignoreEndsSQL + " THEN NULL ELSE issues.ignored_until END"),
+			"ignore_until_count": gorm.Expr("CASE WHEN " + ignoreEndsSQL + " THEN 0 ELSE issues.ignore_until_count END"),
+		}),
+	}).Create(&issues).Error
+	if err != nil {
+		return fmt.Errorf("recording issues: %w", err)
+	}
+	return nil
+}
+
+// SaveReportToEntries fills in each entry's search columns, then stores
+// entries and files each under its issue in one transaction.
+func SaveReportToEntries(ctx context.Context, d *gorm.DB, entries []*ReportToEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	for _, e := range entries {
+		e.BlockedHost = blockedHost(e.BlockedURI)
+		e.Agent = agentColumns(e.UserAgent)
+	}
+	return d.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&entries).Error; err != nil {
+			return fmt.Errorf("creating report-to entries: %w", err)
+		}
+		// Built after Create so each sample carries its row ID.
+		issues := make([]*Issue, 0, len(entries))
+		for _, e := range entries {
+			issues = append(issues, newIssue(e.Service, e.ReportType, e.Fingerprint, e.issueTitle, e.CreatedAt, e.SampleRate, e))
+		}
+		return recordIssues(ctx, tx, issues)
+	})
+}
+
+// SaveSecurityReportEntries fills in each entry's search columns, then
+// stores entries and files each under its issue in one transaction.
+func SaveSecurityReportEntries(ctx context.Context, d *gorm.DB, entries []*SecurityReportEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	for _, e := range entries {
+		e.BlockedHost = blockedHost(e.BlockedURI)
+		e.Agent = agentColumns(e.UserAgent)
+	}
+	return d.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&entries).Error; err != nil {
+			return fmt.Errorf("creating security report entries: %w", err)
+		}
+		issues := make([]*Issue, 0, len(entries))
+		for _, e := range entries {
+			issues = append(issues, newIssue(e.Service, e.ReportType, e.Fingerprint, e.issueTitle, e.CreatedAt, e.SampleRate, e))
+		}
+		return recordIssues(ctx, tx, issues)
+	})
+}
+
+// Issue sort orders accepted by GetIssues.
+const (
+	IssueSortLastSeen = "last_seen"
+	IssueSortCount    = "count"
+)
+
+// IssueFilter selects issues for GetIssues. Zero fields match everything,
+// except Sort, which defaults to IssueSortLastSeen.
+type IssueFilter struct {
+	ReportType string
+	// Statuses usually comes from ParseIssueStatuses.
+	Statuses []string
+	Sort     string
+	Limit    int
+}
+
+// GetIssues returns service's issues matching f, most recently seen first
+// or, with IssueSortCount, most frequent first. Ignores whose time has
+// passed are reopened first.
+func GetIssues(ctx context.Context, d *gorm.DB, service string, f IssueFilter) ([]Issue, error) {
+	if err := reopenExpiredIgnores(ctx, d, service); err != nil {
+		return nil, err
+	}
+
+	q := d.WithContext(ctx).Where("service = ?", service)
+	if f.ReportType != "" {
+		q = q.Where("report_type = ?", f.ReportType)
+	}
+	if len(f.Statuses) > 0 {
+		q = q.Where("status IN ?", f.Statuses)
+	}
+	switch f.Sort {
+	case IssueSortCount:
+		q = q.Order("count DESC").Order("last_seen DESC")
+	case IssueSortLastSeen, "":
+		q = q.Order("last_seen DESC")
+	default:
+		return nil, fmt.Errorf("unknown issue sort %q", f.Sort)
+	}
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+
+	var results []Issue
+	if err := q.Order("id").Find(&results).Error; err != nil {
+		return nil, fmt.Errorf("querying issues: %w", err)
+	}
+	return results, nil
+}
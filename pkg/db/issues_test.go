@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/icco/reportd/pkg/reporting"
+	"github.com/icco/reportd/pkg/reportto"
+	"gorm.io/gorm"
+)
+
+func TestBlockedOrigin(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"https://evil.com/a.js?v=1", "https://evil.com"},
+		{"wss://socket.example.com:8443/live", "wss://socket.example.com:8443"},
+		{"data:image/png;base64,AAAA", "data"},
+		{"blob:https://example.com/1234", "blob"},
+		{"chrome-extension://abcdef/script.js", "chrome-extension"},
+		{"inline", "inline"},
+		{"eval", "eval"},
+		{"", ""},
+	} {
+		if got := blockedOrigin(tc.in); got != tc.want {
+			t.Errorf("blockedOrigin(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestCSPIssueKey(t *testing.T) {
+	a := cspIssueKey("", "script-src-elem", "https://evil.com/a.js", "https://example.com/app.js?v=1")
+	// CSP2 violated-directive with a source list, a different blocked
+	// path and a different cache buster are the same problem.
+	b := cspIssueKey("script-src-elem 'self'", "", "https://evil.com/b.js", "https://example.com/app.js?v=2")
+	if a.fingerprint != b.fingerprint {
+		t.Errorf("fingerprints differ: %q vs %q", a.fingerprint, b.fingerprint)
+	}
+	if a.title != "script-src-elem blocked https://evil.com in https://example.com/app.js" {
+		t.Errorf("title = %q", a.title)
+	}
+
+	for _, other := range []issueKey{
+		cspIssueKey("", "img-src", "https://evil.com/a.js", "https://example.com/app.js"),
+		cspIssueKey("", "script-src-elem", "https://other.com/a.js", "https://example.com/app.js"),
+		cspIssueKey("", "script-src-elem", "https://evil.com/a.js", "https://example.com/vendor.js"),
+	} {
+		if other.fingerprint == a.fingerprint {
+			t.Errorf("%q shares a fingerprint with %q", other.title, a.title)
+		}
+	}
+}
+
+func TestFingerprintIsUnambiguous(t *testing.T) {
+	if fingerprint("ab", "c") == fingerprint("a", "bc") {
+		t.Error("fingerprint should not depend on how parts are split")
+	}
+	if len(fingerprint("x")) != 32 {
+		t.Errorf("fingerprint length = %d, want 32", len(fingerprint("x")))
+	}
+}
+
+func TestLegacyAndV1CSPShareIssue(t *testing.T) {
+	legacy := &reportto.Report{CSP: &reportto.CSPReport{}, Service: nullStr("svc")}
+	legacy.CSP.CSPReport.DocumentURI = "https://example.com/a"
+	legacy.CSP.CSPReport.ViolatedDirective = "script-src-elem"
+	legacy.CSP.CSPReport.BlockedURI = "https://evil.com/x.js"
+	rt := ReportToEntriesFromReport(legacy)[0]
+
+	sr := SecurityReportEntryFromReport(&reporting.SecurityReport{
+		CSP: &reporting.CSPReport{
+			Type: "csp-violation",
+			URL:  "https://example.com/b",
+			Body: reporting.CSPReportBody{
+				DocumentURI:        "https://example.com/b",
+				EffectiveDirective: "script-src-elem",
+				BlockedURI:         "https://evil.com/y.js",
+			},
+		},
+		ReportType: nullStr("csp-violation"),
+		Service:    nullStr("svc"),
+	})
+
+	if rt.Fingerprint == "" || rt.Fingerprint != sr.Fingerprint {
+		t.Errorf("fingerprints = %q and %q, want equal and non-empty", rt.Fingerprint, sr.Fingerprint)
+	}
+}
+
+// seedIssueFixtures stores reports through the Save helpers, as the
+// handlers do: three CSP reports of one problem across both tables, two
+// of a deprecation, and one network error.
+func seedIssueFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	csp := func(blocked string, at time.Time) *SecurityReportEntry {
+		e := SecurityReportEntryFromReport(&reporting.SecurityReport{
+			CSP: &reporting.CSPReport{
+				Type: "csp-violation",
+				URL:  "https://example.com/",
+				Body: reporting.CSPReportBody{EffectiveDirective: "script-src", BlockedURI: blocked},
+			},
+			ReportType: nullStr("csp-violation"),
+			RawJSON:    "{}",
+			Service:    nullStr(service),
+		})
+		e.CreatedAt = at
+		return e
+	}
+	deprecation := func(at time.Time) *SecurityReportEntry {
+		e := SecurityReportEntryFromReport(&reporting.SecurityReport{
+			Deprecation: &reporting.DeprecationReport{
+				Type: "deprecation",
+				Body: reporting.DeprecationReportBody{ID: nullStr("UnloadHandler"), Message: nullStr("unload is deprecated")},
+			},
+			ReportType: nullStr("deprecation"),
+			RawJSON:    "{}",
+			Service:    nullStr(service),
+		})
+		e.CreatedAt = at
+		return e
+	}
+
+	// One batch holding two reports of the same issue must upsert once.
+	if err := SaveSecurityReportEntries(ctx, d, []*SecurityReportEntry{
+		csp("https://evil.com/a.js", now.Add(-2*time.Hour)),
+		csp("https://evil.com/b.js", now.Add(-time.Hour)),
+		deprecation(now.Add(-30 * time.Minute)),
+	}); err != nil {
+		t.Fatalf("SaveSecurityReportEntries: %v", err)
+	}
+	if err := SaveSecurityReportEntries(ctx, d, []*SecurityReportEntry{deprecation(now)}); err != nil {
+		t.Fatalf("SaveSecurityReportEntries: %v", err)
+	}
+
+	legacy := &reportto.Report{CSP: &reportto.CSPReport{}, Service: nullStr(service)}
+	legacy.CSP.CSPReport.EffectiveDirective = "script-src"
+	legacy.CSP.CSPReport.BlockedURI = "https://evil.com/c.js"
+	rtEntries := ReportToEntriesFromReport(legacy)
+	rtEntries[0].CreatedAt = now.Add(-10 * time.Minute)
+	nel := &reportto.Report{Service: nullStr(service), ReportTo: []*reportto.Entry{{Type: "network-error", URL: "https://api.example.com/v1"}}}
+	nel.ReportTo[0].Body.Type = "tcp.timed_out"
+	nel.ReportTo[0].Body.Phase = "connection"
+	nelEntries := ReportToEntriesFromReport(nel)
+	nelEntries[0].CreatedAt = now.Add(-5 * time.Minute)
+	if err := SaveReportToEntries(ctx, d, append(rtEntries, nelEntries...)); err != nil {
+		t.Fatalf("SaveReportToEntries: %v", err)
+	}
+}
+
+func assertIssues(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	issues, err := GetIssues(ctx, d, service, IssueFilter{Sort: IssueSortCount, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetIssues() error = %v", err)
+	}
+	if len(issues) != 3 {
+		t.Fatalf("GetIssues() returned %d issues, want 3: %+v", len(issues), issues)
+	}
+
+	csp := issues[0]
+	if csp.ReportType != cspIssueType || csp.Count != 3 || csp.Title != "script-src blocked https://evil.com" {
+		t.Errorf("csp issue = %+v, want 3 csp-violation reports", csp)
+	}
+	if !csp.FirstSeen.Equal(now.Add(-2*time.Hour)) || !csp.LastSeen.Equal(now.Add(-10*time.Minute)) {
+		t.Errorf("csp issue seen %v .. %v", csp.FirstSeen, csp.LastSeen)
+	}
+	var sample SecurityReportEntry
+	if err := json.Unmarshal([]byte(csp.Sample), &sample); err != nil || sample.ID == 0 || sample.BlockedURI != "https://evil.com/a.js" {
+		t.Errorf("csp sample = %+v (err %v), want the first report with its row ID", sample, err)
+	}
+
+	if dep := issues[1]; dep.ReportType != "deprecation" || dep.Count != 2 || dep.Title != "deprecation: UnloadHandler" {
+		t.Errorf("deprecation issue = %+v", dep)
+	}
+
+	recent, err := GetIssues(ctx, d, service, IssueFilter{Sort: IssueSortLastSeen, Limit: 1})
+	if err != nil {
+		t.Fatalf("GetIssues(last_seen) error = %v", err)
+	}
+	if len(recent) != 1 || recent[0].ReportType != "deprecation" {
+		t.Errorf("most recent issue = %+v, want the deprecation", recent)
+	}
+
+	nel, err := GetIssues(ctx, d, service, IssueFilter{ReportType: reportTypeNEL, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetIssues(network-error) error = %v", err)
+	}
+	if len(nel) != 1 || nel[0].Title != "tcp.timed_out during connection to api.example.com" {
+		t.Errorf("network-error issues = %+v", nel)
+	}
+
+	if _, err := GetIssues(ctx, d, service, IssueFilter{Sort: "bogus"}); err == nil {
+		t.Error("GetIssues() with unknown sort expected error")
+	}
+}
+
+func TestIssueMarshalJSON(t *testing.T) {
+	raw, err := json.Marshal(Issue{Fingerprint: "abc", Sample: `{"id":1}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	if sample, ok := got["sample"].(map[string]any); !ok || sample["id"] != float64(1) {
+		t.Errorf("sample = %#v, want an inline object", got["sample"])
+	}
+
+	raw, err = json.Marshal(Issue{})
+	if err != nil {
+		t.Fatalf("Marshal(empty issue) error = %v", err)
+	}
+	if err := json.Unmarshal(raw, &got); err != nil || got["sample"] != nil {
+		t.Errorf("empty sample = %#v, want null", got["sample"])
+	}
+}
@@ -0,0 +1,268 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock key held while migrating,
+// so replicas starting together apply each migration once.
+const migrationLockID int64 = 0x7265706f727464 // "reportd"
+
+// Migration is one step of the schema, read from
+// migrations/<dialect>/<version>_<name>.sql.
+type Migration struct {
+	Version int
+	Name    string
+	sql     string
+}
+
+// MigrationStatus is a migration and when it was applied, if it has been.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// models lists every table the migrations create, for tests that check
+// the two agree.
+var models = []any{
+	&WebVital{},
+	&ReportToEntry{},
+	&SecurityReportEntry{},
+	&Issue{},
+	&WebVitalDailyRollup{},
+	&ReportDailyRollup{},
+	&RollupState{},
+	&Service{},
+}
+
+// dialectDir is the migrations subdirectory for d.
+func dialectDir(d *gorm.DB) string {
+	if d.Dialector.Name() == dialectSQLite {
+		return "sqlite"
+	}
+	return "postgres"
+}
+
+// loadMigrations returns the migrations for dialect ("postgres" or
+// "sqlite") ordered by version.
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s migrations: %w", dialect, err)
+	}
+	var out []Migration
+	for _, e := range entries {
+		base, ok := strings.CutSuffix(e.Name(), ".sql")
+		if !ok {
+			continue
+		}
+		num, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: want <version>_<name>.sql", e.Name())
+		}
+		raw, err := migrationFiles.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration %s: %w", e.Name(), err)
+		}
+		if n := len(out); n > 0 && out[n-1].Version == version {
+			return nil, fmt.Errorf("migrations %d_%s and %s share a version", version, out[n-1].Name, e.Name())
+		}
+		out = append(out, Migration{Version: version, Name: name, sql: string(raw)})
+	}
+	return out, nil
+}
+
+// Migrate applies every migration not yet recorded in schema_migrations,
+// in order, each in its own transaction. On Postgres it holds an advisory
+// lock throughout, so concurrent callers wait and then find nothing to do.
+// It returns the migrations it applied.
+func Migrate(ctx context.Context, d *gorm.DB) ([]Migration, error) {
+	dialect := dialectDir(d)
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := d.DB()
+	if err != nil {
+		return nil, fmt.Errorf("getting connection pool: %w", err)
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if dialect == "postgres" {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return nil, fmt.Errorf("acquiring migration lock: %w", err)
+		}
+		defer func() {
+			// Unlock even if ctx is done; closing the connection would
+			// release it anyway, but only once the pool drops it.
+			_, _ = conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLockID)
+		}()
+	}
+
+	if _, err := conn.ExecContext(ctx, schemaMigrationsDDL(dialect)); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	insert := "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, CURRENT_TIMESTAMP)"
+	if dialect == "postgres" {
+		insert = "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, CURRENT_TIMESTAMP)"
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		stmts := m.sql
+		if dialect == "sqlite" {
+			if stmts, err = sqliteAddColumns(ctx, tx, stmts); err != nil {
+				_ = tx.Rollback()
+				return done, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, stmts); err != nil {
+			_ = tx.Rollback()
+			return done, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, insert, m.Version, m.Name); err != nil {
+			_ = tx.Rollback()
+			return done, fmt.Errorf("recording migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return done, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// addColumnIfNotExists matches the Postgres statement SQLite lacks, which
+// the SQLite migrations use as well to adopt AutoMigrate's tables.
+var addColumnIfNotExists = regexp.MustCompile(`(?im)^ALTER TABLE (\w+) ADD COLUMN IF NOT EXISTS (\w+) ([^;]*);`)
+
+// sqliteAddColumns rewrites stmts' ADD COLUMN IF NOT EXISTS statements
+// into plain ADD COLUMN, dropping those whose column tx already has.
+func sqliteAddColumns(ctx context.Context, tx *sql.Tx, stmts string) (string, error) {
+	var b strings.Builder
+	last := 0
+	for _, m := range addColumnIfNotExists.FindAllStringSubmatchIndex(stmts, -1) {
+		table, column, def := stmts[m[2]:m[3]], stmts[m[4]:m[5]], stmts[m[6]:m[7]]
+		b.WriteString(stmts[last:m[0]])
+		last = m[1]
+		var n int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n); err != nil {
+			return "", fmt.Errorf("checking for %s.%s: %w", table, column, err)
+		}
+		if n == 0 {
+			fmt.Fprintf(&b, "ALTER TABLE %s ADD COLUMN %s %s;", table, column, def)
+		}
+	}
+	b.WriteString(stmts[last:])
+	return b.String(), nil
+}
+
+// GetMigrationStatus lists every known migration with when it was
+// applied, plus any recorded in the database that this build does not
+// know, which means the database is ahead of the binary.
+func GetMigrationStatus(ctx context.Context, d *gorm.DB) ([]MigrationStatus, error) {
+	dialect := dialectDir(d)
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := d.DB()
+	if err != nil {
+		return nil, fmt.Errorf("getting connection pool: %w", err)
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	applied := map[int]appliedMigration{}
+	if d.Migrator().HasTable("schema_migrations") {
+		if applied, err = appliedMigrations(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			st.AppliedAt = &a.at
+			delete(applied, m.Version)
+		}
+		out = append(out, st)
+	}
+	for v, a := range applied {
+		out = append(out, MigrationStatus{Version: v, Name: a.name + " (unknown to this build)", AppliedAt: &a.at})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+type appliedMigration struct {
+	name string
+	at   time.Time
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("querying schema_migrations: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	out := map[int]appliedMigration{}
+	for rows.Next() {
+		var v int
+		var a appliedMigration
+		if err := rows.Scan(&v, &a.name, &a.at); err != nil {
+			return nil, fmt.Errorf("querying schema_migrations: %w", err)
+		}
+		out[v] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("querying schema_migrations: %w", err)
+	}
+	return out, nil
+}
+
+func schemaMigrationsDDL(dialect string) string {
+	if dialect == "postgres" {
+		return "CREATE TABLE IF NOT EXISTS schema_migrations (version bigint PRIMARY KEY, name text NOT NULL, applied_at timestamptz NOT NULL)"
+	}
+	return "CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY, name text NOT NULL, applied_at datetime NOT NULL)"
+}
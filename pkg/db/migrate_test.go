@@ -0,0 +1,299 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	pg, err := loadMigrations("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lite, err := loadMigrations("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pg) == 0 || len(pg) != len(lite) {
+		t.Fatalf("got %d postgres and %d sqlite migrations, want the same non-zero number", len(pg), len(lite))
+	}
+	for i := range pg {
+		if pg[i].Version != i+1 || lite[i].Version != i+1 || pg[i].Name != lite[i].Name {
+			t.Errorf("migration %d = postgres %d_%s, sqlite %d_%s; want matching, contiguous versions",
+				i, pg[i].Version, pg[i].Name, lite[i].Version, lite[i].Name)
+		}
+	}
+}
+
+// columnsSinceAutoMigrate are model columns migrations after 0007 add,
+// which a database last set up by AutoMigrate does not have.
+var columnsSinceAutoMigrate = []struct {
+	model  any
+	column string
+}{
+	{&RollupState{}, "since"},
+	{&ReportToEntry{}, "blocked_host"},
+	{&ReportToEntry{}, "user_agent"},
+	{&ReportToEntry{}, "browser_family"},
+	{&SecurityReportEntry{}, "blocked_host"},
+	{&SecurityReportEntry{}, "user_agent"},
+	{&SecurityReportEntry{}, "browser_family"},
+	{&WebVital{}, "user_agent"},
+	{&WebVital{}, "browser_family"},
+	{&WebVital{}, "browser_major"},
+	{&WebVital{}, "os"},
+	{&WebVital{}, "device_class"},
+	{&ReportToEntry{}, "browser_major"},
+	{&ReportToEntry{}, "os"},
+	{&ReportToEntry{}, "device_class"},
+	{&SecurityReportEntry{}, "browser_major"},
+	{&SecurityReportEntry{}, "os"},
+	{&SecurityReportEntry{}, "device_class"},
+	{&ReportToEntry{}, "received_at"},
+	{&ReportToEntry{}, "occurred_at"},
+	{&SecurityReportEntry{}, "received_at"},
+	{&SecurityReportEntry{}, "occurred_at"},
+	{&Service{}, "sample_rates"},
+	{&WebVital{}, "sample_rate"},
+	{&ReportToEntry{}, "sample_rate"},
+	{&SecurityReportEntry{}, "sample_rate"},
+	{&Service{}, "noise_rules"},
+	{&ReportToEntry{}, "noise_rule"},
+	{&SecurityReportEntry{}, "noise_rule"},
+}
+
+// TestMigrateAdoptsAutoMigratedSQLite checks that a database set up by
+// GORM's AutoMigrate in the last release before migrations, which has
+// the columns of 0001 through 0007 already, takes them without error.
+func TestMigrateAdoptsAutoMigratedSQLite(t *testing.T) {
+	ctx := context.Background()
+	d, err := Connect(ctx, "sqlite://"+filepath.Join(t.TempDir(), "reportd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AutoMigrate(models...); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	for _, c := range columnsSinceAutoMigrate {
+		if err := d.Migrator().DropColumn(c.model, c.column); err != nil {
+			t.Fatalf("dropping %s: %v", c.column, err)
+		}
+	}
+	if _, err := Migrate(ctx, d); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	assertMigrations(ctx, t, d)
+}
+
+// The models as of the first release, frozen so the baseline migration
+// is checked against what that release's AutoMigrate really created.
+type (
+	baselineWebVital struct {
+		ID        uint           `gorm:"primaryKey"`
+		CreatedAt time.Time      `gorm:"index"`
+		DeletedAt gorm.DeletedAt `gorm:"index"`
+		Service   string         `gorm:"index;not null"`
+		Name      string         `gorm:"index;not null"`
+		Value     float64        `gorm:"not null"`
+		Delta     float64
+		VitalID   string
+		Label     string
+	}
+	baselineReportToEntry struct {
+		ID                 uint           `gorm:"primaryKey"`
+		CreatedAt          time.Time      `gorm:"index"`
+		DeletedAt          gorm.DeletedAt `gorm:"index"`
+		Service            string         `gorm:"index;not null"`
+		ReportType         string         `gorm:"index"`
+		DocumentURI        string
+		BlockedURI         string
+		ViolatedDirective  string
+		EffectiveDirective string
+		OriginalPolicy     string
+		SourceFile         string
+		LineNumber         int
+		ColumnNumber       int
+		StatusCode         int
+		RawJSON            string `gorm:"type:jsonb"`
+	}
+	baselineSecurityReportEntry struct {
+		ID                 uint           `gorm:"primaryKey"`
+		CreatedAt          time.Time      `gorm:"index"`
+		DeletedAt          gorm.DeletedAt `gorm:"index"`
+		Service            string         `gorm:"index;not null"`
+		ReportType         string         `gorm:"index;not null"`
+		URL                string
+		DocumentURI        string
+		BlockedURI         string
+		ViolatedDirective  string
+		EffectiveDirective string
+		SourceFile         string
+		LineNumber         int
+		ColumnNumber       int
+		Message            string
+		RawJSON            string `gorm:"type:jsonb"`
+	}
+)
+
+func (baselineWebVital) TableName() string            { return "web_vitals" }
+func (baselineReportToEntry) TableName() string       { return "report_to_entries" }
+func (baselineSecurityReportEntry) TableName() string { return "security_report_entries" }
+
+// TestMigrateAdoptsFirstReleaseSQLite checks that a database AutoMigrate
+// set up in the first release, before any of the columns 0002 onwards
+// add, migrates and keeps its rows.
+func TestMigrateAdoptsFirstReleaseSQLite(t *testing.T) {
+	ctx := context.Background()
+	d, err := Connect(ctx, "sqlite://"+filepath.Join(t.TempDir(), "reportd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AutoMigrate(&baselineWebVital{}, &baselineReportToEntry{}, &baselineSecurityReportEntry{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	old := &baselineSecurityReportEntry{CreatedAt: time.Now(), Service: "blog", ReportType: "deprecation", RawJSON: "{}"}
+	if err := d.Create(old).Error; err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	if _, err := Migrate(ctx, d); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	assertMigrations(ctx, t, d)
+
+	if err := SaveSecurityReportEntries(ctx, d, []*SecurityReportEntry{{Service: "blog", ReportType: "deprecation", RawJSON: "{}"}}); err != nil {
+		t.Fatalf("SaveSecurityReportEntries() after migrating error = %v", err)
+	}
+	var entries []SecurityReportEntry
+	if err := d.Where("service = ?", "blog").Order("id").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != old.ID || !entries[0].OccurredAt.Equal(entries[0].CreatedAt) {
+		t.Errorf("entries = %+v, want the first release's row, backfilled, and the new one", entries)
+	}
+}
+
+func TestMigrateStoresTimesInUTCSQLite(t *testing.T) {
+	ctx := context.Background()
+	d, err := Connect(ctx, "sqlite://"+filepath.Join(t.TempDir(), "reportd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(ctx, d); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	// Rows as a host in New York wrote them before 0015, then 0015 and 0016
+	// again.
+	for _, stmt := range []string{
+		"INSERT INTO web_vitals (service, name, value, created_at) VALUES ('blog', 'LCP', 0, '2025-11-01 10:00:00.25-04:00')",
+		"INSERT INTO web_vitals (service, name, value, created_at) VALUES ('blog', 'CLS', 0, '2025-11-02 23:30:00-05:00')",
+		"INSERT INTO web_vitals (service, name, value, created_at) VALUES ('blog', 'INP', 0, '2025-11-03 04:30:00+00:00')",
+		"INSERT INTO issues (service, fingerprint, count, first_seen, last_seen, resolved_at) VALUES ('blog', 'fp', 1, '2025-11-01 10:00:00-04:00', '2025-11-01 10:00:00-04:00', '2025-11-01 14:30:00+00:00')",
+		"DELETE FROM schema_migrations WHERE version IN (15, 16)",
+	} {
+		if err := d.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Migrate(ctx, d); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	var got []string
+	if err := d.Raw("SELECT CAST(created_at AS TEXT) FROM web_vitals ORDER BY id").Scan(&got).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2025-11-01 14:00:00.25+00:00", "2025-11-03 04:30:00+00:00", "2025-11-03 04:30:00+00:00"}
+	if !slices.Equal(got, want) {
+		t.Errorf("created_at = %q, want %q", got, want)
+	}
+	var issue []string
+	if err := d.Raw("SELECT CAST(last_seen AS TEXT) FROM issues WHERE last_seen < resolved_at").Scan(&issue).Error; err != nil || len(issue) != 1 || issue[0] != "2025-11-01 14:00:00+00:00" {
+		t.Errorf("issue last_seen = %q (err %v), want 14:00 UTC, before its resolution", issue, err)
+	}
+}
+
+func TestMigrationStatusBeforeMigrate(t *testing.T) {
+	ctx := context.Background()
+	d, err := Connect(ctx, "sqlite://"+filepath.Join(t.TempDir(), "reportd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := GetMigrationStatus(ctx, d)
+	if err != nil {
+		t.Fatalf("GetMigrationStatus() error = %v", err)
+	}
+	for _, st := range status {
+		if st.AppliedAt != nil {
+			t.Errorf("migration %d applied before Migrate", st.Version)
+		}
+	}
+}
+
+// assertMigrations checks every migration is recorded, a second Migrate
+// is a no-op, and the schema has every column and index the models
+// declare, so a model change without a migration fails here.
+func assertMigrations(ctx context.Context, t *testing.T, d *gorm.DB) {
+	t.Helper()
+	status, err := GetMigrationStatus(ctx, d)
+	if err != nil {
+		t.Fatalf("GetMigrationStatus() error = %v", err)
+	}
+	for _, st := range status {
+		if st.AppliedAt == nil {
+			t.Errorf("migration %d_%s not applied", st.Version, st.Name)
+		}
+	}
+	if applied, err := Migrate(ctx, d); err != nil || len(applied) != 0 {
+		t.Errorf("second Migrate() = %v, %v; want nothing to apply", applied, err)
+	}
+
+	m := d.Migrator()
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: d}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatalf("parsing %T: %v", model, err)
+		}
+		if !m.HasTable(model) {
+			t.Errorf("table %s missing", stmt.Schema.Table)
+			continue
+		}
+		for _, f := range stmt.Schema.Fields {
+			if f.DBName != "" && !m.HasColumn(model, f.DBName) {
+				t.Errorf("column %s.%s missing", stmt.Schema.Table, f.DBName)
+			}
+		}
+		for _, idx := range stmt.Schema.ParseIndexes() {
+			if !m.HasIndex(model, idx.Name) {
+				t.Errorf("index %s on %s missing", idx.Name, stmt.Schema.Table)
+			}
+		}
+	}
+}
+
+// assertConcurrentMigrate runs Migrate from several goroutines at once,
+// as replicas starting together would.
+func assertConcurrentMigrate(ctx context.Context, t *testing.T, d *gorm.DB) {
+	t.Helper()
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for range 4 {
+		wg.Go(func() {
+			_, err := Migrate(ctx, d)
+			errs <- err
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent Migrate() error = %v", err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+-- The schema as AutoMigrate created it before reportd tracked migrations.
+-- Every statement is IF NOT EXISTS, here and in the migrations that
+-- follow up to 0007, so a database AutoMigrate set up by any earlier
+-- release adopts them, whichever of their columns it already has.
+
+CREATE TABLE IF NOT EXISTS web_vitals (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    deleted_at timestamptz,
+    service text NOT NULL,
+    name text NOT NULL,
+    value decimal NOT NULL,
+    delta decimal,
+    vital_id text,
+    label text
+);
+CREATE INDEX IF NOT EXISTS idx_web_vitals_created_at ON web_vitals (created_at);
+CREATE INDEX IF NOT EXISTS idx_web_vitals_deleted_at ON web_vitals (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_web_vitals_service ON web_vitals (service);
+CREATE INDEX IF NOT EXISTS idx_web_vitals_name ON web_vitals (name);
+
+CREATE TABLE IF NOT EXISTS report_to_entries (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    deleted_at timestamptz,
+    service text NOT NULL,
+    report_type text,
+    document_uri text,
+    blocked_uri text,
+    violated_directive text,
+    effective_directive text,
+    original_policy text,
+    source_file text,
+    line_number bigint,
+    column_number bigint,
+    status_code bigint,
+    raw_json jsonb
+);
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_created_at ON report_to_entries (created_at);
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_deleted_at ON report_to_entries (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_service ON report_to_entries (service);
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_report_type ON report_to_entries (report_type);
+
+CREATE TABLE IF NOT EXISTS security_report_entries (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    deleted_at timestamptz,
+    service text NOT NULL,
+    report_type text NOT NULL,
+    url text,
+    document_uri text,
+    blocked_uri text,
+    violated_directive text,
+    effective_directive text,
+    source_file text,
+    line_number bigint,
+    column_number bigint,
+    message text,
+    raw_json jsonb
+);
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_created_at ON security_report_entries (created_at);
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_deleted_at ON security_report_entries (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_service ON security_report_entries (service);
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_report_type ON security_report_entries (report_type);
@@ -0,0 +1,21 @@
+-- Network Error Logging fields on both report tables. Reporting API
+-- reports gain status_code, which Report-To rows already had.
+
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS phase text;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS nel_type text;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS server_ip text;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS protocol text;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS method text;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS elapsed_time bigint;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS sampling_fraction decimal;
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_nel_type ON report_to_entries (nel_type);
+
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS status_code bigint;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS phase text;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS nel_type text;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS server_ip text;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS protocol text;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS method text;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS elapsed_time bigint;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS sampling_fraction decimal;
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_nel_type ON security_report_entries (nel_type);
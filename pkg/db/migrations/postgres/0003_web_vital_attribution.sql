@@ -0,0 +1,12 @@
+-- The web-vitals library's rating and navigation type, plus attribution:
+-- the element or URL responsible and the page's load state, with the
+-- full attribution object kept as JSON.
+
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS rating text;
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS navigation_type text;
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS attribution_target text;
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS attribution_url text;
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS load_state text;
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS attribution_json text;
+CREATE INDEX IF NOT EXISTS idx_web_vitals_rating ON web_vitals (rating);
+CREATE INDEX IF NOT EXISTS idx_web_vitals_navigation_type ON web_vitals (navigation_type);
@@ -0,0 +1,9 @@
+-- Issue triage: status and when an issue was resolved, regressed or is
+-- ignored until. Existing issues start out open.
+
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'open';
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS resolved_at timestamptz;
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS regressed_at timestamptz;
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS ignored_until timestamptz;
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS ignore_until_count bigint;
+CREATE INDEX IF NOT EXISTS idx_issues_status ON issues (status);
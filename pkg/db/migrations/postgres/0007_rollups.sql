@@ -0,0 +1,27 @@
+-- Daily rollups of Web Vitals and report counts, and how far they reach.
+
+CREATE TABLE IF NOT EXISTS web_vital_daily_rollups (
+    id bigserial PRIMARY KEY,
+    service text NOT NULL,
+    day date NOT NULL,
+    name text NOT NULL,
+    count bigint NOT NULL,
+    sum decimal NOT NULL,
+    sketch text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_web_vital_rollups_key ON web_vital_daily_rollups (service, day, name);
+
+CREATE TABLE IF NOT EXISTS report_daily_rollups (
+    id bigserial PRIMARY KEY,
+    service text NOT NULL,
+    day date NOT NULL,
+    report_type text NOT NULL,
+    count bigint NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_report_rollups_key ON report_daily_rollups (service, day, report_type);
+
+CREATE TABLE IF NOT EXISTS rollup_states (
+    name text PRIMARY KEY,
+    through date,
+    updated_at timestamptz
+);
@@ -0,0 +1,26 @@
+-- Columns and indexes behind /api/reports/{service}/search. The
+-- (service, created_at) index replaces the one on service alone and
+-- serves the newest-first scan every search pages through. URLs can
+-- outgrow a btree entry, so exact-match lookups on them use hash indexes.
+
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS blocked_host text;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS user_agent text;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS browser_family text;
+DROP INDEX IF EXISTS idx_report_to_entries_service;
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_service_created ON report_to_entries (service, created_at);
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_document_uri ON report_to_entries USING hash (document_uri);
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_source_file ON report_to_entries USING hash (source_file);
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_blocked_host ON report_to_entries (blocked_host);
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_browser_family ON report_to_entries (browser_family);
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_directive ON report_to_entries (service, (COALESCE(NULLIF(violated_directive, ''), effective_directive)));
+
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS blocked_host text;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS user_agent text;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS browser_family text;
+DROP INDEX IF EXISTS idx_security_report_entries_service;
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_service_created ON security_report_entries (service, created_at);
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_url ON security_report_entries USING hash (url);
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_source_file ON security_report_entries USING hash (source_file);
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_blocked_host ON security_report_entries (blocked_host);
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_browser_family ON security_report_entries (browser_family);
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_directive ON security_report_entries (service, (COALESCE(NULLIF(violated_directive, ''), effective_directive)));
@@ -0,0 +1,18 @@
+-- Parsed User-Agent columns on every table, behind the browser, OS and
+-- device breakdowns. The report tables have had user_agent and
+-- browser_family since 0009.
+
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS user_agent text;
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS browser_family text;
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS browser_major bigint;
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS os text;
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS device_class text;
+CREATE INDEX IF NOT EXISTS idx_web_vitals_browser_family ON web_vitals (browser_family);
+
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS browser_major bigint;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS os text;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS device_class text;
+
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS browser_major bigint;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS os text;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS device_class text;
@@ -0,0 +1,14 @@
+-- When a report was received and when the event it describes occurred,
+-- received less the report's age. Daily report counts bucket by the
+-- latter. Rows from before this migration have no age to go on, so both
+-- start out as created_at.
+
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS received_at timestamptz;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS occurred_at timestamptz;
+UPDATE report_to_entries SET received_at = created_at, occurred_at = created_at WHERE occurred_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_service_occurred ON report_to_entries (service, occurred_at);
+
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS received_at timestamptz;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS occurred_at timestamptz;
+UPDATE security_report_entries SET received_at = created_at, occurred_at = created_at WHERE occurred_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_service_occurred ON security_report_entries (service, occurred_at);
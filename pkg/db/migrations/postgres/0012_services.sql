@@ -0,0 +1,13 @@
+-- The service registry. allowed_origins holds a JSON array of origins or
+-- hostnames; archived services are hidden and, when registration is
+-- required, refused.
+
+CREATE TABLE IF NOT EXISTS services (
+    name text PRIMARY KEY,
+    display_name text,
+    owner text,
+    allowed_origins text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    archived_at timestamptz
+);
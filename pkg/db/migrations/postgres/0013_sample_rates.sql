@@ -0,0 +1,10 @@
+-- Ingest sampling. services.sample_rates holds a JSON object of event
+-- type to the fraction of events kept; each stored row records the rate
+-- it was kept at so counts can be scaled back up. Rows from before this
+-- migration were all kept.
+
+ALTER TABLE services ADD COLUMN IF NOT EXISTS sample_rates text;
+
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS sample_rate decimal NOT NULL DEFAULT 1;
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS sample_rate decimal NOT NULL DEFAULT 1;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS sample_rate decimal NOT NULL DEFAULT 1;
@@ -0,0 +1,7 @@
+-- Noise filtering. services.noise_rules holds a JSON array of the
+-- service's own rules; noise_rule names the rule that tagged a report.
+
+ALTER TABLE services ADD COLUMN IF NOT EXISTS noise_rules text;
+
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS noise_rule text;
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS noise_rule text;
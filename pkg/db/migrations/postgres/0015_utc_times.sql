@@ -0,0 +1,3 @@
+-- Times are stored in UTC. timestamptz columns already hold instants
+-- rather than text, so Postgres has nothing to convert; the SQLite
+-- migration of this version rewrites times stored with a UTC offset.
@@ -0,0 +1,2 @@
+-- Issue times are stored in UTC like report times. timestamptz columns
+-- already hold instants, so as with 0015 only SQLite has rows to convert.
@@ -0,0 +1,5 @@
+-- The page a Web Vital was measured on and the route it normalizes to.
+
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS page text;
+ALTER TABLE web_vitals ADD COLUMN IF NOT EXISTS route text;
+CREATE INDEX IF NOT EXISTS idx_web_vitals_route ON web_vitals (route);
@@ -0,0 +1,22 @@
+-- Reports grouped into issues by fingerprint.
+
+ALTER TABLE report_to_entries ADD COLUMN IF NOT EXISTS fingerprint text;
+CREATE INDEX IF NOT EXISTS idx_report_to_entries_fingerprint ON report_to_entries (fingerprint);
+
+ALTER TABLE security_report_entries ADD COLUMN IF NOT EXISTS fingerprint text;
+CREATE INDEX IF NOT EXISTS idx_security_report_entries_fingerprint ON security_report_entries (fingerprint);
+
+CREATE TABLE IF NOT EXISTS issues (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    service text NOT NULL,
+    fingerprint text NOT NULL,
+    report_type text,
+    title text,
+    first_seen datetime,
+    last_seen datetime,
+    count integer NOT NULL,
+    sample text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_issues_service_fingerprint ON issues (service, fingerprint);
+CREATE INDEX IF NOT EXISTS idx_issues_report_type ON issues (report_type);
+CREATE INDEX IF NOT EXISTS idx_issues_last_seen ON issues (last_seen);
@@ -0,0 +1,3 @@
+-- The first day the rollup tables hold, so ranges reaching further back
+-- are read from raw rows.
+ALTER TABLE rollup_states ADD COLUMN since date;
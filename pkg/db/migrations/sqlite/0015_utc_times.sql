@@ -0,0 +1,22 @@
+-- Times are stored in UTC. SQLite keeps them as text written with the
+-- host's UTC offset and compares them as text, so rows written on a host
+-- not running in UTC are rewritten with the same instant in UTC.
+
+UPDATE web_vitals SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) || substr(created_at, 20, length(created_at) - 25) || '+00:00' WHERE created_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND created_at NOT GLOB '*+00:00';
+UPDATE web_vitals SET deleted_at = strftime('%Y-%m-%d %H:%M:%S', deleted_at) || substr(deleted_at, 20, length(deleted_at) - 25) || '+00:00' WHERE deleted_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND deleted_at NOT GLOB '*+00:00';
+
+UPDATE report_to_entries SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) || substr(created_at, 20, length(created_at) - 25) || '+00:00' WHERE created_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND created_at NOT GLOB '*+00:00';
+UPDATE report_to_entries SET deleted_at = strftime('%Y-%m-%d %H:%M:%S', deleted_at) || substr(deleted_at, 20, length(deleted_at) - 25) || '+00:00' WHERE deleted_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND deleted_at NOT GLOB '*+00:00';
+UPDATE report_to_entries SET received_at = strftime('%Y-%m-%d %H:%M:%S', received_at) || substr(received_at, 20, length(received_at) - 25) || '+00:00' WHERE received_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND received_at NOT GLOB '*+00:00';
+UPDATE report_to_entries SET occurred_at = strftime('%Y-%m-%d %H:%M:%S', occurred_at) || substr(occurred_at, 20, length(occurred_at) - 25) || '+00:00' WHERE occurred_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND occurred_at NOT GLOB '*+00:00';
+
+UPDATE security_report_entries SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) || substr(created_at, 20, length(created_at) - 25) || '+00:00' WHERE created_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND created_at NOT GLOB '*+00:00';
+UPDATE security_report_entries SET deleted_at = strftime('%Y-%m-%d %H:%M:%S', deleted_at) || substr(deleted_at, 20, length(deleted_at) - 25) || '+00:00' WHERE deleted_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND deleted_at NOT GLOB '*+00:00';
+UPDATE security_report_entries SET received_at = strftime('%Y-%m-%d %H:%M:%S', received_at) || substr(received_at, 20, length(received_at) - 25) || '+00:00' WHERE received_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND received_at NOT GLOB '*+00:00';
+UPDATE security_report_entries SET occurred_at = strftime('%Y-%m-%d %H:%M:%S', occurred_at) || substr(occurred_at, 20, length(occurred_at) - 25) || '+00:00' WHERE occurred_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND occurred_at NOT GLOB '*+00:00';
+
+UPDATE rollup_states SET updated_at = strftime('%Y-%m-%d %H:%M:%S', updated_at) || substr(updated_at, 20, length(updated_at) - 25) || '+00:00' WHERE updated_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND updated_at NOT GLOB '*+00:00';
+
+UPDATE services SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) || substr(created_at, 20, length(created_at) - 25) || '+00:00' WHERE created_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND created_at NOT GLOB '*+00:00';
+UPDATE services SET updated_at = strftime('%Y-%m-%d %H:%M:%S', updated_at) || substr(updated_at, 20, length(updated_at) - 25) || '+00:00' WHERE updated_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND updated_at NOT GLOB '*+00:00';
+UPDATE services SET archived_at = strftime('%Y-%m-%d %H:%M:%S', archived_at) || substr(archived_at, 20, length(archived_at) - 25) || '+00:00' WHERE archived_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND archived_at NOT GLOB '*+00:00';
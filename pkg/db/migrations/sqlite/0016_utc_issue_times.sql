@@ -0,0 +1,8 @@
+-- Issue times are stored in UTC like report times (see 0015), since the
+-- triage upsert compares last_seen with resolved_at and ignored_until.
+
+UPDATE issues SET first_seen = strftime('%Y-%m-%d %H:%M:%S', first_seen) || substr(first_seen, 20, length(first_seen) - 25) || '+00:00' WHERE first_seen GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND first_seen NOT GLOB '*+00:00';
+UPDATE issues SET last_seen = strftime('%Y-%m-%d %H:%M:%S', last_seen) || substr(last_seen, 20, length(last_seen) - 25) || '+00:00' WHERE last_seen GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND last_seen NOT GLOB '*+00:00';
+UPDATE issues SET resolved_at = strftime('%Y-%m-%d %H:%M:%S', resolved_at) || substr(resolved_at, 20, length(resolved_at) - 25) || '+00:00' WHERE resolved_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND resolved_at NOT GLOB '*+00:00';
+UPDATE issues SET regressed_at = strftime('%Y-%m-%d %H:%M:%S', regressed_at) || substr(regressed_at, 20, length(regressed_at) - 25) || '+00:00' WHERE regressed_at GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND regressed_at NOT GLOB '*+00:00';
+UPDATE issues SET ignored_until = strftime('%Y-%m-%d %H:%M:%S', ignored_until) || substr(ignored_until, 20, length(ignored_until) - 25) || '+00:00' WHERE ignored_until GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND ignored_until NOT GLOB '*+00:00';
@@ -6,53 +6,166 @@ import (
 	"gorm.io/gorm"
 )
 
-// WebVital is a row from POST /analytics.
+// Agent is the sender's User-Agent and what useragent.Parse makes of it.
+// Every table embeds it so rows can be broken down by browser, OS and
+// device.
+type Agent struct {
+	UserAgent     string `json:"user_agent,omitempty"`
+	BrowserFamily string `gorm:"index" json:"browser_family,omitempty"`
+	BrowserMajor  int    `json:"browser_major,omitempty"`
+	OS            string `json:"os,omitempty"`
+	DeviceClass   string `json:"device_class,omitempty"`
+}
+
+// WebVital is a row from POST /analytics. AttributionTarget and
+// AttributionURL are lifted out of the attribution build's payload for
+// grouping; AttributionJSON keeps the full breakdown. SampleRate is the
+// fraction of the service's Web Vitals ingest kept, so each row stands
+// for 1/SampleRate samples.
 type WebVital struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	CreatedAt time.Time      `gorm:"index" json:"created_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
-	Service   string         `gorm:"index;not null" json:"service"`
-	Name      string         `gorm:"index;not null" json:"name"`
-	Value     float64        `gorm:"not null" json:"value"`
-	Delta     float64        `json:"delta"`
-	VitalID   string         `json:"vital_id"`
-	Label     string         `json:"label"`
-}
-
-// ReportToEntry is a row from POST /report (legacy Report-To API).
+	ID                uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt         time.Time      `gorm:"index" json:"created_at"`
+	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
+	Service           string         `gorm:"index;not null" json:"service"`
+	Name              string         `gorm:"index;not null" json:"name"`
+	Value             float64        `gorm:"not null" json:"value"`
+	Delta             float64        `json:"delta"`
+	VitalID           string         `json:"vital_id"`
+	Label             string         `json:"label"`
+	Rating            string         `gorm:"index" json:"rating"`
+	NavigationType    string         `gorm:"index" json:"navigation_type"`
+	AttributionTarget string         `json:"attribution_target"`
+	AttributionURL    string         `json:"attribution_url"`
+	LoadState         string         `json:"load_state"`
+	AttributionJSON   string         `gorm:"type:text" json:"attribution_json"`
+	Page              string         `json:"page"`
+	Route             string         `gorm:"index" json:"route"`
+	SampleRate        float64        `gorm:"not null;default:1" json:"sample_rate"`
+	Agent
+}
+
+// ReportToEntry is a row from POST /report (legacy Report-To API). The
+// NEL columns (Phase through SamplingFraction) are only set for
+// network-error reports. Fingerprint links the row to its Issue;
+// BlockedHost and the Agent columns are derived on save. OccurredAt is
+// ReceivedAt less the report's age, when the browser sent one; both
+// default to CreatedAt. SampleRate is the fraction of the service's
+// reports of this type ingest kept, unlike SamplingFraction, which is the
+// browser's own NEL sampling. NoiseRule names the noise rule that tagged
+// the row, if any.
 type ReportToEntry struct {
 	ID                 uint           `gorm:"primaryKey" json:"id"`
-	CreatedAt          time.Time      `gorm:"index" json:"created_at"`
+	CreatedAt          time.Time      `gorm:"index;index:idx_report_to_entries_service_created,priority:2" json:"created_at"`
+	ReceivedAt         time.Time      `json:"received_at"`
+	OccurredAt         time.Time      `gorm:"index:idx_report_to_entries_service_occurred,priority:2" json:"occurred_at"`
 	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
-	Service            string         `gorm:"index;not null" json:"service"`
+	Service            string         `gorm:"index:idx_report_to_entries_service_created,priority:1;index:idx_report_to_entries_service_occurred,priority:1;not null" json:"service"`
 	ReportType         string         `gorm:"index" json:"report_type"`
-	DocumentURI        string         `json:"document_uri"`
+	DocumentURI        string         `gorm:"index" json:"document_uri"`
 	BlockedURI         string         `json:"blocked_uri"`
+	BlockedHost        string         `gorm:"index" json:"blocked_host,omitempty"`
 	ViolatedDirective  string         `json:"violated_directive"`
 	EffectiveDirective string         `json:"effective_directive"`
 	OriginalPolicy     string         `json:"original_policy"`
-	SourceFile         string         `json:"source_file"`
+	SourceFile         string         `gorm:"index" json:"source_file"`
 	LineNumber         int            `json:"line_number"`
 	ColumnNumber       int            `json:"column_number"`
 	StatusCode         int            `json:"status_code"`
-	RawJSON            string         `gorm:"type:jsonb" json:"raw_json,omitempty"`
+	Phase              string         `json:"phase,omitempty"`
+	NELType            string         `gorm:"column:nel_type;index" json:"nel_type,omitempty"`
+	ServerIP           string         `json:"server_ip,omitempty"`
+	Protocol           string         `json:"protocol,omitempty"`
+	Method             string         `json:"method,omitempty"`
+	ElapsedTime        int64          `json:"elapsed_time,omitempty"`
+	SamplingFraction   float64        `json:"sampling_fraction,omitempty"`
+	SampleRate         float64        `gorm:"not null;default:1" json:"sample_rate"`
+	Agent
+	RawJSON     string `gorm:"type:jsonb" json:"raw_json,omitempty"`
+	Fingerprint string `gorm:"index;size:32" json:"fingerprint,omitempty"`
+	NoiseRule   string `json:"noise_rule,omitempty"`
+
+	// issueTitle names the row's issue; set alongside Fingerprint by the
+	// converters and not stored.
+	issueTitle string
 }
 
 // SecurityReportEntry is a row from POST /reporting (Reporting API v1).
+// The NEL columns, Fingerprint, the search columns, SampleRate, NoiseRule
+// and the received and occurred times match ReportToEntry's.
 type SecurityReportEntry struct {
 	ID                 uint           `gorm:"primaryKey" json:"id"`
-	CreatedAt          time.Time      `gorm:"index" json:"created_at"`
+	CreatedAt          time.Time      `gorm:"index;index:idx_security_report_entries_service_created,priority:2" json:"created_at"`
+	ReceivedAt         time.Time      `json:"received_at"`
+	OccurredAt         time.Time      `gorm:"index:idx_security_report_entries_service_occurred,priority:2" json:"occurred_at"`
 	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
-	Service            string         `gorm:"index;not null" json:"service"`
+	Service            string         `gorm:"index:idx_security_report_entries_service_created,priority:1;index:idx_security_report_entries_service_occurred,priority:1;not null" json:"service"`
 	ReportType         string         `gorm:"index;not null" json:"report_type"`
-	URL                string         `json:"url"`
+	URL                string         `gorm:"index" json:"url"`
 	DocumentURI        string         `json:"document_uri"`
 	BlockedURI         string         `json:"blocked_uri"`
+	BlockedHost        string         `gorm:"index" json:"blocked_host,omitempty"`
 	ViolatedDirective  string         `json:"violated_directive"`
 	EffectiveDirective string         `json:"effective_directive"`
-	SourceFile         string         `json:"source_file"`
+	SourceFile         string         `gorm:"index" json:"source_file"`
 	LineNumber         int            `json:"line_number"`
 	ColumnNumber       int            `json:"column_number"`
 	Message            string         `json:"message"`
-	RawJSON            string         `gorm:"type:jsonb" json:"raw_json,omitempty"`
+	StatusCode         int            `json:"status_code,omitempty"`
+	Phase              string         `json:"phase,omitempty"`
+	NELType            string         `gorm:"column:nel_type;index" json:"nel_type,omitempty"`
+	ServerIP           string         `json:"server_ip,omitempty"`
+	Protocol           string         `json:"protocol,omitempty"`
+	Method             string         `json:"method,omitempty"`
+	ElapsedTime        int64          `json:"elapsed_time,omitempty"`
+	SamplingFraction   float64        `json:"sampling_fraction,omitempty"`
+	SampleRate         float64        `gorm:"not null;default:1" json:"sample_rate"`
+	Agent
+	RawJSON     string `gorm:"type:jsonb" json:"raw_json,omitempty"`
+	Fingerprint string `gorm:"index;size:32" json:"fingerprint,omitempty"`
+	NoiseRule   string `json:"noise_rule,omitempty"`
+
+	// issueTitle names the row's issue; set alongside Fingerprint by the
+	// converters and not stored.
+	issueTitle string
+}
+
+// defaultSampleRate treats an unset sample rate as everything kept.
+func defaultSampleRate(rate *float64) {
+	if *rate <= 0 {
+		*rate = 1
+	}
+}
+
+// reportTimes fills in unset received and occurred times: received
+// defaults to created, itself defaulting to now, and occurred to received.
+func reportTimes(created, received, occurred *time.Time) {
+	if created.IsZero() {
+		*created = time.Now()
+	}
+	if received.IsZero() {
+		*received = *created
+	}
+	if occurred.IsZero() {
+		*occurred = *received
+	}
+}
+
+// BeforeCreate implements gorm's BeforeCreate hook.
+func (e *ReportToEntry) BeforeCreate(*gorm.DB) error {
+	reportTimes(&e.CreatedAt, &e.ReceivedAt, &e.OccurredAt)
+	defaultSampleRate(&e.SampleRate)
+	return nil
+}
+
+// BeforeCreate implements gorm's BeforeCreate hook.
+func (e *SecurityReportEntry) BeforeCreate(*gorm.DB) error {
+	reportTimes(&e.CreatedAt, &e.ReceivedAt, &e.OccurredAt)
+	defaultSampleRate(&e.SampleRate)
+	return nil
+}
+
+// BeforeCreate implements gorm's BeforeCreate hook.
+func (v *WebVital) BeforeCreate(*gorm.DB) error {
+	defaultSampleRate(&v.SampleRate)
+	return nil
 }
@@ -0,0 +1,224 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Percentile selects which quantile the Web Vitals helpers compute.
+type Percentile int
+
+// Supported percentiles.
+const (
+	P50 Percentile = 50
+	P75 Percentile = 75
+	P95 Percentile = 95
+)
+
+// DefaultPercentile is p75, the quantile Google's Web Vitals thresholds
+// are defined against.
+const DefaultPercentile = P75
+
+// ParsePercentile accepts "p50", "p75", "p95" (or the bare numbers);
+// empty means DefaultPercentile.
+func ParsePercentile(s string) (Percentile, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "p") {
+	case "":
+		return DefaultPercentile, nil
+	case "50":
+		return P50, nil
+	case "75":
+		return P75, nil
+	case "95":
+		return P95, nil
+	default:
+		return 0, fmt.Errorf("unsupported percentile %q: want p50, p75 or p95", s)
+	}
+}
+
+// String returns p as "p75".
+func (p Percentile) String() string {
+	return fmt.Sprintf("p%d", int(p))
+}
+
+func (p Percentile) fraction() float64 {
+	return float64(p) / 100
+}
+
+// percentileGroup is one group's key values (in keyExprs order), row
+// count, scaled up like sampledCount, and percentile.
+type percentileGroup struct {
+	Keys  []string
+	Count int64
+	Value float64
+}
+
+// vitalPercentiles computes p over web_vitals.value for every distinct
+// combination of keyExprs matching where (a condition with args, or a
+// *gorm.DB scope), ordered by key. Each row stands for 1/sample_rate
+// samples, as in sampledCount, and p interpolates between ranks like
+// percentile_cont over the samples that stand for. Postgres ranks rows
+// by their cumulative weight; SQLite, without FLOOR, falls back to
+// groupedPercentiles.
+func vitalPercentiles(ctx context.Context, d *gorm.DB, p Percentile, keyExprs []string, where any, args ...any) ([]percentileGroup, error) {
+	if d.Dialector.Name() == dialectSQLite {
+		return groupedPercentiles(ctx, d, p, keyExprs, where, args...)
+	}
+
+	partition := strings.Join(keyExprs, ", ")
+	inner := make([]string, 0, len(keyExprs)+3)
+	keys := make([]string, 0, len(keyExprs))
+	for i, expr := range keyExprs {
+		inner = append(inner, fmt.Sprintf("%s AS k%d", expr, i))
+		keys = append(keys, fmt.Sprintf("k%d", i))
+	}
+	inner = append(inner, "value",
+		"SUM(1.0 / sample_rate) OVER (PARTITION BY "+partition+" ORDER BY value ROWS UNBOUNDED PRECEDING) AS cum",
+		"SUM(1.0 / sample_rate) OVER (PARTITION BY "+partition+") AS total")
+	ranked := d.Model(&WebVital{}).Select(strings.Join(inner, ", ")).Where(where, args...)
+
+	// The value at rank r is the first whose cumulative weight exceeds r.
+	f := p.fraction()
+	lo := fmt.Sprintf("MIN(value) FILTER (WHERE cum > FLOOR(%g * (total - 1)))", f)
+	hi := fmt.Sprintf("MIN(value) FILTER (WHERE cum > CEIL(%g * (total - 1)))", f)
+	pos := fmt.Sprintf("%g * (MAX(total) - 1)", f)
+	value := fmt.Sprintf("%s + (%s - %s) * (%s - FLOOR(%s))", lo, hi, lo, pos, pos)
+
+	order := strings.Join(keys, ", ")
+	rows, err := d.WithContext(ctx).
+		Table("(?) AS ranked", ranked).
+		Select(order + ", CAST(ROUND(MAX(total)) AS BIGINT) AS count, " + value + " AS value").
+		Group(order).
+		Order(order).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []percentileGroup
+	dest := make([]any, len(keyExprs)+2)
+	keyVals := make([]sql.NullString, len(keyExprs))
+	for i := range keyVals {
+		dest[i] = &keyVals[i]
+	}
+	for rows.Next() {
+		var g percentileGroup
+		dest[len(keyExprs)], dest[len(keyExprs)+1] = &g.Count, &g.Value
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		g.Keys = make([]string, len(keyVals))
+		for i, k := range keyVals {
+			g.Keys[i] = k.String
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// groupedPercentiles is vitalPercentiles computed in Go, streaming rows
+// sorted by key and value so only one group's values are held at a time.
+func groupedPercentiles(ctx context.Context, d *gorm.DB, p Percentile, keyExprs []string, where any, args ...any) ([]percentileGroup, error) {
+	selects := make([]string, 0, len(keyExprs)+1)
+	for i, expr := range keyExprs {
+		selects = append(selects, fmt.Sprintf("%s AS k%d", expr, i))
+	}
+	selects = append(selects, "value", "sample_rate")
+	order := strings.Join(append(append([]string{}, keyExprs...), "value"), ", ")
+
+	rows, err := d.WithContext(ctx).
+		Model(&WebVital{}).
+		Select(strings.Join(selects, ", ")).
+		Where(where, args...).
+		Order(order).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var (
+		out     []percentileGroup
+		cur     []string
+		values  []float64
+		weights []float64
+		weight  float64
+	)
+	flush := func() {
+		if len(values) > 0 {
+			out = append(out, percentileGroup{Keys: cur, Count: int64(math.Round(weight)), Value: percentileOf(values, weights, p)})
+		}
+	}
+
+	keys := make([]sql.NullString, len(keyExprs))
+	dest := make([]any, 0, len(keyExprs)+1)
+	for i := range keys {
+		dest = append(dest, &keys[i])
+	}
+	var value, rate float64
+	dest = append(dest, &value, &rate)
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make([]string, len(keys))
+		for i, k := range keys {
+			row[i] = k.String
+		}
+		if !slices.Equal(row, cur) {
+			flush()
+			cur = row
+			values, weights = values[:0], weights[:0]
+			weight = 0
+		}
+		values = append(values, value)
+		weights = append(weights, 1/rate)
+		weight += 1 / rate
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return out, nil
+}
+
+// percentileOf linearly interpolates p over sorted, like percentile_cont,
+// with each value standing for weights[i] values; nil weights are all 1.
+func percentileOf(sorted, weights []float64, p Percentile) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	weight := func(i int) float64 {
+		if weights == nil {
+			return 1
+		}
+		return weights[i]
+	}
+	var total float64
+	for i := range sorted {
+		total += weight(i)
+	}
+	// valueAt returns the value at rank, counting each value weight times.
+	valueAt := func(rank float64) float64 {
+		var seen float64
+		for i, v := range sorted {
+			seen += weight(i)
+			if rank < seen {
+				return v
+			}
+		}
+		return sorted[len(sorted)-1]
+	}
+	pos := p.fraction() * (total - 1)
+	lo, hi := math.Floor(pos), math.Ceil(pos)
+	vlo, vhi := valueAt(lo), valueAt(hi)
+	return vlo + (vhi-vlo)*(pos-lo)
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestParsePercentile(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Percentile
+		wantErr bool
+	}{
+		{"", DefaultPercentile, false},
+		{"p50", P50, false},
+		{"P75", P75, false},
+		{"95", P95, false},
+		{" p95 ", P95, false},
+		{"p99", 0, true},
+		{"median", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePercentile(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePercentile(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePercentile(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPercentileOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		sorted  []float64
+		weights []float64
+		p       Percentile
+		want    float64
+	}{
+		{"empty", nil, nil, P75, 0},
+		{"single", []float64{42}, nil, P95, 42},
+		{"median even", []float64{1, 2, 3, 4}, nil, P50, 2.5},
+		{"p75 interpolated", []float64{1, 2, 3, 4}, nil, P75, 3.25},
+		{"p95 skewed", []float64{100, 100, 100, 100, 10000}, nil, P95, 8020},
+		{"unit weights", []float64{1, 2, 3, 4}, []float64{1, 1, 1, 1}, P75, 3.25},
+		{"weighted median", []float64{100, 1000}, []float64{1, 4}, P50, 1000},
+		{"weighted interpolated", []float64{1, 2}, []float64{2, 2}, P50, 1.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentileOf(tt.sorted, tt.weights, tt.p); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("percentileOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// seedWeightedPercentileFixtures stores an LCP of 100ms kept in full and
+// one of 1000ms kept at 1/4, which stands for four such samples.
+func seedWeightedPercentileFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	for _, wv := range []*WebVital{
+		{CreatedAt: time.Now(), Service: service, Name: "LCP", Value: 100, Rating: "good", NavigationType: "navigate", SampleRate: 1},
+		{CreatedAt: time.Now(), Service: service, Name: "LCP", Value: 1000, Rating: "good", NavigationType: "navigate", SampleRate: 0.25},
+	} {
+		if err := d.Create(wv).Error; err != nil {
+			t.Fatalf("seed web vital: %v", err)
+		}
+	}
+}
+
+// assertWeightedPercentiles checks that percentiles, raw and rolled up,
+// count each row 1/sample_rate times: p50 is 1000ms, where unweighted
+// rows would give 550ms.
+func assertWeightedPercentiles(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+
+	pcts, err := GetWebVitalPercentiles(ctx, d, service, P50, TimeRange{})
+	if err != nil {
+		t.Fatalf("GetWebVitalPercentiles() error = %v", err)
+	}
+	if len(pcts) != 1 || pcts[0].Value != 1000 {
+		t.Errorf("GetWebVitalPercentiles() = %+v, want LCP p50 1000", pcts)
+	}
+
+	byNav, err := GetWebVitalsByNavigationType(ctx, d, service, P50)
+	if err != nil {
+		t.Fatalf("GetWebVitalsByNavigationType() error = %v", err)
+	}
+	if len(byNav) != 1 || byNav[0].Count != 5 || byNav[0].Value != 1000 {
+		t.Errorf("GetWebVitalsByNavigationType() = %+v, want 5 samples with p50 1000", byNav)
+	}
+
+	health, err := GetAllServicesHealth(ctx, d, P50)
+	if err != nil {
+		t.Fatalf("GetAllServicesHealth() error = %v", err)
+	}
+	if h := health[service]; len(h) != 1 || h[0].Value != 1000 {
+		t.Errorf("GetAllServicesHealth()[%q] = %+v, want LCP p50 1000", service, h)
+	}
+
+	if err := RollupDay(ctx, d, time.Now()); err != nil {
+		t.Fatalf("RollupDay() error = %v", err)
+	}
+	var rollup WebVitalDailyRollup
+	if err := d.WithContext(ctx).Where("service = ?", service).Take(&rollup).Error; err != nil {
+		t.Fatalf("reading web vital rollup: %v", err)
+	}
+	sketch, err := decodeSketch(rollup.Sketch)
+	if err != nil {
+		t.Fatalf("decodeSketch() error = %v", err)
+	}
+	if got := sketch.quantile(P50); math.Abs(got-1000) > 1000*sketchAccuracy {
+		t.Errorf("rollup sketch p50 = %v, want 1000 within %g%%", got, sketchAccuracy*100)
+	}
+}
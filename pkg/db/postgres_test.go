@@ -31,15 +31,87 @@ func TestConnectPostgresAndQueryHelpers(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Connect() error = %v", err)
 	}
-	if err := AutoMigrate(ctx, d); err != nil {
-		t.Fatalf("AutoMigrate() error = %v", err)
+	if _, err := Migrate(ctx, d); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
 	}
+	assertMigrations(ctx, t, d)
+	assertConcurrentMigrate(ctx, t, d)
 
 	service := "test-" + randHex(t, 8)
 	t.Cleanup(func() { cleanupService(t, d, service) })
 
 	seedQueryFixtures(t, d, service)
 	assertQueryHelpers(ctx, t, d, service)
+
+	nelService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, nelService) })
+	seedNELFixtures(t, d, nelService)
+	assertNELSummary(ctx, t, d, nelService)
+
+	vitalsService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, vitalsService) })
+	seedVitalBreakdownFixtures(t, d, vitalsService)
+	assertVitalBreakdowns(ctx, t, d, vitalsService)
+
+	issueService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, issueService) })
+	seedIssueFixtures(t, d, issueService)
+	assertIssues(ctx, t, d, issueService)
+
+	triageService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, triageService) })
+	assertIssueTriage(ctx, t, d, triageService)
+
+	retentionService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, retentionService) })
+	seedRetentionFixtures(t, d, retentionService)
+	assertPurge(ctx, t, d, retentionService)
+
+	timeRangeService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, timeRangeService) })
+	seedTimeRangeFixtures(t, d, timeRangeService)
+	assertTimeRange(ctx, t, d, timeRangeService)
+
+	searchService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, searchService) })
+	seedSearchFixtures(t, d, searchService)
+	assertSearch(ctx, t, d, searchService)
+
+	exportService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, exportService) })
+	seedExportFixtures(t, d, exportService)
+	assertExport(ctx, t, d, exportService)
+
+	agentService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, agentService) })
+	seedAgentFixtures(t, d, agentService)
+	assertAgentBreakdowns(ctx, t, d, agentService)
+
+	occurredService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, occurredService) })
+	seedOccurredFixtures(t, d, occurredService)
+	assertOccurredCounts(ctx, t, d, occurredService)
+
+	samplingService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, samplingService) })
+	seedSamplingFixtures(t, d, samplingService)
+	assertSampledCounts(ctx, t, d, samplingService)
+
+	weightedService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, weightedService) })
+	seedWeightedPercentileFixtures(t, d, weightedService)
+	assertWeightedPercentiles(ctx, t, d, weightedService)
+
+	registryService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, registryService) })
+	seedServiceFixtures(t, d, registryService)
+	assertServiceRegistry(ctx, t, d, registryService)
+
+	rollupService := "test-" + randHex(t, 8)
+	t.Cleanup(func() { cleanupService(t, d, rollupService) })
+	seedRollupFixtures(t, d, rollupService)
+	assertRollups(ctx, t, d, rollupService)
+	assertConcurrentRollups(ctx, t, d, rollupService)
 }
 
 func randHex(t *testing.T, n int) string {
@@ -53,9 +125,12 @@ func randHex(t *testing.T, n int) string {
 
 func cleanupService(t *testing.T, d *gorm.DB, service string) {
 	t.Helper()
-	for _, model := range []any{&WebVital{}, &ReportToEntry{}, &SecurityReportEntry{}} {
+	for _, model := range []any{&WebVital{}, &ReportToEntry{}, &SecurityReportEntry{}, &Issue{}, &WebVitalDailyRollup{}, &ReportDailyRollup{}} {
 		if err := d.Unscoped().Where("service = ?", service).Delete(model).Error; err != nil {
 			t.Logf("cleanup %T for service %q: %v", model, service, err)
 		}
 	}
+	if err := d.Where("name = ?", service).Delete(&Service{}).Error; err != nil {
+		t.Logf("cleanup service %q: %v", service, err)
+	}
 }
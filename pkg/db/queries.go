@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"database/sql/driver"
 	"encoding/json"
 	"fmt"
 	"sort"
@@ -10,6 +11,10 @@ import (
 	"gorm.io/gorm"
 )
 
+// sampledCount counts rows as the events they stand for: a row kept at a
+// sample rate of 0.1 counts ten times, so totals survive ingest sampling.
+const sampledCount = "CAST(ROUND(SUM(1.0 / sample_rate)) AS BIGINT)"
+
 // Day is a date-only value that scans cleanly from Postgres (date →
 // time.Time) and SQLite (DATE() → string) and marshals as "YYYY-MM-DD".
 type Day time.Time
@@ -19,6 +24,12 @@ func (d Day) MarshalJSON() ([]byte, error) {
 	return json.Marshal(time.Time(d).Format(time.DateOnly))
 }
 
+// Value stores d as "YYYY-MM-DD", which both Postgres date columns and
+// SQLite accept.
+func (d Day) Value() (driver.Value, error) {
+	return time.Time(d).Format(time.DateOnly), nil
+}
+
 // Scan accepts nil, time.Time, []byte, or string (date-only or ISO
 // timestamp; only the date prefix is used).
 func (d *Day) Scan(v any) error {
@@ -47,32 +58,55 @@ func (d *Day) Scan(v any) error {
 	}
 }
 
-// WebVitalDailySummary is one (service, metric, day) average.
+// WebVitalDailySummary is one (service, metric, bucket) percentile.
+// Bucket is when the bucket starts and Day the local date it starts on;
+// with the default daily interval they name the same day.
 type WebVitalDailySummary struct {
-	Day     Day     `json:"day"`
-	Service string  `json:"service"`
-	Name    string  `json:"name"`
-	Value   float64 `json:"value"`
+	Bucket  time.Time `json:"bucket"`
+	Day     Day       `json:"day"`
+	Service string    `json:"service"`
+	Name    string    `json:"name"`
+	Value   float64   `json:"value"`
+}
+
+// WebVitalPercentile is one metric's percentile over a time range.
+type WebVitalPercentile struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+// VitalRatingCount is how many samples of one metric landed in one
+// rating bucket, and their share of that metric's rated samples.
+type VitalRatingCount struct {
+	Name   string  `json:"name"`
+	Rating string  `json:"rating"`
+	Count  int64   `json:"count"`
+	Share  float64 `json:"share"`
 }
 
-// WebVitalAverage is one metric's trailing-28-day average.
-type WebVitalAverage struct {
+// VitalGroupPercentile is one metric's percentile within a group, such
+// as a navigation type or an attribution target.
+type VitalGroupPercentile struct {
 	Name  string  `json:"name"`
+	Key   string  `json:"key"`
+	Count int64   `json:"count"`
 	Value float64 `json:"value"`
 }
 
-// ReportDailyCount is the count of one report type on one day.
+// ReportDailyCount is the count of one report type in one bucket, with
+// Bucket and Day as in WebVitalDailySummary.
 type ReportDailyCount struct {
-	Day        Day    `json:"day"`
-	ReportType string `json:"report_type"`
-	Count      int64  `json:"count"`
+	Bucket     time.Time `json:"bucket"`
+	Day        Day       `json:"day"`
+	ReportType string    `json:"report_type"`
+	Count      int64     `json:"count"`
 }
 
-// ServiceHealth is one (metric, average) pair for a service.
+// ServiceHealth is one (metric, percentile) pair for a service.
 type ServiceHealth struct {
 	Service string  `json:"service"`
 	Metric  string  `json:"metric"`
-	Average float64 `json:"average"`
+	Value   float64 `json:"value"`
 }
 
 // DirectiveCount is the violation count for a single CSP directive.
@@ -81,40 +115,69 @@ type DirectiveCount struct {
 	Count     int64  `json:"count"`
 }
 
-// GetAllServicesHealth returns trailing-28-day metric averages keyed by
-// service.
-func GetAllServicesHealth(ctx context.Context, d *gorm.DB) (map[string][]ServiceHealth, error) {
+// NELBreakdown is the estimated network-error volume for one value of a
+// NEL dimension (error type, phase, or server IP). Requests weights each
+// report by 1/sampling_fraction; Rate divides it by all NEL requests.
+type NELBreakdown struct {
+	Key      string  `json:"key"`
+	Reports  int64   `json:"reports"`
+	Requests float64 `json:"requests"`
+	Rate     float64 `json:"rate"`
+}
+
+// NELSummary is a service's Network Error Logging picture. Requests
+// counts successes too, so ErrorRate is only meaningful when the NEL
+// policy sets a success_fraction.
+type NELSummary struct {
+	Requests   float64        `json:"requests"`
+	Errors     float64        `json:"errors"`
+	ErrorRate  float64        `json:"error_rate"`
+	ByType     []NELBreakdown `json:"by_type"`
+	ByPhase    []NELBreakdown `json:"by_phase"`
+	ByServerIP []NELBreakdown `json:"by_server_ip"`
+}
+
+// GetAllServicesHealth returns trailing-28-day metric percentiles keyed
+// by service.
+func GetAllServicesHealth(ctx context.Context, d *gorm.DB, p Percentile) (map[string][]ServiceHealth, error) {
 	cutoff := time.Now().AddDate(0, 0, -28)
-	var results []ServiceHealth
-	err := d.WithContext(ctx).
-		Model(&WebVital{}).
-		Select("service, name AS metric, AVG(value) AS average").
-		Where("created_at >= ?", cutoff).
-		Group("service, name").
-		Order("service, name").
-		Find(&results).Error
+	groups, err := vitalPercentiles(ctx, d, p, []string{"service", "name"}, "created_at >= ?", cutoff)
 	if err != nil {
 		return nil, fmt.Errorf("querying all services health: %w", err)
 	}
 
 	out := make(map[string][]ServiceHealth)
-	for _, r := range results {
-		out[r.Service] = append(out[r.Service], r)
+	for _, g := range groups {
+		out[g.Keys[0]] = append(out[g.Keys[0]], ServiceHealth{Service: g.Keys[0], Metric: g.Keys[1], Value: g.Value})
 	}
 	return out, nil
 }
 
-// GetServices returns the sorted union of services across all three
-// ingestion tables.
-func GetServices(ctx context.Context, d *gorm.DB) ([]string, error) {
+// GetServices returns the sorted union of registered services and
+// services with rows in any of the three ingestion tables, leaving out
+// archived ones. With registeredOnly, unregistered services are left out
+// too.
+func GetServices(ctx context.Context, d *gorm.DB, registeredOnly bool) ([]string, error) {
+	registered, err := ListServices(ctx, d)
+	if err != nil {
+		return nil, err
+	}
 	seen := make(map[string]bool)
+	for _, s := range registered {
+		seen[s.Name] = s.ArchivedAt == nil
+	}
+	if registeredOnly {
+		return sortedServices(seen), nil
+	}
 
 	var wvServices []string
 	if err := d.WithContext(ctx).Model(&WebVital{}).Distinct("service").Pluck("service", &wvServices).Error; err != nil {
 		return nil, fmt.Errorf("querying web_vitals services: %w", err)
 	}
 	for _, s := range wvServices {
-		seen[s] = true
+		if _, ok := seen[s]; !ok {
+			seen[s] = true
+		}
 	}
 
 	var rtServices []string
@@ -122,7 +185,9 @@ func GetServices(ctx context.Context, d *gorm.DB) ([]string, error) {
 		return nil, fmt.Errorf("querying report_to services: %w", err)
 	}
 	for _, s := range rtServices {
-		seen[s] = true
+		if _, ok := seen[s]; !ok {
+			seen[s] = true
+		}
 	}
 
 	var srServices []string
@@ -130,92 +195,212 @@ func GetServices(ctx context.Context, d *gorm.DB) ([]string, error) {
 		return nil, fmt.Errorf("querying security_report services: %w", err)
 	}
 	for _, s := range srServices {
-		seen[s] = true
+		if _, ok := seen[s]; !ok {
+			seen[s] = true
+		}
 	}
 
+	return sortedServices(seen), nil
+}
+
+// sortedServices returns the services seen maps to true, sorted.
+func sortedServices(seen map[string]bool) []string {
 	services := make([]string, 0, len(seen))
-	for s := range seen {
-		services = append(services, s)
+	for s, ok := range seen {
+		if ok {
+			services = append(services, s)
+		}
 	}
 	sort.Strings(services)
-	return services, nil
+	return services
 }
 
-// GetWebVitalSummaries returns daily metric averages for service over the
-// trailing 3 months, newest first.
-func GetWebVitalSummaries(ctx context.Context, d *gorm.DB, service string) ([]WebVitalDailySummary, error) {
-	cutoff := time.Now().AddDate(0, -3, 0)
+// GetWebVitalSummaries returns metric percentiles for service in each
+// r.Interval bucket of r, newest first. r defaults to the trailing 3
+// months. Daily and weekly UTC buckets the rollup job has covered are
+// estimated from their days' sketches, merged; the rest are computed from
+// raw rows.
+func GetWebVitalSummaries(ctx context.Context, d *gorm.DB, service string, p Percentile, r TimeRange) ([]WebVitalDailySummary, error) {
+	r = r.window(0, -3, 0)
+	since, through, err := rolledUp(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []WebVitalDailySummary
-	err := d.WithContext(ctx).
-		Model(&WebVital{}).
-		Select("DATE(created_at) AS day, service, name, AVG(value) AS value").
-		Where("service = ? AND created_at >= ?", service, cutoff).
-		Group("DATE(created_at), service, name").
-		Order("DATE(created_at) DESC").
-		Find(&results).Error
+	start, end := r.rollupDays(since, through)
+	if start.Before(end) {
+		var rollups []WebVitalDailyRollup
+		err := d.WithContext(ctx).
+			Where("service = ? AND day >= ? AND day < ?", service, Day(start), Day(end)).
+			Find(&rollups).Error
+		if err != nil {
+			return nil, fmt.Errorf("querying web vital rollups: %w", err)
+		}
+		type bucketKey struct {
+			bucket time.Time
+			name   string
+		}
+		var keys []bucketKey
+		sketches := map[bucketKey]*valueSketch{}
+		for _, ru := range rollups {
+			sk, err := decodeSketch(ru.Sketch)
+			if err != nil {
+				return nil, fmt.Errorf("decoding web vital rollup sketch: %w", err)
+			}
+			k := bucketKey{r.rollupBucket(time.Time(ru.Day)), ru.Name}
+			if sketches[k] == nil {
+				sketches[k] = &valueSketch{}
+				keys = append(keys, k)
+			}
+			sketches[k].merge(sk)
+		}
+		for _, k := range keys {
+			results = append(results, WebVitalDailySummary{Bucket: k.bucket, Day: Day(k.bucket), Service: service, Name: k.name, Value: sketches[k].quantile(p)})
+		}
+	}
+
+	raw, err := rawWebVitalSummaries(ctx, d, service, p, r, start, end)
 	if err != nil {
-		return nil, fmt.Errorf("querying web vital summaries: %w", err)
+		return nil, err
 	}
+	results = append(results, raw...)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Bucket.After(results[j].Bucket)
+	})
 	return results, nil
 }
 
-// GetWebVitalAverages returns trailing-28-day metric averages for service.
-func GetWebVitalAverages(ctx context.Context, d *gorm.DB, service string) ([]WebVitalAverage, error) {
-	cutoff := time.Now().AddDate(0, 0, -28)
-	var results []WebVitalAverage
-	err := d.WithContext(ctx).
-		Model(&WebVital{}).
-		Select("name, AVG(value) AS value").
-		Where("service = ? AND created_at >= ?", service, cutoff).
-		Group("name").
-		Order("name").
-		Find(&results).Error
+// rawRangeWhere matches rows of service whose column, a timestamp such
+// as created_at, is within r, apart from those in [skipFrom, skipTo),
+// which are read from rollups instead.
+func rawRangeWhere(d *gorm.DB, column, service string, r TimeRange, skipFrom, skipTo time.Time) *gorm.DB {
+	q := d.Where("service = ? AND "+column+" >= ? AND "+column+" < ?", service, r.From, r.To)
+	if skipFrom.Before(skipTo) {
+		q = q.Where("("+column+" < ? OR "+column+" >= ?)", skipFrom, skipTo)
+	}
+	return q
+}
+
+// rawWebVitalSummaries computes bucketed metric percentiles for service
+// from web_vitals rows in r, skipping [skipFrom, skipTo).
+func rawWebVitalSummaries(ctx context.Context, d *gorm.DB, service string, p Percentile, r TimeRange, skipFrom, skipTo time.Time) ([]WebVitalDailySummary, error) {
+	bucket := r.bucketExpr(d, "created_at")
+	where := rawRangeWhere(d, "created_at", service, r, skipFrom, skipTo)
+
+	groups, err := vitalPercentiles(ctx, d, p, []string{bucket, "name"}, where)
 	if err != nil {
-		return nil, fmt.Errorf("querying web vital averages: %w", err)
+		return nil, fmt.Errorf("querying web vital summaries: %w", err)
+	}
+
+	results := make([]WebVitalDailySummary, 0, len(groups))
+	for _, g := range groups {
+		at, err := r.parseBucket(g.Keys[0])
+		if err != nil {
+			return nil, fmt.Errorf("querying web vital summaries: %w", err)
+		}
+		results = append(results, WebVitalDailySummary{Bucket: at, Day: Day(at), Service: service, Name: g.Keys[1], Value: g.Value})
 	}
 	return results, nil
 }
 
-// GetReportCounts returns per-day, per-type counts for service across
-// both ingestion tables over the trailing 3 months.
-func GetReportCounts(ctx context.Context, d *gorm.DB, service string) ([]ReportDailyCount, error) {
-	cutoff := time.Now().AddDate(0, -3, 0)
-	const daySelect = "DATE(created_at) AS day, report_type, COUNT(*) AS count"
-
-	var rtCounts []ReportDailyCount
-	err := d.WithContext(ctx).
-		Model(&ReportToEntry{}).
-		Select(daySelect).
-		Where("service = ? AND created_at >= ?", service, cutoff).
-		Group("DATE(created_at), report_type").
-		Order("DATE(created_at) DESC").
-		Find(&rtCounts).Error
+// GetWebVitalPercentiles returns metric percentiles for service over r,
+// by default the trailing 28 days.
+func GetWebVitalPercentiles(ctx context.Context, d *gorm.DB, service string, p Percentile, r TimeRange) ([]WebVitalPercentile, error) {
+	r = r.window(0, 0, -28)
+	groups, err := vitalPercentiles(ctx, d, p, []string{"name"}, "service = ? AND created_at >= ? AND created_at < ?", service, r.From, r.To)
 	if err != nil {
-		return nil, fmt.Errorf("querying report-to counts: %w", err)
+		return nil, fmt.Errorf("querying web vital percentiles: %w", err)
+	}
+	var results []WebVitalPercentile
+	for _, g := range groups {
+		results = append(results, WebVitalPercentile{Name: g.Keys[0], Value: g.Value})
 	}
+	return results, nil
+}
 
-	var srCounts []ReportDailyCount
-	err = d.WithContext(ctx).
-		Model(&SecurityReportEntry{}).
-		Select(daySelect).
-		Where("service = ? AND created_at >= ?", service, cutoff).
-		Group("DATE(created_at), report_type").
-		Order("DATE(created_at) DESC").
-		Find(&srCounts).Error
+// GetReportCounts returns per-bucket, per-type counts for service across
+// both ingestion tables over r, by default the trailing 3 months, newest
+// first. Reports are bucketed by when they occurred rather than when they
+// arrived, and sampled reports count 1/sample_rate times. Daily and
+// weekly UTC buckets the rollup job has covered are summed from
+// report_daily_rollups.
+func GetReportCounts(ctx context.Context, d *gorm.DB, service string, r TimeRange) ([]ReportDailyCount, error) {
+	r = r.window(0, -3, 0)
+	since, through, err := rolledUp(ctx, d)
 	if err != nil {
-		return nil, fmt.Errorf("querying security report counts: %w", err)
+		return nil, err
 	}
 
-	return append(rtCounts, srCounts...), nil
+	var results []ReportDailyCount
+	start, end := r.rollupDays(since, through)
+	if start.Before(end) {
+		var rollups []ReportDailyRollup
+		err := d.WithContext(ctx).
+			Where("service = ? AND day >= ? AND day < ?", service, Day(start), Day(end)).
+			Order("day, report_type").
+			Find(&rollups).Error
+		if err != nil {
+			return nil, fmt.Errorf("querying report rollups: %w", err)
+		}
+		type bucketKey struct {
+			bucket     time.Time
+			reportType string
+		}
+		index := map[bucketKey]int{}
+		for _, ru := range rollups {
+			k := bucketKey{r.rollupBucket(time.Time(ru.Day)), ru.ReportType}
+			i, ok := index[k]
+			if !ok {
+				i = len(results)
+				index[k] = i
+				results = append(results, ReportDailyCount{Bucket: k.bucket, Day: Day(k.bucket), ReportType: k.reportType})
+			}
+			results[i].Count += ru.Count
+		}
+	}
+
+	bucket := r.bucketExpr(d, "occurred_at")
+	for _, model := range []any{&ReportToEntry{}, &SecurityReportEntry{}} {
+		var counts []struct {
+			Bucket     string
+			ReportType string
+			Count      int64
+		}
+		err := d.WithContext(ctx).
+			Model(model).
+			Select(bucket + " AS bucket, report_type, " + sampledCount + " AS count").
+			Where(rawRangeWhere(d, "occurred_at", service, r, start, end)).
+			Group(bucket + ", report_type").
+			Find(&counts).Error
+		if err != nil {
+			return nil, fmt.Errorf("querying report counts: %w", err)
+		}
+		for _, c := range counts {
+			at, err := r.parseBucket(c.Bucket)
+			if err != nil {
+				return nil, fmt.Errorf("querying report counts: %w", err)
+			}
+			results = append(results, ReportDailyCount{Bucket: at, Day: Day(at), ReportType: c.ReportType, Count: c.Count})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Bucket.After(results[j].Bucket)
+	})
+	return results, nil
 }
 
 // GetRecentReports returns up to limit recent SecurityReportEntry rows
-// for service.
-func GetRecentReports(ctx context.Context, d *gorm.DB, service string, limit int) ([]SecurityReportEntry, error) {
+// for service. Unless includeTriaged is set, rows filed under a resolved
+// or ignored issue are left out.
+func GetRecentReports(ctx context.Context, d *gorm.DB, service string, limit int, includeTriaged bool) ([]SecurityReportEntry, error) {
+	q := d.WithContext(ctx).Where("service = ?", service)
+	if !includeTriaged {
+		q = q.Where("NOT " + triagedSQL("security_report_entries"))
+	}
 	var results []SecurityReportEntry
-	err := d.WithContext(ctx).
-		Where("service = ?", service).
-		Order("created_at DESC").
+	err := q.Order("created_at DESC").
 		Limit(limit).
 		Find(&results).Error
 	if err != nil {
@@ -225,12 +410,15 @@ func GetRecentReports(ctx context.Context, d *gorm.DB, service string, limit int
 }
 
 // GetRecentReportToEntries returns up to limit recent ReportToEntry rows
-// for service.
-func GetRecentReportToEntries(ctx context.Context, d *gorm.DB, service string, limit int) ([]ReportToEntry, error) {
+// for service. Unless includeTriaged is set, rows filed under a resolved
+// or ignored issue are left out.
+func GetRecentReportToEntries(ctx context.Context, d *gorm.DB, service string, limit int, includeTriaged bool) ([]ReportToEntry, error) {
+	q := d.WithContext(ctx).Where("service = ?", service)
+	if !includeTriaged {
+		q = q.Where("NOT " + triagedSQL("report_to_entries"))
+	}
 	var results []ReportToEntry
-	err := d.WithContext(ctx).
-		Where("service = ?", service).
-		Order("created_at DESC").
+	err := q.Order("created_at DESC").
 		Limit(limit).
 		Find(&results).Error
 	if err != nil {
@@ -239,20 +427,24 @@ func GetRecentReportToEntries(ctx context.Context, d *gorm.DB, service string, l
 	return results, nil
 }
 
+// directiveExpr is a CSP report's violated directive, falling back to the
+// effective directive for browsers that only send that. Both report
+// tables have an index on it.
+const directiveExpr = "COALESCE(NULLIF(violated_directive, ''), effective_directive)"
+
 // GetTopViolatedDirectives returns up to limit most-violated CSP
-// directives for service over the trailing month, merged across both
-// ingestion tables.
-func GetTopViolatedDirectives(ctx context.Context, d *gorm.DB, service string, limit int) ([]DirectiveCount, error) {
-	cutoff := time.Now().AddDate(0, -1, 0)
+// directives for service over r, by default the trailing month, merged
+// across both ingestion tables.
+func GetTopViolatedDirectives(ctx context.Context, d *gorm.DB, service string, limit int, r TimeRange) ([]DirectiveCount, error) {
+	r = r.window(0, -1, 0)
 	cspTypes := []string{reportTypeCSPViolation, reportTypeCSP}
-	const directiveExpr = "COALESCE(NULLIF(violated_directive, ''), effective_directive)"
-	const whereClause = "service = ? AND created_at >= ? AND report_type IN ? AND " + directiveExpr + " != ''"
+	const whereClause = "service = ? AND created_at >= ? AND created_at < ? AND report_type IN ? AND " + directiveExpr + " != ''"
 
 	var srResults []DirectiveCount
 	err := d.WithContext(ctx).
 		Model(&SecurityReportEntry{}).
-		Select(directiveExpr+" AS directive, COUNT(*) AS count").
-		Where(whereClause, service, cutoff, cspTypes).
+		Select(directiveExpr+" AS directive, "+sampledCount+" AS count").
+		Where(whereClause, service, r.From, r.To, cspTypes).
 		Group(directiveExpr).
 		Find(&srResults).Error
 	if err != nil {
@@ -262,8 +454,8 @@ func GetTopViolatedDirectives(ctx context.Context, d *gorm.DB, service string, l
 	var rtResults []DirectiveCount
 	err = d.WithContext(ctx).
 		Model(&ReportToEntry{}).
-		Select(directiveExpr+" AS directive, COUNT(*) AS count").
-		Where(whereClause, service, cutoff, cspTypes).
+		Select(directiveExpr+" AS directive, "+sampledCount+" AS count").
+		Where(whereClause, service, r.From, r.To, cspTypes).
 		Group(directiveExpr).
 		Find(&rtResults).Error
 	if err != nil {
@@ -293,3 +485,199 @@ func GetTopViolatedDirectives(ctx context.Context, d *gorm.DB, service string, l
 	}
 	return results, nil
 }
+
+// nelOKType is the NEL body type browsers send for sampled successes.
+const nelOKType = "ok"
+
+// nelWeight estimates how many requests a NEL report stands for: the
+// browser's sampling_fraction, where usable, times reportd's own
+// sample_rate.
+const nelWeight = "CASE WHEN sampling_fraction > 0 THEN 1.0 / sampling_fraction ELSE 1.0 END / sample_rate"
+
+type nelRow struct {
+	Phase    string
+	NELType  string `gorm:"column:nel_type"`
+	ServerIP string
+	Reports  int64
+	Requests float64
+}
+
+// GetNELSummary returns sampling-weighted network-error rates for service
+// over the trailing month, broken down by error type, phase, and server
+// IP and merged across both ingestion tables.
+func GetNELSummary(ctx context.Context, d *gorm.DB, service string) (*NELSummary, error) {
+	cutoff := time.Now().AddDate(0, -1, 0)
+	const selectClause = "phase, nel_type, server_ip, " + sampledCount + " AS reports, SUM(" + nelWeight + ") AS requests"
+	const whereClause = "service = ? AND created_at >= ? AND report_type = ?"
+
+	var srRows []nelRow
+	err := d.WithContext(ctx).
+		Model(&SecurityReportEntry{}).
+		Select(selectClause).
+		Where(whereClause, service, cutoff, reportTypeNEL).
+		Group("phase, nel_type, server_ip").
+		Find(&srRows).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying nel summary (security_report): %w", err)
+	}
+
+	var rtRows []nelRow
+	err = d.WithContext(ctx).
+		Model(&ReportToEntry{}).
+		Select(selectClause).
+		Where(whereClause, service, cutoff, reportTypeNEL).
+		Group("phase, nel_type, server_ip").
+		Find(&rtRows).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying nel summary (report_to): %w", err)
+	}
+
+	out := &NELSummary{}
+	byType := map[string]*NELBreakdown{}
+	byPhase := map[string]*NELBreakdown{}
+	byServerIP := map[string]*NELBreakdown{}
+	for _, r := range append(srRows, rtRows...) {
+		out.Requests += r.Requests
+		if r.NELType == nelOKType {
+			continue
+		}
+		out.Errors += r.Requests
+		addNELBreakdown(byType, r.NELType, r)
+		addNELBreakdown(byPhase, r.Phase, r)
+		addNELBreakdown(byServerIP, r.ServerIP, r)
+	}
+	if out.Requests > 0 {
+		out.ErrorRate = out.Errors / out.Requests
+	}
+	out.ByType = sortNELBreakdowns(byType, out.Requests)
+	out.ByPhase = sortNELBreakdowns(byPhase, out.Requests)
+	out.ByServerIP = sortNELBreakdowns(byServerIP, out.Requests)
+	return out, nil
+}
+
+func addNELBreakdown(m map[string]*NELBreakdown, key string, r nelRow) {
+	b, ok := m[key]
+	if !ok {
+		b = &NELBreakdown{Key: key}
+		m[key] = b
+	}
+	b.Reports += r.Reports
+	b.Requests += r.Requests
+}
+
+// sortNELBreakdowns fills in Rate against total and orders by estimated
+// requests, largest first.
+func sortNELBreakdowns(m map[string]*NELBreakdown, total float64) []NELBreakdown {
+	results := make([]NELBreakdown, 0, len(m))
+	for _, b := range m {
+		if total > 0 {
+			b.Rate = b.Requests / total
+		}
+		results = append(results, *b)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Requests != results[j].Requests {
+			return results[i].Requests > results[j].Requests
+		}
+		return results[i].Key < results[j].Key
+	})
+	return results
+}
+
+// GetWebVitalRatings returns trailing-28-day sample counts per metric and
+// rating for service, scaled up for ingest sampling. Samples without a
+// rating are skipped.
+func GetWebVitalRatings(ctx context.Context, d *gorm.DB, service string) ([]VitalRatingCount, error) {
+	cutoff := time.Now().AddDate(0, 0, -28)
+	var results []VitalRatingCount
+	err := d.WithContext(ctx).
+		Model(&WebVital{}).
+		Select("name, rating, "+sampledCount+" AS count").
+		Where("service = ? AND created_at >= ? AND rating != ''", service, cutoff).
+		Group("name, rating").
+		Order("name, rating").
+		Find(&results).Error
+	if err != nil {
+		return nil, fmt.Errorf("querying web vital ratings: %w", err)
+	}
+
+	totals := map[string]int64{}
+	for _, r := range results {
+		totals[r.Name] += r.Count
+	}
+	for i := range results {
+		results[i].Share = float64(results[i].Count) / float64(totals[results[i].Name])
+	}
+	return results, nil
+}
+
+// GetWebVitalsByNavigationType returns trailing-28-day metric percentiles
+// for service keyed by navigation type, so back/forward-cache restores and
+// prerenders can be compared with regular navigations.
+func GetWebVitalsByNavigationType(ctx context.Context, d *gorm.DB, service string, p Percentile) ([]VitalGroupPercentile, error) {
+	results, err := groupedVitalPercentiles(ctx, d, p, "navigation_type", "service = ? AND created_at >= ?", service, time.Now().AddDate(0, 0, -28))
+	if err != nil {
+		return nil, fmt.Errorf("querying web vitals by navigation type: %w", err)
+	}
+	return results, nil
+}
+
+// GetTopAttributionTargets returns, for each metric, up to limit elements
+// most often blamed by the web-vitals attribution build for service over
+// the trailing 28 days, with the metric's percentile on each.
+func GetTopAttributionTargets(ctx context.Context, d *gorm.DB, service string, p Percentile, limit int) ([]VitalGroupPercentile, error) {
+	results, err := groupedVitalPercentiles(ctx, d, p, "attribution_target", "service = ? AND created_at >= ? AND attribution_target != ''", service, time.Now().AddDate(0, 0, -28))
+	if err != nil {
+		return nil, fmt.Errorf("querying top attribution targets: %w", err)
+	}
+
+	return topPerMetric(results, limit, func(a, b VitalGroupPercentile) bool {
+		return a.Count > b.Count
+	}), nil
+}
+
+// GetWorstPages returns, for each metric, up to limit routes with the
+// highest percentile for service over the trailing 28 days.
+func GetWorstPages(ctx context.Context, d *gorm.DB, service string, p Percentile, limit int) ([]VitalGroupPercentile, error) {
+	results, err := groupedVitalPercentiles(ctx, d, p, "route", "service = ? AND created_at >= ? AND route != ''", service, time.Now().AddDate(0, 0, -28))
+	if err != nil {
+		return nil, fmt.Errorf("querying worst pages: %w", err)
+	}
+	return topPerMetric(results, limit, func(a, b VitalGroupPercentile) bool {
+		return a.Value > b.Value
+	}), nil
+}
+
+// topPerMetric orders results by metric name and then by better, keeping
+// at most limit entries per metric.
+func topPerMetric(results []VitalGroupPercentile, limit int, better func(a, b VitalGroupPercentile) bool) []VitalGroupPercentile {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Name != results[j].Name {
+			return results[i].Name < results[j].Name
+		}
+		return better(results[i], results[j])
+	})
+	out := results[:0]
+	seen := map[string]int{}
+	for _, r := range results {
+		if seen[r.Name] < limit {
+			out = append(out, r)
+		}
+		seen[r.Name]++
+	}
+	return out
+}
+
+// groupedVitalPercentiles computes p per (name, keyColumn) over the
+// web_vitals rows matching where, ordered by name then key.
+func groupedVitalPercentiles(ctx context.Context, d *gorm.DB, p Percentile, keyColumn, where string, args ...any) ([]VitalGroupPercentile, error) {
+	groups, err := vitalPercentiles(ctx, d, p, []string{"name", keyColumn}, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	var results []VitalGroupPercentile
+	for _, g := range groups {
+		results = append(results, VitalGroupPercentile{Name: g.Keys[0], Key: g.Keys[1], Count: g.Count, Value: g.Value})
+	}
+	return results, nil
+}
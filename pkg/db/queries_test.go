@@ -74,37 +74,49 @@ func seedQueryFixtures(t *testing.T, d *gorm.DB, service string) {
 func assertQueryHelpers(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
 	t.Helper()
 
-	avgs, err := GetWebVitalAverages(ctx, d, service)
-	if err != nil {
-		t.Fatalf("GetWebVitalAverages() error = %v", err)
-	}
-	if len(avgs) != 1 {
-		t.Fatalf("expected 1 metric, got %d", len(avgs))
-	}
-	if math.Abs(avgs[0].Value-2.5) > 1e-9 {
-		t.Fatalf("expected average 2.5, got %v", avgs[0].Value)
+	for _, tc := range []struct {
+		p    Percentile
+		want float64
+	}{
+		{P50, 2.5},
+		{P75, 3.25},
+		{P95, 3.85},
+	} {
+		pcts, err := GetWebVitalPercentiles(ctx, d, service, tc.p, TimeRange{})
+		if err != nil {
+			t.Fatalf("GetWebVitalPercentiles(%s) error = %v", tc.p, err)
+		}
+		if len(pcts) != 1 {
+			t.Fatalf("expected 1 metric, got %d", len(pcts))
+		}
+		if math.Abs(pcts[0].Value-tc.want) > 1e-9 {
+			t.Fatalf("expected %s %v, got %v", tc.p, tc.want, pcts[0].Value)
+		}
 	}
 
-	health, err := GetAllServicesHealth(ctx, d)
+	health, err := GetAllServicesHealth(ctx, d, P75)
 	if err != nil {
 		t.Fatalf("GetAllServicesHealth() error = %v", err)
 	}
 	if len(health[service]) != 1 {
 		t.Fatalf("expected 1 health metric for service, got %d", len(health[service]))
 	}
-	if math.Abs(health[service][0].Average-2.5) > 1e-9 {
-		t.Fatalf("expected service average 2.5, got %v", health[service][0].Average)
+	if math.Abs(health[service][0].Value-3.25) > 1e-9 {
+		t.Fatalf("expected service p75 3.25, got %v", health[service][0].Value)
 	}
 
-	summaries, err := GetWebVitalSummaries(ctx, d, service)
+	summaries, err := GetWebVitalSummaries(ctx, d, service, P75, TimeRange{})
 	if err != nil {
 		t.Fatalf("GetWebVitalSummaries() error = %v", err)
 	}
 	if len(summaries) == 0 || time.Time(summaries[0].Day).IsZero() {
 		t.Fatalf("expected non-empty daily summaries, got %+v", summaries)
 	}
+	if summaries[0].Service != service {
+		t.Fatalf("expected summary service %q, got %q", service, summaries[0].Service)
+	}
 
-	counts, err := GetReportCounts(ctx, d, service)
+	counts, err := GetReportCounts(ctx, d, service, TimeRange{})
 	if err != nil {
 		t.Fatalf("GetReportCounts() error = %v", err)
 	}
@@ -117,7 +129,7 @@ func assertQueryHelpers(ctx context.Context, t *testing.T, d *gorm.DB, service s
 		}
 	}
 
-	directives, err := GetTopViolatedDirectives(ctx, d, service, 10)
+	directives, err := GetTopViolatedDirectives(ctx, d, service, 10, TimeRange{})
 	if err != nil {
 		t.Fatalf("GetTopViolatedDirectives() error = %v", err)
 	}
@@ -139,7 +151,7 @@ func assertQueryHelpers(ctx context.Context, t *testing.T, d *gorm.DB, service s
 		}
 	}
 
-	services, err := GetServices(ctx, d)
+	services, err := GetServices(ctx, d, false)
 	if err != nil {
 		t.Fatalf("GetServices() error = %v", err)
 	}
@@ -147,7 +159,7 @@ func assertQueryHelpers(ctx context.Context, t *testing.T, d *gorm.DB, service s
 		t.Errorf("GetServices() = %v, want it to contain %q", services, service)
 	}
 
-	recent, err := GetRecentReports(ctx, d, service, 10)
+	recent, err := GetRecentReports(ctx, d, service, 10, false)
 	if err != nil {
 		t.Fatalf("GetRecentReports() error = %v", err)
 	}
@@ -160,7 +172,7 @@ func assertQueryHelpers(ctx context.Context, t *testing.T, d *gorm.DB, service s
 		}
 	}
 
-	recentRT, err := GetRecentReportToEntries(ctx, d, service, 10)
+	recentRT, err := GetRecentReportToEntries(ctx, d, service, 10, false)
 	if err != nil {
 		t.Fatalf("GetRecentReportToEntries() error = %v", err)
 	}
@@ -169,7 +181,7 @@ func assertQueryHelpers(ctx context.Context, t *testing.T, d *gorm.DB, service s
 	}
 
 	// Limit clamps results.
-	limited, err := GetRecentReports(ctx, d, service, 1)
+	limited, err := GetRecentReports(ctx, d, service, 1, false)
 	if err != nil {
 		t.Fatalf("GetRecentReports(limit=1) error = %v", err)
 	}
@@ -178,6 +190,88 @@ func assertQueryHelpers(ctx context.Context, t *testing.T, d *gorm.DB, service s
 	}
 }
 
+// seedNELFixtures inserts network-error rows under service: three sampled
+// successes and two errors spread across both ingestion tables.
+func seedNELFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	now := time.Now()
+	// Three successes sampled at 10% stand for 30 requests.
+	for range 3 {
+		if err := d.Create(&ReportToEntry{
+			CreatedAt:        now,
+			Service:          service,
+			ReportType:       reportTypeNEL,
+			NELType:          "ok",
+			Phase:            "application",
+			ServerIP:         "192.0.2.1",
+			SamplingFraction: 0.1,
+			RawJSON:          "{}",
+		}).Error; err != nil {
+			t.Fatalf("creating nel report_to_entry: %v", err)
+		}
+	}
+	if err := d.Create(&ReportToEntry{
+		CreatedAt:        now,
+		Service:          service,
+		ReportType:       reportTypeNEL,
+		NELType:          "http.error",
+		Phase:            "application",
+		ServerIP:         "192.0.2.1",
+		SamplingFraction: 1,
+		RawJSON:          "{}",
+	}).Error; err != nil {
+		t.Fatalf("creating nel report_to_entry: %v", err)
+	}
+	// Half-sampled, so it stands for two requests.
+	if err := d.Create(&SecurityReportEntry{
+		CreatedAt:        now,
+		Service:          service,
+		ReportType:       reportTypeNEL,
+		NELType:          "tcp.timed_out",
+		Phase:            "connection",
+		ServerIP:         "192.0.2.2",
+		SamplingFraction: 0.5,
+		RawJSON:          "{}",
+	}).Error; err != nil {
+		t.Fatalf("creating nel security_report_entry: %v", err)
+	}
+}
+
+// assertNELSummary checks GetNELSummary against seedNELFixtures.
+func assertNELSummary(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+
+	summary, err := GetNELSummary(ctx, d, service)
+	if err != nil {
+		t.Fatalf("GetNELSummary() error = %v", err)
+	}
+	if math.Abs(summary.Requests-33) > 1e-9 {
+		t.Errorf("requests = %v, want 33", summary.Requests)
+	}
+	if math.Abs(summary.Errors-3) > 1e-9 {
+		t.Errorf("errors = %v, want 3", summary.Errors)
+	}
+	if math.Abs(summary.ErrorRate-3.0/33) > 1e-9 {
+		t.Errorf("error_rate = %v, want %v", summary.ErrorRate, 3.0/33)
+	}
+
+	if len(summary.ByType) != 2 {
+		t.Fatalf("by_type = %+v, want 2 error types", summary.ByType)
+	}
+	if summary.ByType[0].Key != "tcp.timed_out" || summary.ByType[0].Reports != 1 || math.Abs(summary.ByType[0].Requests-2) > 1e-9 {
+		t.Errorf("by_type[0] = %+v, want tcp.timed_out weighted to 2", summary.ByType[0])
+	}
+	if len(summary.ByPhase) != 2 {
+		t.Errorf("by_phase = %+v, want 2 phases", summary.ByPhase)
+	}
+	if len(summary.ByServerIP) != 2 || summary.ByServerIP[0].Key != "192.0.2.2" {
+		t.Errorf("by_server_ip = %+v, want 192.0.2.2 first", summary.ByServerIP)
+	}
+	if math.Abs(summary.ByServerIP[0].Rate-2.0/33) > 1e-9 {
+		t.Errorf("by_server_ip[0].rate = %v, want %v", summary.ByServerIP[0].Rate, 2.0/33)
+	}
+}
+
 func containsString(haystack []string, needle string) bool {
 	for _, s := range haystack {
 		if s == needle {
@@ -251,3 +345,211 @@ func TestDayScanInvalidDateRejected(t *testing.T) {
 		t.Errorf("error %q should mention 'parsing day'", err.Error())
 	}
 }
+
+// occurredBase is midnight UTC on the day the occurred-at fixtures were
+// received.
+var occurredBase = time.Date(2025, 9, 2, 0, 0, 0, 0, time.UTC)
+
+// seedOccurredFixtures stores two reports received half an hour into
+// occurredBase's day, one of which occurred the evening before.
+func seedOccurredFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	received := occurredBase.Add(30 * time.Minute)
+	if err := d.Create(&ReportToEntry{CreatedAt: received, OccurredAt: received.Add(-time.Hour), Service: service, ReportType: reportTypeCSPViolation, RawJSON: "{}"}).Error; err != nil {
+		t.Fatalf("seed report-to: %v", err)
+	}
+	if err := d.Create(&SecurityReportEntry{CreatedAt: received, Service: service, ReportType: reportTypeCSPViolation, RawJSON: "{}"}).Error; err != nil {
+		t.Fatalf("seed security report: %v", err)
+	}
+}
+
+// assertOccurredCounts checks that raw and rolled-up report counts land
+// on the day a report occurred, not the day it arrived.
+func assertOccurredCounts(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+
+	var rt ReportToEntry
+	if err := d.WithContext(ctx).Where("service = ? AND occurred_at < ?", service, occurredBase).Take(&rt).Error; err != nil {
+		t.Fatalf("loading report-to entry: %v", err)
+	}
+	if !rt.ReceivedAt.Equal(rt.CreatedAt) {
+		t.Errorf("received_at = %v, want it to default to created_at %v", rt.ReceivedAt, rt.CreatedAt)
+	}
+
+	counts, err := GetReportCounts(ctx, d, service, TimeRange{From: occurredBase.AddDate(0, 0, -1), To: occurredBase.AddDate(0, 0, 1)})
+	if err != nil {
+		t.Fatalf("GetReportCounts() error = %v", err)
+	}
+	if len(counts) != 2 || !counts[0].Bucket.Equal(occurredBase) || counts[0].Count != 1 || counts[1].Count != 1 {
+		t.Errorf("GetReportCounts() = %+v, want one report on each day", counts)
+	}
+
+	for _, day := range []time.Time{occurredBase.AddDate(0, 0, -1), occurredBase} {
+		if err := RollupDay(ctx, d, day); err != nil {
+			t.Fatalf("RollupDay(%v) error = %v", day, err)
+		}
+		var rollup ReportDailyRollup
+		if err := d.WithContext(ctx).Where("service = ? AND day = ?", service, Day(day)).Take(&rollup).Error; err != nil {
+			t.Fatalf("loading report rollup for %v: %v", day, err)
+		}
+		if rollup.Count != 1 {
+			t.Errorf("rollup for %v = %+v, want 1 report", day, rollup)
+		}
+	}
+}
+
+// sampledBase is when seedSamplingFixtures' reports occurred.
+var sampledBase = time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+// seedSamplingFixtures stores rows kept at various sample rates: two
+// deprecation reports at 1/4, one kept in full, and two LCP samples at
+// 1/2.
+func seedSamplingFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	for range 2 {
+		e := &SecurityReportEntry{CreatedAt: sampledBase, Service: service, ReportType: "deprecation", SampleRate: 0.25, RawJSON: "{}", Fingerprint: "sampled-deprecation"}
+		if err := SaveSecurityReportEntries(context.Background(), d, []*SecurityReportEntry{e}); err != nil {
+			t.Fatalf("seed security report: %v", err)
+		}
+	}
+	if err := d.Create(&ReportToEntry{CreatedAt: sampledBase, Service: service, ReportType: "deprecation", RawJSON: "{}"}).Error; err != nil {
+		t.Fatalf("seed report-to: %v", err)
+	}
+	for _, v := range []float64{1000, 3000} {
+		wv := &WebVital{CreatedAt: time.Now(), Service: service, Name: "LCP", Value: v, Rating: "good", NavigationType: "navigate", SampleRate: 0.5}
+		if err := d.Create(wv).Error; err != nil {
+			t.Fatalf("seed web vital: %v", err)
+		}
+	}
+}
+
+// assertSampledCounts checks that counts and issues weight each row by
+// the inverse of its sample rate, and that unsampled rows default to a rate of 1.
+func assertSampledCounts(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+
+	var rt ReportToEntry
+	if err := d.WithContext(ctx).Where("service = ?", service).Take(&rt).Error; err != nil || rt.SampleRate != 1 {
+		t.Errorf("unsampled report-to entry sample_rate = %v (err %v), want 1", rt.SampleRate, err)
+	}
+
+	counts, err := GetReportCounts(ctx, d, service, TimeRange{From: sampledBase.AddDate(0, 0, -1), To: sampledBase.AddDate(0, 0, 1)})
+	if err != nil {
+		t.Fatalf("GetReportCounts() error = %v", err)
+	}
+	if len(counts) != 2 || counts[0].Count+counts[1].Count != 9 {
+		t.Errorf("GetReportCounts() = %+v, want 8 + 1 deprecation reports", counts)
+	}
+
+	issues, err := GetIssues(ctx, d, service, IssueFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("GetIssues() error = %v", err)
+	}
+	if len(issues) != 1 || issues[0].Count != 8 {
+		t.Errorf("GetIssues() = %+v, want one issue of 8 deprecation reports", issues)
+	}
+
+	ratings, err := GetWebVitalRatings(ctx, d, service)
+	if err != nil {
+		t.Fatalf("GetWebVitalRatings() error = %v", err)
+	}
+	if len(ratings) != 1 || ratings[0].Count != 4 {
+		t.Errorf("GetWebVitalRatings() = %+v, want 4 good LCP samples", ratings)
+	}
+	byNav, err := GetWebVitalsByNavigationType(ctx, d, service, P75)
+	if err != nil {
+		t.Fatalf("GetWebVitalsByNavigationType() error = %v", err)
+	}
+	if len(byNav) != 1 || byNav[0].Count != 4 {
+		t.Errorf("GetWebVitalsByNavigationType() = %+v, want 4 samples", byNav)
+	}
+
+	if err := RollupDay(ctx, d, sampledBase); err != nil {
+		t.Fatalf("RollupDay() error = %v", err)
+	}
+	var reports ReportDailyRollup
+	if err := d.WithContext(ctx).Where("service = ?", service).Take(&reports).Error; err != nil || reports.Count != 9 {
+		t.Errorf("report rollup = %+v (err %v), want 9 reports", reports, err)
+	}
+	if err := RollupDay(ctx, d, time.Now()); err != nil {
+		t.Fatalf("RollupDay() error = %v", err)
+	}
+	var vitals WebVitalDailyRollup
+	if err := d.WithContext(ctx).Where("service = ?", service).Take(&vitals).Error; err != nil || vitals.Count != 4 || vitals.Sum != 8000 {
+		t.Errorf("web vital rollup = %+v (err %v), want count 4 and sum 8000", vitals, err)
+	}
+}
+
+// seedVitalBreakdownFixtures inserts LCP samples spread across ratings,
+// navigation types, attribution targets and routes.
+func seedVitalBreakdownFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	now := time.Now()
+	rows := []WebVital{
+		{Value: 1000, Rating: "good", NavigationType: "navigate", AttributionTarget: "#hero", Route: "/"},
+		{Value: 2000, Rating: "good", NavigationType: "navigate", AttributionTarget: "#hero", Route: "/"},
+		{Value: 3000, Rating: "needs-improvement", NavigationType: "navigate", AttributionTarget: "#hero", Route: "/posts/:id"},
+		{Value: 5000, Rating: "poor", NavigationType: "reload", AttributionTarget: "h1", Route: "/posts/:id"},
+		{Value: 100, Rating: "good", NavigationType: "back-forward-cache"},
+	}
+	for i := range rows {
+		rows[i].CreatedAt = now
+		rows[i].Service = service
+		rows[i].Name = "LCP"
+		if err := d.Create(&rows[i]).Error; err != nil {
+			t.Fatalf("creating web vital: %v", err)
+		}
+	}
+}
+
+// assertVitalBreakdowns checks the rating, navigation-type, attribution
+// and per-page helpers against seedVitalBreakdownFixtures.
+func assertVitalBreakdowns(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+
+	ratings, err := GetWebVitalRatings(ctx, d, service)
+	if err != nil {
+		t.Fatalf("GetWebVitalRatings() error = %v", err)
+	}
+	gotRatings := map[string]VitalRatingCount{}
+	for _, r := range ratings {
+		gotRatings[r.Rating] = r
+	}
+	if len(gotRatings) != 3 || gotRatings["good"].Count != 3 || math.Abs(gotRatings["good"].Share-0.6) > 1e-9 {
+		t.Fatalf("unexpected ratings %+v", ratings)
+	}
+
+	nav, err := GetWebVitalsByNavigationType(ctx, d, service, P50)
+	if err != nil {
+		t.Fatalf("GetWebVitalsByNavigationType() error = %v", err)
+	}
+	gotNav := map[string]VitalGroupPercentile{}
+	for _, r := range nav {
+		gotNav[r.Key] = r
+	}
+	if len(gotNav) != 3 {
+		t.Fatalf("expected 3 navigation types, got %+v", nav)
+	}
+	if n := gotNav["navigate"]; n.Count != 3 || math.Abs(n.Value-2000) > 1e-9 {
+		t.Fatalf("unexpected navigate breakdown %+v", n)
+	}
+	if n := gotNav["back-forward-cache"]; n.Count != 1 || math.Abs(n.Value-100) > 1e-9 {
+		t.Fatalf("unexpected back-forward-cache breakdown %+v", n)
+	}
+
+	targets, err := GetTopAttributionTargets(ctx, d, service, P75, 1)
+	if err != nil {
+		t.Fatalf("GetTopAttributionTargets() error = %v", err)
+	}
+	if len(targets) != 1 || targets[0].Key != "#hero" || targets[0].Count != 3 || math.Abs(targets[0].Value-2500) > 1e-9 {
+		t.Fatalf("unexpected attribution targets %+v", targets)
+	}
+
+	pages, err := GetWorstPages(ctx, d, service, P75, 10)
+	if err != nil {
+		t.Fatalf("GetWorstPages() error = %v", err)
+	}
+	if len(pages) != 2 || pages[0].Key != "/posts/:id" || math.Abs(pages[0].Value-4500) > 1e-9 || pages[1].Key != "/" {
+		t.Fatalf("unexpected worst pages %+v", pages)
+	}
+}
@@ -0,0 +1,341 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icco/gutil/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const meterName = "github.com/icco/reportd/pkg/db"
+
+// ReportTypeWebVital is the report type retention overrides use for
+// web_vitals rows, which have no report_type column.
+const ReportTypeWebVital = "web-vital"
+
+// RetentionPolicy says how long rows are kept. The most specific rule
+// wins: service and report type, then report type, then service, then
+// Default. A zero duration keeps rows forever, so an override of 0
+// exempts a service or type from a global default.
+type RetentionPolicy struct {
+	Default      time.Duration
+	Services     map[string]time.Duration
+	Types        map[string]time.Duration
+	ServiceTypes map[string]map[string]time.Duration
+}
+
+// For returns how long rows of reportType for service are kept.
+func (p RetentionPolicy) For(service, reportType string) time.Duration {
+	if d, ok := p.ServiceTypes[service][reportType]; ok {
+		return d
+	}
+	if d, ok := p.Types[reportType]; ok {
+		return d
+	}
+	if d, ok := p.Services[service]; ok {
+		return d
+	}
+	return p.Default
+}
+
+// Enabled reports whether any rule deletes anything.
+func (p RetentionPolicy) Enabled() bool {
+	if p.Default > 0 {
+		return true
+	}
+	for _, d := range p.Services {
+		if d > 0 {
+			return true
+		}
+	}
+	for _, d := range p.Types {
+		if d > 0 {
+			return true
+		}
+	}
+	for _, byType := range p.ServiceTypes {
+		for _, d := range byType {
+			if d > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseRetentionPolicy builds a policy from the --retention and
+// --retention_overrides flags. def is a duration; overrides is a
+// comma-separated list of key=duration where key is a service, a
+// service/report-type pair or */report-type. Durations accept a "d" suffix
+// for days, so "90d" keeps rows for 90 days.
+func ParseRetentionPolicy(def, overrides string) (RetentionPolicy, error) {
+	var p RetentionPolicy
+	var err error
+	if p.Default, err = ParseRetention(def); err != nil {
+		return p, fmt.Errorf("retention: %w", err)
+	}
+
+	for _, o := range strings.Split(overrides, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(o, "=")
+		if !ok {
+			return p, fmt.Errorf("retention override %q: want key=duration", o)
+		}
+		d, err := ParseRetention(val)
+		if err != nil {
+			return p, fmt.Errorf("retention override %q: %w", o, err)
+		}
+		service, reportType, typed := strings.Cut(strings.TrimSpace(key), "/")
+		switch {
+		case service == "" || (typed && reportType == ""):
+			return p, fmt.Errorf("retention override %q: empty service or report type", o)
+		case !typed:
+			if p.Services == nil {
+				p.Services = map[string]time.Duration{}
+			}
+			p.Services[service] = d
+		case service == "*":
+			if p.Types == nil {
+				p.Types = map[string]time.Duration{}
+			}
+			p.Types[reportType] = d
+		default:
+			if p.ServiceTypes == nil {
+				p.ServiceTypes = map[string]map[string]time.Duration{}
+			}
+			if p.ServiceTypes[service] == nil {
+				p.ServiceTypes[service] = map[string]time.Duration{}
+			}
+			p.ServiceTypes[service][reportType] = d
+		}
+	}
+	return p, nil
+}
+
+// ParseRetention parses a retention period: a Go duration such as "720h",
+// a number of days such as "30d", or "" or "0" to keep forever.
+func ParseRetention(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("retention %q must not be negative", s)
+	}
+	return d, nil
+}
+
+// PurgeCount is how many rows of one service and report type a purge
+// deleted from a table. For the issues table, which is never purged,
+// Deleted counts the samples it cleared instead.
+type PurgeCount struct {
+	Table      string `json:"table"`
+	Service    string `json:"service"`
+	ReportType string `json:"report_type"`
+	Deleted    int64  `json:"deleted"`
+}
+
+// retentionTable is a table the purge job deletes from.
+type retentionTable struct {
+	name  string
+	model func() any
+	// typed tables have a report_type column; the rest are treated as
+	// ReportTypeWebVital.
+	typed bool
+}
+
+var retentionTables = []retentionTable{
+	{"web_vitals", func() any { return &WebVital{} }, false},
+	{"report_to_entries", func() any { return &ReportToEntry{} }, true},
+	{"security_report_entries", func() any { return &SecurityReportEntry{} }, true},
+}
+
+// Purge hard-deletes rows older than policy allows, batchSize rows per
+// statement so no single delete holds locks for long. Soft-deleted rows
+// are purged like any other. Issues outlive their reports, but the copy
+// of the first report each keeps as its sample is cleared once that
+// report would have been purged. It returns a count for every service
+// and report type it deleted rows of, including on error.
+func Purge(ctx context.Context, d *gorm.DB, policy RetentionPolicy, batchSize int, now time.Time) ([]PurgeCount, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	var counts []PurgeCount
+	for _, t := range retentionTables {
+		type group struct {
+			Service    string
+			ReportType string
+		}
+		var groups []group
+		cols := []any{"service"}
+		if t.typed {
+			cols = append(cols, "report_type")
+		}
+		if err := d.WithContext(ctx).Unscoped().Model(t.model()).Distinct(cols...).Find(&groups).Error; err != nil {
+			return counts, fmt.Errorf("listing services in %s: %w", t.name, err)
+		}
+
+		for _, g := range groups {
+			if !t.typed {
+				g.ReportType = ReportTypeWebVital
+			}
+			keep := policy.For(g.Service, g.ReportType)
+			if keep <= 0 {
+				continue
+			}
+			cutoff := now.Add(-keep)
+			where := d.Where("service = ? AND created_at < ?", g.Service, cutoff)
+			if t.typed {
+				where = where.Where("report_type = ?", g.ReportType)
+			}
+
+			c := PurgeCount{Table: t.name, Service: g.Service, ReportType: g.ReportType}
+			for {
+				if err := ctx.Err(); err != nil {
+					return appendPurgeCount(counts, c), err
+				}
+				ids := d.Unscoped().Model(t.model()).Select("id").Where(where).Order("id").Limit(batchSize)
+				res := d.WithContext(ctx).Unscoped().Where("id IN (?)", ids).Delete(t.model())
+				if res.Error != nil {
+					return appendPurgeCount(counts, c), fmt.Errorf("purging %s for %s: %w", t.name, g.Service, res.Error)
+				}
+				c.Deleted += res.RowsAffected
+				if res.RowsAffected < int64(batchSize) {
+					break
+				}
+			}
+			counts = appendPurgeCount(counts, c)
+		}
+	}
+	return purgeIssueSamples(ctx, d, policy, batchSize, now, counts)
+}
+
+// purgeIssueSamples clears the samples of issues first seen before policy
+// allows for their service and report type, appending to counts.
+func purgeIssueSamples(ctx context.Context, d *gorm.DB, policy RetentionPolicy, batchSize int, now time.Time, counts []PurgeCount) ([]PurgeCount, error) {
+	var groups []struct {
+		Service    string
+		ReportType string
+	}
+	err := d.WithContext(ctx).
+		Model(&Issue{}).
+		Distinct("service", "report_type").
+		Where("sample <> ''").
+		Find(&groups).Error
+	if err != nil {
+		return counts, fmt.Errorf("listing services in issues: %w", err)
+	}
+
+	for _, g := range groups {
+		keep := policy.For(g.Service, g.ReportType)
+		if keep <= 0 {
+			continue
+		}
+		where := d.Where("service = ? AND report_type = ? AND first_seen < ? AND sample <> ''", g.Service, g.ReportType, now.Add(-keep))
+
+		c := PurgeCount{Table: "issues", Service: g.Service, ReportType: g.ReportType}
+		for {
+			if err := ctx.Err(); err != nil {
+				return appendPurgeCount(counts, c), err
+			}
+			ids := d.Model(&Issue{}).Select("id").Where(where).Order("id").Limit(batchSize)
+			res := d.WithContext(ctx).Model(&Issue{}).Where("id IN (?)", ids).Update("sample", "")
+			if res.Error != nil {
+				return appendPurgeCount(counts, c), fmt.Errorf("clearing issue samples for %s: %w", g.Service, res.Error)
+			}
+			c.Deleted += res.RowsAffected
+			if res.RowsAffected < int64(batchSize) {
+				break
+			}
+		}
+		counts = appendPurgeCount(counts, c)
+	}
+	return counts, nil
+}
+
+func appendPurgeCount(counts []PurgeCount, c PurgeCount) []PurgeCount {
+	if c.Deleted == 0 {
+		return counts
+	}
+	return append(counts, c)
+}
+
+// PurgeOptions configure RunPurger.
+type PurgeOptions struct {
+	// Interval between purges. Defaults to an hour.
+	Interval time.Duration
+	// BatchSize caps rows deleted per statement. Defaults to 1000.
+	BatchSize int
+}
+
+// RunPurger purges once, then every opts.Interval, until ctx is done.
+// Each run is logged with how many rows it deleted and counted in the
+// reportd.retention.* metrics.
+func RunPurger(ctx context.Context, d *gorm.DB, policy RetentionPolicy, opts PurgeOptions) {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Hour
+	}
+	meter := otel.Meter(meterName)
+	purged, _ := meter.Int64Counter("reportd.retention.purged",
+		metric.WithDescription("Rows deleted, or issue samples cleared, by the retention job, by table and service."))
+	runs, _ := meter.Float64Histogram("reportd.retention.run.duration",
+		metric.WithDescription("Time spent in each retention purge, by result."),
+		metric.WithUnit("s"))
+
+	l := logging.FromContext(ctx)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		counts, err := Purge(ctx, d, policy, opts.BatchSize, start)
+
+		var total int64
+		for _, c := range counts {
+			total += c.Deleted
+			purged.Add(ctx, c.Deleted, metric.WithAttributes(
+				attribute.String("table", c.Table),
+				attribute.String("service", c.Service),
+			))
+			l.Infow("purged expired rows", "table", c.Table, "service", c.Service, "report_type", c.ReportType, "deleted", c.Deleted)
+		}
+		result := "ok"
+		if err != nil {
+			result = "error"
+			if ctx.Err() == nil {
+				l.Errorw("retention purge failed", zap.Error(err))
+			}
+		}
+		runs.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attribute.String("result", result)))
+		l.Infow("retention purge finished", "deleted", total, "duration", time.Since(start), "result", result)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestParseRetention(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"30d", 30 * 24 * time.Hour},
+		{"36h", 36 * time.Hour},
+	} {
+		got, err := ParseRetention(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseRetention(%q) = %v, %v; want %v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"xd", "-1h", "-3d", "forever"} {
+		if _, err := ParseRetention(bad); err == nil {
+			t.Errorf("ParseRetention(%q) expected error", bad)
+		}
+	}
+}
+
+func TestRetentionPolicyFor(t *testing.T) {
+	p, err := ParseRetentionPolicy("90d", "blog=30d, blog/deprecation=7d, */csp-violation=14d, archive=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := 24 * time.Hour
+	for _, tc := range []struct {
+		service, reportType string
+		want                time.Duration
+	}{
+		{"shop", "deprecation", 90 * day},
+		{"blog", "network-error", 30 * day},
+		{"blog", "deprecation", 7 * day},
+		{"blog", "csp-violation", 14 * day},
+		{"shop", "csp-violation", 14 * day},
+		{"archive", ReportTypeWebVital, 0},
+	} {
+		if got := p.For(tc.service, tc.reportType); got != tc.want {
+			t.Errorf("For(%q, %q) = %v, want %v", tc.service, tc.reportType, got, tc.want)
+		}
+	}
+	if !p.Enabled() {
+		t.Error("Enabled() = false, want true")
+	}
+
+	if p, err := ParseRetentionPolicy("", "archive=0"); err != nil || p.Enabled() {
+		t.Errorf("keep-forever policy = %+v, %v; want disabled", p, err)
+	}
+	for _, bad := range []string{"blog", "=30d", "blog/=30d", "blog=soon"} {
+		if _, err := ParseRetentionPolicy("", bad); err == nil {
+			t.Errorf("ParseRetentionPolicy(%q) expected error", bad)
+		}
+	}
+}
+
+// seedRetentionFixtures stores one old and one new row per table, plus an
+// old soft-deleted vital and an old CSP report the policy keeps longer.
+func seedRetentionFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	now := time.Now()
+	old := now.AddDate(0, 0, -10)
+
+	for _, at := range []time.Time{old, old, now} {
+		if err := d.Create(&WebVital{CreatedAt: at, Service: service, Name: "LCP", Value: 1}).Error; err != nil {
+			t.Fatalf("seed web vital: %v", err)
+		}
+		if err := d.Create(&ReportToEntry{CreatedAt: at, Service: service, ReportType: "deprecation", RawJSON: "{}"}).Error; err != nil {
+			t.Fatalf("seed report-to: %v", err)
+		}
+		if err := d.Create(&SecurityReportEntry{CreatedAt: at, Service: service, ReportType: "deprecation", RawJSON: "{}"}).Error; err != nil {
+			t.Fatalf("seed security report: %v", err)
+		}
+	}
+	if err := d.Create(&SecurityReportEntry{CreatedAt: old, Service: service, ReportType: reportTypeCSPViolation, RawJSON: "{}"}).Error; err != nil {
+		t.Fatalf("seed csp report: %v", err)
+	}
+	if err := d.Where("service = ?", service).Limit(1).Delete(&WebVital{}).Error; err != nil {
+		t.Fatalf("soft-deleting vital: %v", err)
+	}
+	for _, is := range []*Issue{
+		{Service: service, Fingerprint: "retention-old", ReportType: "deprecation", FirstSeen: old, LastSeen: now, Count: 2, Sample: `{"old":true}`},
+		{Service: service, Fingerprint: "retention-new", ReportType: "deprecation", FirstSeen: now, LastSeen: now, Count: 1, Sample: `{"new":true}`},
+		{Service: service, Fingerprint: "retention-csp", ReportType: reportTypeCSPViolation, FirstSeen: old, LastSeen: old, Count: 1, Sample: `{"csp":true}`},
+	} {
+		if err := d.Create(is).Error; err != nil {
+			t.Fatalf("seed issue: %v", err)
+		}
+	}
+}
+
+func assertPurge(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	policy, err := ParseRetentionPolicy("", service+"=7d,*/"+reportTypeCSPViolation+"=30d")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch size of 1 makes every group take several statements.
+	counts, err := Purge(ctx, d, policy, 1, time.Now())
+	if err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	want := map[string]int64{"web_vitals": 2, "report_to_entries": 2, "security_report_entries": 2, "issues": 1}
+	got := map[string]int64{}
+	for _, c := range counts {
+		if c.Service != service {
+			t.Errorf("Purge() deleted rows of %q: %+v", c.Service, c)
+		}
+		got[c.Table] += c.Deleted
+	}
+	for table, n := range want {
+		if got[table] != n {
+			t.Errorf("Purge() deleted %d rows from %s, want %d", got[table], table, n)
+		}
+	}
+
+	for _, tc := range []struct {
+		model any
+		want  int64
+	}{
+		{&WebVital{}, 1},
+		{&ReportToEntry{}, 1},
+		{&SecurityReportEntry{}, 2},
+	} {
+		var n int64
+		if err := d.WithContext(ctx).Unscoped().Model(tc.model).Where("service = ?", service).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		if n != tc.want {
+			t.Errorf("%T rows left = %d, want %d", tc.model, n, tc.want)
+		}
+	}
+
+	// Issues are kept, but the old deprecation report's copy is cleared.
+	var issues []Issue
+	if err := d.WithContext(ctx).Where("service = ?", service).Find(&issues).Error; err != nil {
+		t.Fatal(err)
+	}
+	samples := map[string]string{}
+	for _, is := range issues {
+		samples[is.Fingerprint] = is.Sample
+	}
+	wantSamples := map[string]string{"retention-csp": `{"csp":true}`, "retention-new": `{"new":true}`, "retention-old": ""}
+	if !maps.Equal(samples, wantSamples) {
+		t.Errorf("issue samples after Purge() = %v, want %v", samples, wantSamples)
+	}
+
+	if counts, err := Purge(ctx, d, policy, 1, time.Now()); err != nil || len(counts) != 0 {
+		t.Errorf("second Purge() = %+v, %v; want nothing left to delete", counts, err)
+	}
+}
@@ -0,0 +1,279 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/icco/gutil/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WebVitalDailyRollup is one metric's samples for a service on one UTC
+// day: how many, their sum and a sketch to estimate percentiles from.
+// Count, Sum and the sketch's weights are all scaled up for ingest
+// sampling.
+type WebVitalDailyRollup struct {
+	ID      uint    `gorm:"primaryKey" json:"-"`
+	Service string  `gorm:"uniqueIndex:idx_web_vital_rollups_key;not null" json:"service"`
+	Day     Day     `gorm:"type:date;uniqueIndex:idx_web_vital_rollups_key;not null" json:"day"`
+	Name    string  `gorm:"uniqueIndex:idx_web_vital_rollups_key;not null" json:"name"`
+	Count   int64   `gorm:"not null" json:"count"`
+	Sum     float64 `gorm:"not null" json:"sum"`
+	Sketch  string  `gorm:"type:text" json:"-"`
+}
+
+// ReportDailyRollup is how many reports of one type a service got for
+// events that occurred on one UTC day, across both report tables.
+type ReportDailyRollup struct {
+	ID         uint   `gorm:"primaryKey" json:"-"`
+	Service    string `gorm:"uniqueIndex:idx_report_rollups_key;not null" json:"service"`
+	Day        Day    `gorm:"type:date;uniqueIndex:idx_report_rollups_key;not null" json:"day"`
+	ReportType string `gorm:"uniqueIndex:idx_report_rollups_key;not null" json:"report_type"`
+	Count      int64  `gorm:"not null" json:"count"`
+}
+
+// RollupState records the days the rollup tables are complete for, Since
+// through Through. Query helpers read rollups for those days and raw rows
+// for the rest.
+type RollupState struct {
+	Name      string `gorm:"primaryKey"`
+	Since     Day    `gorm:"type:date"`
+	Through   Day    `gorm:"type:date"`
+	UpdatedAt time.Time
+}
+
+const dailyRollup = "daily"
+
+// rollupLockID is the Postgres advisory lock key held while a day's
+// rollups are replaced, so replicas running the job together take turns
+// instead of interleaving their deletes and inserts.
+const rollupLockID int64 = migrationLockID + 1
+
+// rolledUp returns the first and last days covered by the rollup tables,
+// or zero times if they have never been built.
+func rolledUp(ctx context.Context, d *gorm.DB) (since, through time.Time, err error) {
+	var st RollupState
+	err = d.WithContext(ctx).Where("name = ?", dailyRollup).Take(&st).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("querying rollup state: %w", err)
+	}
+	return time.Time(st.Since), time.Time(st.Through), nil
+}
+
+// utcDay truncates t to the start of its UTC day.
+func utcDay(t time.Time) time.Time {
+	y, m, dd := t.UTC().Date()
+	return time.Date(y, m, dd, 0, 0, 0, 0, time.UTC)
+}
+
+// RollupDay rebuilds both rollup tables' rows for the UTC day holding day
+// from the raw tables, replacing whatever was there.
+func RollupDay(ctx context.Context, d *gorm.DB, day time.Time) error {
+	start := utcDay(day)
+	end := start.AddDate(0, 0, 1)
+
+	type vitalKey struct{ service, name string }
+	vitals := map[vitalKey]*WebVitalDailyRollup{}
+	sketches := map[vitalKey]*valueSketch{}
+	counts := map[vitalKey]float64{}
+	rows, err := d.WithContext(ctx).
+		Model(&WebVital{}).
+		Select("service, name, value, sample_rate").
+		Where("created_at >= ? AND created_at < ?", start, end).
+		Rows()
+	if err != nil {
+		return fmt.Errorf("reading web vitals for %s: %w", start.Format(time.DateOnly), err)
+	}
+	for rows.Next() {
+		var k vitalKey
+		var value, rate float64
+		if err := rows.Scan(&k.service, &k.name, &value, &rate); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("reading web vitals for %s: %w", start.Format(time.DateOnly), err)
+		}
+		r, ok := vitals[k]
+		if !ok {
+			r = &WebVitalDailyRollup{Service: k.service, Day: Day(start), Name: k.name}
+			vitals[k] = r
+			sketches[k] = &valueSketch{}
+		}
+		counts[k] += 1 / rate
+		r.Sum += value / rate
+		sketches[k].add(value, 1/rate)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("reading web vitals for %s: %w", start.Format(time.DateOnly), err)
+	}
+
+	type reportKey struct{ service, reportType string }
+	reports := map[reportKey]*ReportDailyRollup{}
+	for _, model := range []any{&ReportToEntry{}, &SecurityReportEntry{}} {
+		var counts []struct {
+			Service    string
+			ReportType string
+			Count      int64
+		}
+		err := d.WithContext(ctx).
+			Model(model).
+			Select("service, report_type, "+sampledCount+" AS count").
+			Where("occurred_at >= ? AND occurred_at < ?", start, end).
+			Group("service, report_type").
+			Find(&counts).Error
+		if err != nil {
+			return fmt.Errorf("counting reports for %s: %w", start.Format(time.DateOnly), err)
+		}
+		for _, c := range counts {
+			k := reportKey{c.Service, c.ReportType}
+			if reports[k] == nil {
+				reports[k] = &ReportDailyRollup{Service: c.Service, Day: Day(start), ReportType: c.ReportType}
+			}
+			reports[k].Count += c.Count
+		}
+	}
+
+	vitalRows := make([]*WebVitalDailyRollup, 0, len(vitals))
+	for k, r := range vitals {
+		r.Count = int64(math.Round(counts[k]))
+		r.Sketch = sketches[k].encode()
+		vitalRows = append(vitalRows, r)
+	}
+	reportRows := make([]*ReportDailyRollup, 0, len(reports))
+	for _, r := range reports {
+		reportRows = append(reportRows, r)
+	}
+
+	return d.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// SQLite already lets one writer in at a time.
+		if tx.Dialector.Name() != dialectSQLite {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", rollupLockID).Error; err != nil {
+				return fmt.Errorf("acquiring rollup lock: %w", err)
+			}
+		}
+		if err := tx.Where("day = ?", Day(start)).Delete(&WebVitalDailyRollup{}).Error; err != nil {
+			return fmt.Errorf("clearing web vital rollups: %w", err)
+		}
+		if err := tx.Where("day = ?", Day(start)).Delete(&ReportDailyRollup{}).Error; err != nil {
+			return fmt.Errorf("clearing report rollups: %w", err)
+		}
+		if len(vitalRows) > 0 {
+			if err := tx.CreateInBatches(vitalRows, 500).Error; err != nil {
+				return fmt.Errorf("storing web vital rollups: %w", err)
+			}
+		}
+		if len(reportRows) > 0 {
+			if err := tx.CreateInBatches(reportRows, 500).Error; err != nil {
+				return fmt.Errorf("storing report rollups: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// RollupOptions configure Rollup and RunRollups.
+type RollupOptions struct {
+	// Interval between runs of RunRollups. Defaults to an hour.
+	Interval time.Duration
+	// Lookback is how many already rolled-up days are rebuilt on each
+	// run to pick up late rows. Defaults to 2.
+	Lookback int
+	// Backfill is how many days are built on the first run. Defaults to
+	// 92, the dashboard's three-month window.
+	Backfill int
+}
+
+func (o RollupOptions) withDefaults() RollupOptions {
+	if o.Interval <= 0 {
+		o.Interval = time.Hour
+	}
+	if o.Lookback <= 0 {
+		o.Lookback = 2
+	}
+	if o.Backfill <= 0 {
+		o.Backfill = 92
+	}
+	return o
+}
+
+// Rollup brings the rollup tables up to date through yesterday (UTC):
+// every day since the last run, plus opts.Lookback days before it, or
+// opts.Backfill days on the first run. It returns how many days it built.
+func Rollup(ctx context.Context, d *gorm.DB, opts RollupOptions, now time.Time) (int, error) {
+	opts = opts.withDefaults()
+	yesterday := utcDay(now).AddDate(0, 0, -1)
+	_, through, err := rolledUp(ctx, d)
+	if err != nil {
+		return 0, err
+	}
+
+	from := yesterday.AddDate(0, 0, 1-opts.Backfill)
+	if !through.IsZero() {
+		if resume := utcDay(through).AddDate(0, 0, 1-opts.Lookback); resume.After(from) {
+			from = resume
+		}
+	}
+
+	built := 0
+	for day := from; !day.After(yesterday); day = day.AddDate(0, 0, 1) {
+		if err := RollupDay(ctx, d, day); err != nil {
+			return built, err
+		}
+		built++
+	}
+
+	// Since is only set once: the first run's backfill, or for state
+	// written before it was tracked, the first day rebuilt since.
+	st := RollupState{Name: dailyRollup, Since: Day(from), Through: Day(yesterday)}
+	err = d.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "name"}},
+		DoUpdates: append(clause.AssignmentColumns([]string{"through", "updated_at"}),
+			clause.Assignment{Column: clause.Column{Name: "since"}, Value: gorm.Expr("COALESCE(rollup_states.since, excluded.since)")}),
+	}).Create(&st).Error
+	if err != nil {
+		return built, fmt.Errorf("storing rollup state: %w", err)
+	}
+	return built, nil
+}
+
+// RunRollups calls Rollup now and every opts.Interval until ctx is done.
+func RunRollups(ctx context.Context, d *gorm.DB, opts RollupOptions) {
+	opts = opts.withDefaults()
+	meter := otel.Meter(meterName)
+	runs, _ := meter.Float64Histogram("reportd.rollup.run.duration",
+		metric.WithDescription("Time spent bringing the daily rollup tables up to date, by result."),
+		metric.WithUnit("s"))
+
+	l := logging.FromContext(ctx)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		days, err := Rollup(ctx, d, opts, start)
+		result := "ok"
+		if err != nil {
+			result = "error"
+			if ctx.Err() == nil {
+				l.Errorw("daily rollup failed", zap.Error(err), "days", days)
+			}
+		} else {
+			l.Infow("daily rollup finished", "days", days, "duration", time.Since(start))
+		}
+		runs.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attribute.String("result", result)))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
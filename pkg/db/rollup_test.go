@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestSketchQuantile(t *testing.T) {
+	var s valueSketch
+	values := make([]float64, 0, 1000)
+	for i := 1; i <= 1000; i++ {
+		v := float64(i * i % 9973)
+		s.add(v, 1)
+		values = append(values, v)
+	}
+	s.add(0, 1)
+	values = append(values, 0)
+
+	round, err := decodeSketch(s.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(values)
+	for _, p := range []Percentile{P50, P75, P95} {
+		want := percentileOf(values, nil, p)
+		if got := round.quantile(p); math.Abs(got-want) > want*sketchAccuracy*2 {
+			t.Errorf("quantile(%s) = %v, want %v within %g%%", p, got, want, sketchAccuracy*200)
+		}
+	}
+
+	// Merging two halves gives the same estimate as one sketch.
+	var a, b valueSketch
+	for i, v := range values {
+		if i%2 == 0 {
+			a.add(v, 1)
+		} else {
+			b.add(v, 1)
+		}
+	}
+	a.merge(b)
+	if a.count() != s.count() || a.quantile(P75) != s.quantile(P75) {
+		t.Errorf("merged sketch = %v values p75 %v, want %v values p75 %v", a.count(), a.quantile(P75), s.count(), s.quantile(P75))
+	}
+
+	var empty valueSketch
+	if got := empty.quantile(P50); got != 0 {
+		t.Errorf("empty quantile = %v, want 0", got)
+	}
+}
+
+// seedRollupFixtures stores vitals and reports three days ago, which the
+// rollup covers, and today, which it leaves to the raw tables.
+func seedRollupFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	now := time.Now()
+	for _, at := range []time.Time{now.AddDate(0, 0, -3), now} {
+		for _, v := range []float64{1000, 2000, 3000, 4000} {
+			if err := d.Create(&WebVital{CreatedAt: at, Service: service, Name: "LCP", Value: v}).Error; err != nil {
+				t.Fatalf("seed web vital: %v", err)
+			}
+		}
+		if err := d.Create(&ReportToEntry{CreatedAt: at, Service: service, ReportType: reportTypeCSPViolation, RawJSON: "{}"}).Error; err != nil {
+			t.Fatalf("seed report-to: %v", err)
+		}
+		if err := d.Create(&SecurityReportEntry{CreatedAt: at, Service: service, ReportType: reportTypeCSPViolation, RawJSON: "{}"}).Error; err != nil {
+			t.Fatalf("seed security report: %v", err)
+		}
+	}
+}
+
+func assertRollups(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	now := time.Now()
+	// A lookback reaching the seeded day rebuilds it even if a shared
+	// database was rolled up before.
+	opts := RollupOptions{Lookback: 4}
+	if _, err := Rollup(ctx, d, opts, now); err != nil {
+		t.Fatalf("Rollup() error = %v", err)
+	}
+	past := Day(utcDay(now.AddDate(0, 0, -3)))
+
+	var rollup WebVitalDailyRollup
+	if err := d.WithContext(ctx).Where("service = ? AND day = ?", service, past).Take(&rollup).Error; err != nil {
+		t.Fatalf("loading web vital rollup: %v", err)
+	}
+	if rollup.Name != "LCP" || rollup.Count != 4 || rollup.Sum != 10000 {
+		t.Errorf("rollup = %+v, want 4 LCP samples summing to 10000", rollup)
+	}
+
+	summaries, err := GetWebVitalSummaries(ctx, d, service, P75, TimeRange{})
+	if err != nil {
+		t.Fatalf("GetWebVitalSummaries() error = %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("GetWebVitalSummaries() = %+v, want today and 3 days ago", summaries)
+	}
+	if today := summaries[0]; today.Value != 3250 {
+		t.Errorf("today's p75 = %v, want exactly 3250 from raw rows", today.Value)
+	}
+	if old := summaries[1]; !time.Time(old.Day).Equal(time.Time(past)) || math.Abs(old.Value-3250) > 3250*sketchAccuracy*2 {
+		t.Errorf("rolled-up summary = %+v, want p75 near 3250 on %v", old, time.Time(past))
+	}
+
+	counts, err := GetReportCounts(ctx, d, service, TimeRange{})
+	if err != nil {
+		t.Fatalf("GetReportCounts() error = %v", err)
+	}
+	// Today's raw counts come per table; the rollup merges them.
+	if len(counts) != 3 || counts[2].Count != 2 || counts[2].ReportType != reportTypeCSPViolation {
+		t.Errorf("GetReportCounts() = %+v, want 1 + 1 today and 2 rolled up", counts)
+	}
+
+	// A late row for a rolled-up day is picked up by the lookback.
+	late := utcDay(now).AddDate(0, 0, -1).Add(time.Hour)
+	if err := d.Create(&SecurityReportEntry{CreatedAt: late, Service: service, ReportType: "deprecation", RawJSON: "{}"}).Error; err != nil {
+		t.Fatalf("seed late report: %v", err)
+	}
+	if days, err := Rollup(ctx, d, opts, now); err != nil || days != 4 {
+		t.Errorf("second Rollup() = %d days, %v; want the 4 lookback days", days, err)
+	}
+	counts, err = GetReportCounts(ctx, d, service, TimeRange{})
+	if err != nil {
+		t.Fatalf("GetReportCounts() error = %v", err)
+	}
+	if len(counts) != 4 {
+		t.Errorf("GetReportCounts() after the late row = %+v, want it counted", counts)
+	}
+
+	// A rolled-up week is read from its days' rollups, merged, even once
+	// the raw rows are gone.
+	week := TimeRange{Interval: IntervalWeek}.rollupBucket(now).AddDate(0, 0, -14)
+	for i, at := range []time.Time{week.Add(time.Hour), week.AddDate(0, 0, 2).Add(time.Hour)} {
+		for _, v := range []float64{float64(2*i+1) * 1000, float64(2*i+2) * 1000} {
+			if err := d.Create(&WebVital{CreatedAt: at, Service: service, Name: "LCP", Value: v}).Error; err != nil {
+				t.Fatalf("seed web vital: %v", err)
+			}
+		}
+		if err := d.Create(&ReportToEntry{CreatedAt: at, Service: service, ReportType: reportTypeCSPViolation, RawJSON: "{}"}).Error; err != nil {
+			t.Fatalf("seed report-to: %v", err)
+		}
+		if err := RollupDay(ctx, d, at); err != nil {
+			t.Fatalf("RollupDay() error = %v", err)
+		}
+	}
+	weekEnd := week.AddDate(0, 0, 7)
+	for _, model := range []any{&WebVital{}, &ReportToEntry{}} {
+		if err := d.WithContext(ctx).Unscoped().Where("service = ? AND created_at < ?", service, weekEnd).Delete(model).Error; err != nil {
+			t.Fatalf("deleting raw rows: %v", err)
+		}
+	}
+	weekly := TimeRange{From: week, To: weekEnd, Interval: IntervalWeek, Location: time.UTC}
+	summaries, err = GetWebVitalSummaries(ctx, d, service, P75, weekly)
+	if err != nil {
+		t.Fatalf("GetWebVitalSummaries() weekly error = %v", err)
+	}
+	if len(summaries) != 1 || !summaries[0].Bucket.Equal(week) || math.Abs(summaries[0].Value-3250) > 3250*sketchAccuracy*2 {
+		t.Errorf("GetWebVitalSummaries() weekly = %+v, want one p75 near 3250 for %v", summaries, week)
+	}
+	counts, err = GetReportCounts(ctx, d, service, weekly)
+	if err != nil {
+		t.Fatalf("GetReportCounts() weekly error = %v", err)
+	}
+	if len(counts) != 1 || !counts[0].Bucket.Equal(week) || counts[0].Count != 2 {
+		t.Errorf("GetReportCounts() weekly = %+v, want 2 reports for %v", counts, week)
+	}
+}
+
+// assertConcurrentRollups rebuilds the seeded day from several goroutines
+// at once, as replicas sharing a database do, and checks it ends up
+// stored once.
+func assertConcurrentRollups(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	day := time.Now().AddDate(0, 0, -3)
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for range 4 {
+		wg.Go(func() { errs <- RollupDay(ctx, d, day) })
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent RollupDay() error = %v", err)
+		}
+	}
+
+	var rollups []WebVitalDailyRollup
+	if err := d.WithContext(ctx).Where("service = ? AND day = ?", service, Day(utcDay(day))).Find(&rollups).Error; err != nil {
+		t.Fatalf("loading web vital rollups: %v", err)
+	}
+	if len(rollups) != 1 || rollups[0].Count != 4 {
+		t.Errorf("rollups after concurrent runs = %+v, want one row of 4 samples", rollups)
+	}
+}
@@ -0,0 +1,235 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Sources of a ReportSearchResult.
+const (
+	SourceReportTo  = "report-to"
+	SourceReporting = "reporting"
+)
+
+// ErrInvalidCursor is returned by SearchReports for a cursor it did not
+// issue.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ReportSearch filters SearchReports. Empty fields match everything.
+// DocumentURL and SourceFile must match exactly; BlockedHost and
+// BrowserFamily are compared case-insensitively.
+type ReportSearch struct {
+	ReportType    string
+	Directive     string
+	BlockedHost   string
+	DocumentURL   string
+	SourceFile    string
+	BrowserFamily string
+	From, To      time.Time
+	// Cursor is the Next of the previous page, or "" for the first.
+	Cursor string
+	Limit  int
+}
+
+// ReportSearchResult is one stored report from either report table.
+// Directive is the violated directive, falling back to the effective one,
+// as in GetTopViolatedDirectives.
+type ReportSearchResult struct {
+	Source        string    `json:"source"`
+	ID            uint      `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	ReportType    string    `json:"report_type"`
+	DocumentURL   string    `json:"document_url"`
+	BlockedURI    string    `json:"blocked_uri,omitempty"`
+	Directive     string    `json:"directive,omitempty"`
+	SourceFile    string    `json:"source_file,omitempty"`
+	LineNumber    int       `json:"line_number,omitempty"`
+	ColumnNumber  int       `json:"column_number,omitempty"`
+	Message       string    `json:"message,omitempty"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+	BrowserFamily string    `json:"browser_family,omitempty"`
+	Fingerprint   string    `json:"fingerprint,omitempty"`
+	RawJSON       string    `json:"raw_json,omitempty"`
+}
+
+// ReportSearchPage is a page of SearchReports results, newest first, and
+// the cursor for the next page, empty on the last.
+type ReportSearchPage struct {
+	Reports []ReportSearchResult `json:"reports"`
+	Next    string               `json:"next,omitempty"`
+}
+
+// searchCursor is the position of the last result on a page. Results are
+// ordered by CreatedAt descending, then Source, then ID descending.
+type searchCursor struct {
+	CreatedAt time.Time `json:"t"`
+	Source    string    `json:"s"`
+	ID        uint      `json:"id"`
+}
+
+func (c searchCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeSearchCursor(s string) (searchCursor, error) {
+	var c searchCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(raw, &c) != nil || (c.Source != SourceReportTo && c.Source != SourceReporting) {
+		return c, ErrInvalidCursor
+	}
+	// SQLite compares created_at as text, which rows are stored in UTC.
+	c.CreatedAt = c.CreatedAt.UTC()
+	return c, nil
+}
+
+// after restricts q, over the table results of source come from, to rows
+// ordered after c.
+func (c searchCursor) after(q *gorm.DB, source string) *gorm.DB {
+	switch {
+	case source < c.Source:
+		return q.Where("created_at < ?", c.CreatedAt)
+	case source > c.Source:
+		return q.Where("created_at <= ?", c.CreatedAt)
+	default:
+		return q.Where("(created_at < ? OR (created_at = ? AND id < ?))", c.CreatedAt, c.CreatedAt, c.ID)
+	}
+}
+
+// SearchReports returns up to f.Limit reports for service matching f
+// across both report tables, newest first, with a cursor for the next
+// page. Triaged reports are included.
+func SearchReports(ctx context.Context, d *gorm.DB, service string, f ReportSearch) (*ReportSearchPage, error) {
+	if f.Limit <= 0 {
+		f.Limit = 50
+	}
+	var cursor *searchCursor
+	if f.Cursor != "" {
+		c, err := decodeSearchCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		cursor = &c
+	}
+
+	// where builds the filters for one table; urlColumn holds its
+	// document URL.
+	where := func(source, urlColumn string) *gorm.DB {
+		q := d.Where("service = ?", service)
+		if f.ReportType != "" {
+			q = q.Where("report_type = ?", f.ReportType)
+		}
+		if f.Directive != "" {
+			q = q.Where(directiveExpr+" = ?", f.Directive)
+		}
+		if f.BlockedHost != "" {
+			q = q.Where("blocked_host = ?", strings.ToLower(f.BlockedHost))
+		}
+		if f.DocumentURL != "" {
+			q = q.Where(urlColumn+" = ?", f.DocumentURL)
+		}
+		if f.SourceFile != "" {
+			q = q.Where("source_file = ?", f.SourceFile)
+		}
+		if f.BrowserFamily != "" {
+			q = q.Where("browser_family = ?", strings.ToLower(f.BrowserFamily))
+		}
+		if !f.From.IsZero() {
+			q = q.Where("created_at >= ?", f.From.UTC())
+		}
+		if !f.To.IsZero() {
+			q = q.Where("created_at < ?", f.To.UTC())
+		}
+		if cursor != nil {
+			q = cursor.after(q, source)
+		}
+		return q
+	}
+
+	// One more than a page from each table tells whether there is a next.
+	results := []ReportSearchResult{}
+	var rt []ReportToEntry
+	err := d.WithContext(ctx).
+		Where(where(SourceReportTo, "document_uri")).
+		Order("created_at DESC, id DESC").
+		Limit(f.Limit + 1).
+		Find(&rt).Error
+	if err != nil {
+		return nil, fmt.Errorf("searching report-to entries: %w", err)
+	}
+	for _, e := range rt {
+		results = append(results, ReportSearchResult{
+			Source:        SourceReportTo,
+			ID:            e.ID,
+			CreatedAt:     e.CreatedAt,
+			ReportType:    e.ReportType,
+			DocumentURL:   e.DocumentURI,
+			BlockedURI:    e.BlockedURI,
+			Directive:     cmp.Or(e.ViolatedDirective, e.EffectiveDirective),
+			SourceFile:    e.SourceFile,
+			LineNumber:    e.LineNumber,
+			ColumnNumber:  e.ColumnNumber,
+			UserAgent:     e.UserAgent,
+			BrowserFamily: e.BrowserFamily,
+			Fingerprint:   e.Fingerprint,
+			RawJSON:       e.RawJSON,
+		})
+	}
+
+	var sr []SecurityReportEntry
+	err = d.WithContext(ctx).
+		Where(where(SourceReporting, "url")).
+		Order("created_at DESC, id DESC").
+		Limit(f.Limit + 1).
+		Find(&sr).Error
+	if err != nil {
+		return nil, fmt.Errorf("searching security report entries: %w", err)
+	}
+	for _, e := range sr {
+		results = append(results, ReportSearchResult{
+			Source:        SourceReporting,
+			ID:            e.ID,
+			CreatedAt:     e.CreatedAt,
+			ReportType:    e.ReportType,
+			DocumentURL:   e.URL,
+			BlockedURI:    e.BlockedURI,
+			Directive:     cmp.Or(e.ViolatedDirective, e.EffectiveDirective),
+			SourceFile:    e.SourceFile,
+			LineNumber:    e.LineNumber,
+			ColumnNumber:  e.ColumnNumber,
+			Message:       e.Message,
+			UserAgent:     e.UserAgent,
+			BrowserFamily: e.BrowserFamily,
+			Fingerprint:   e.Fingerprint,
+			RawJSON:       e.RawJSON,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.ID > b.ID
+	})
+
+	page := &ReportSearchPage{Reports: results}
+	if len(results) > f.Limit {
+		page.Reports = results[:f.Limit]
+		last := page.Reports[f.Limit-1]
+		page.Next = searchCursor{CreatedAt: last.CreatedAt, Source: last.Source, ID: last.ID}.encode()
+	}
+	return page, nil
+}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	chromeUA  = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+	firefoxUA = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.4; rv:125.0) Gecko/20100101 Firefox/125.0"
+	safariUA  = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4_1) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15"
+)
+
+// searchBase is when the newest search fixture was received. The newest
+// report-to and reporting rows share it, so paging must order across
+// tables on more than the time.
+var searchBase = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestBlockedHost(t *testing.T) {
+	for _, tc := range []struct{ raw, want string }{
+		{"", ""},
+		{"https://CDN.Example.com:8443/a.js?x=1", "cdn.example.com"},
+		{"wss://socket.example.com/", "socket.example.com"},
+		{"inline", "inline"},
+		{"data", "data"},
+	} {
+		if got := blockedHost(tc.raw); got != tc.want {
+			t.Errorf("blockedHost(%q) = %q, want %q", tc.raw, got, tc.want)
+		}
+	}
+}
+
+// seedSearchFixtures stores two report-to and three reporting rows for
+// service, newest first:
+//
+//	searchBase     report-to  csp-violation  script-src-elem  cdn.evil.com  /a  chrome
+//	searchBase     reporting  csp-violation  img-src          data          /a  safari
+//	searchBase-1h  report-to  deprecation                                   /b  firefox
+//	searchBase-2h  reporting  deprecation                                   /b  chrome   app.js
+//	searchBase-3h  reporting  csp-violation  script-src-elem  cdn.evil.com  /c  firefox
+func seedSearchFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	ctx := context.Background()
+
+	rt := []*ReportToEntry{
+		{
+			CreatedAt:         searchBase,
+			Service:           service,
+			ReportType:        "csp-violation",
+			DocumentURI:       "https://example.com/a",
+			ViolatedDirective: "script-src-elem",
+			BlockedURI:        "https://CDN.Evil.com/x.js",
+			SourceFile:        "https://example.com/app.js",
+			Agent:             Agent{UserAgent: chromeUA},
+			RawJSON:           "{}",
+		},
+		{
+			CreatedAt:   searchBase.Add(-time.Hour),
+			Service:     service,
+			ReportType:  "deprecation",
+			DocumentURI: "https://example.com/b",
+			Agent:       Agent{UserAgent: firefoxUA},
+			RawJSON:     "{}",
+		},
+	}
+	for _, e := range rt {
+		e.setIssue(namedIssueKey(e.ReportType, e.BlockedURI, ""))
+	}
+	if err := SaveReportToEntries(ctx, d, rt); err != nil {
+		t.Fatalf("SaveReportToEntries: %v", err)
+	}
+
+	sr := []*SecurityReportEntry{
+		{
+			CreatedAt:          searchBase,
+			Service:            service,
+			ReportType:         "csp-violation",
+			URL:                "https://example.com/a",
+			EffectiveDirective: "img-src",
+			BlockedURI:         "data",
+			Agent:              Agent{UserAgent: safariUA},
+			RawJSON:            "{}",
+		},
+		{
+			CreatedAt:  searchBase.Add(-2 * time.Hour),
+			Service:    service,
+			ReportType: "deprecation",
+			URL:        "https://example.com/b",
+			SourceFile: "https://example.com/app.js",
+			Agent:      Agent{UserAgent: chromeUA},
+			RawJSON:    "{}",
+		},
+		{
+			CreatedAt:         searchBase.Add(-3 * time.Hour),
+			Service:           service,
+			ReportType:        "csp-violation",
+			URL:               "https://example.com/c",
+			ViolatedDirective: "script-src-elem",
+			BlockedURI:        "https://cdn.evil.com/y.js",
+			Agent:             Agent{UserAgent: firefoxUA},
+			RawJSON:           "{}",
+		},
+	}
+	for _, e := range sr {
+		e.setIssue(namedIssueKey(e.ReportType, e.BlockedURI, ""))
+	}
+	if err := SaveSecurityReportEntries(ctx, d, sr); err != nil {
+		t.Fatalf("SaveSecurityReportEntries: %v", err)
+	}
+}
+
+func assertSearch(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+
+	// key identifies a result by source and document URL, which together
+	// are unique among the fixtures.
+	key := func(r ReportSearchResult) string { return r.Source + " " + r.DocumentURL }
+	search := func(name string, f ReportSearch, want ...string) {
+		t.Helper()
+		page, err := SearchReports(ctx, d, service, f)
+		if err != nil {
+			t.Fatalf("SearchReports(%s) error = %v", name, err)
+		}
+		got := make([]string, 0, len(page.Reports))
+		for _, r := range page.Reports {
+			got = append(got, key(r))
+		}
+		if len(got) != len(want) {
+			t.Errorf("SearchReports(%s) = %q, want %q", name, got, want)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("SearchReports(%s) = %q, want %q", name, got, want)
+				return
+			}
+		}
+	}
+
+	search("all", ReportSearch{},
+		"report-to https://example.com/a",
+		"reporting https://example.com/a",
+		"report-to https://example.com/b",
+		"reporting https://example.com/b",
+		"reporting https://example.com/c")
+	search("type", ReportSearch{ReportType: "deprecation"},
+		"report-to https://example.com/b",
+		"reporting https://example.com/b")
+	search("directive", ReportSearch{Directive: "script-src-elem"},
+		"report-to https://example.com/a",
+		"reporting https://example.com/c")
+	search("effective directive", ReportSearch{Directive: "img-src"},
+		"reporting https://example.com/a")
+	search("blocked host", ReportSearch{BlockedHost: "CDN.evil.com"},
+		"report-to https://example.com/a",
+		"reporting https://example.com/c")
+	search("document url", ReportSearch{DocumentURL: "https://example.com/b"},
+		"report-to https://example.com/b",
+		"reporting https://example.com/b")
+	search("source file", ReportSearch{SourceFile: "https://example.com/app.js"},
+		"report-to https://example.com/a",
+		"reporting https://example.com/b")
+	search("browser family", ReportSearch{BrowserFamily: "Firefox"},
+		"report-to https://example.com/b",
+		"reporting https://example.com/c")
+	search("time range", ReportSearch{From: searchBase.Add(-2 * time.Hour), To: searchBase},
+		"report-to https://example.com/b",
+		"reporting https://example.com/b")
+	search("combined", ReportSearch{ReportType: "csp-violation", BrowserFamily: "chrome"},
+		"report-to https://example.com/a")
+	search("no match", ReportSearch{DocumentURL: "https://example.com/a", ReportType: "nel"})
+
+	// Paging one at a time visits every row once, in the same order.
+	var paged []string
+	f := ReportSearch{Limit: 1}
+	for range 10 {
+		page, err := SearchReports(ctx, d, service, f)
+		if err != nil {
+			t.Fatalf("SearchReports(page %d) error = %v", len(paged), err)
+		}
+		for _, r := range page.Reports {
+			paged = append(paged, key(r))
+		}
+		if page.Next == "" {
+			break
+		}
+		f.Cursor = page.Next
+	}
+	want := []string{
+		"report-to https://example.com/a",
+		"reporting https://example.com/a",
+		"report-to https://example.com/b",
+		"reporting https://example.com/b",
+		"reporting https://example.com/c",
+	}
+	if len(paged) != len(want) {
+		t.Fatalf("paged = %q, want %q", paged, want)
+	}
+	for i := range want {
+		if paged[i] != want[i] {
+			t.Errorf("paged[%d] = %q, want %q", i, paged[i], want[i])
+		}
+	}
+
+	page, err := SearchReports(ctx, d, service, ReportSearch{Limit: 2, ReportType: "csp-violation"})
+	if err != nil {
+		t.Fatalf("SearchReports(csp, limit 2) error = %v", err)
+	}
+	if len(page.Reports) != 2 || page.Next == "" {
+		t.Fatalf("SearchReports(csp, limit 2) = %+v, want a full page and a cursor", page)
+	}
+	r := page.Reports[0]
+	if r.BlockedURI != "https://CDN.Evil.com/x.js" || r.Directive != "script-src-elem" || r.BrowserFamily != "chrome" || r.UserAgent != chromeUA || r.Fingerprint == "" {
+		t.Errorf("SearchReports(csp)[0] = %+v", r)
+	}
+	if r := page.Reports[1]; r.Directive != "img-src" || r.BrowserFamily != "safari" {
+		t.Errorf("SearchReports(csp)[1] = %+v", r)
+	}
+	page, err = SearchReports(ctx, d, service, ReportSearch{Limit: 2, ReportType: "csp-violation", Cursor: page.Next})
+	if err != nil || len(page.Reports) != 1 || page.Next != "" {
+		t.Errorf("SearchReports(csp, page 2) = %+v, %v; want the last row and no cursor", page, err)
+	}
+
+	for _, cursor := range []string{"not base64!", "e30", searchCursor{Source: "other"}.encode()} {
+		if _, err := SearchReports(ctx, d, service, ReportSearch{Cursor: cursor}); err != ErrInvalidCursor {
+			t.Errorf("SearchReports(cursor %q) error = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/icco/reportd/pkg/noise"
+)
+
+// Errors returned by the service registry functions.
+var (
+	ErrServiceNotFound = errors.New("service not found")
+	ErrServiceExists   = errors.New("service already registered")
+)
+
+// Service is a registered service: the name its reports are posted
+// under, who owns it, and where its reports may come from. Reports reach
+// unregistered services too unless the server requires registration.
+type Service struct {
+	// Name is the {service} in ingest and API URLs. It cannot change,
+	// since stored rows refer to it.
+	Name        string `gorm:"primaryKey" json:"name"`
+	DisplayName string `json:"display_name"`
+	Owner       string `json:"owner"`
+	// AllowedOrigins are origins or hostnames as normalized by
+	// lib.NormalizeOrigin.
+	AllowedOrigins StringList `gorm:"type:text" json:"allowed_origins"`
+	// SampleRates maps event types to the fraction of those events
+	// ingest keeps; see ingest.Sampler.
+	SampleRates SampleRates `gorm:"type:text" json:"sample_rates"`
+	// NoiseRules run before the built-in noise rules.
+	NoiseRules NoiseRules `gorm:"type:text" json:"noise_rules"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	// ArchivedAt hides the service from listings and, when registration
+	// is required, stops it accepting reports.
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+}
+
+// StringList is a []string stored as a JSON array in a text column.
+type StringList []string
+
+// MarshalJSON encodes a nil list as [] rather than null.
+func (l StringList) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]string(l))
+}
+
+// Value stores l as a JSON array.
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		l = StringList{}
+	}
+	b, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan accepts nil, or a JSON array as []byte or string.
+func (l *StringList) Scan(v any) error {
+	switch x := v.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(x, l)
+	case string:
+		return json.Unmarshal([]byte(x), l)
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", v)
+	}
+}
+
+// SampleRates is a map[string]float64 stored as a JSON object in a text
+// column.
+type SampleRates map[string]float64
+
+// MarshalJSON encodes a nil map as {} rather than null.
+func (m SampleRates) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]float64(m))
+}
+
+// Value stores m as a JSON object.
+func (m SampleRates) Value() (driver.Value, error) {
+	if m == nil {
+		m = SampleRates{}
+	}
+	b, err := json.Marshal(map[string]float64(m))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan accepts nil, or a JSON object as []byte or string.
+func (m *SampleRates) Scan(v any) error {
+	switch x := v.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(x, m)
+	case string:
+		return json.Unmarshal([]byte(x), m)
+	default:
+		return fmt.Errorf("cannot scan %T into SampleRates", v)
+	}
+}
+
+// NoiseRules is a []noise.Rule stored as a JSON array in a text column.
+type NoiseRules []noise.Rule
+
+// MarshalJSON encodes a nil list as [] rather than null.
+func (l NoiseRules) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]noise.Rule(l))
+}
+
+// Value stores l as a JSON array.
+func (l NoiseRules) Value() (driver.Value, error) {
+	if l == nil {
+		l = NoiseRules{}
+	}
+	b, err := json.Marshal([]noise.Rule(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan accepts nil, or a JSON array as []byte or string.
+func (l *NoiseRules) Scan(v any) error {
+	switch x := v.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(x, l)
+	case string:
+		return json.Unmarshal([]byte(x), l)
+	default:
+		return fmt.Errorf("cannot scan %T into NoiseRules", v)
+	}
+}
+
+// CreateService registers s, returning ErrServiceExists if its name is
+// taken, archived or not.
+func CreateService(ctx context.Context, d *gorm.DB, s *Service) error {
+	res := d.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(s)
+	if res.Error != nil {
+		return fmt.Errorf("creating service: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrServiceExists
+	}
+	return nil
+}
+
+// GetService returns the registered service called name, or
+// ErrServiceNotFound.
+func GetService(ctx context.Context, d *gorm.DB, name string) (*Service, error) {
+	var s Service
+	err := d.WithContext(ctx).Where("name = ?", name).Take(&s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrServiceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying service: %w", err)
+	}
+	return &s, nil
+}
+
+// ListServices returns every registered service, archived ones included,
+// ordered by name.
+func ListServices(ctx context.Context, d *gorm.DB) ([]Service, error) {
+	var services []Service
+	if err := d.WithContext(ctx).Order("name").Find(&services).Error; err != nil {
+		return nil, fmt.Errorf("querying services: %w", err)
+	}
+	return services, nil
+}
+
+// ServiceUpdate holds the fields UpdateService changes; nil fields are
+// left alone.
+type ServiceUpdate struct {
+	DisplayName    *string
+	Owner          *string
+	AllowedOrigins *[]string
+	SampleRates    *map[string]float64
+	NoiseRules     *[]noise.Rule
+}
+
+// UpdateService applies u to the service called name and returns it as
+// stored.
+func UpdateService(ctx context.Context, d *gorm.DB, name string, u ServiceUpdate) (*Service, error) {
+	return updateService(ctx, d, name, func() map[string]any {
+		change := map[string]any{}
+		if u.DisplayName != nil {
+			change["display_name"] = *u.DisplayName
+		}
+		if u.Owner != nil {
+			change["owner"] = *u.Owner
+		}
+		if u.AllowedOrigins != nil {
+			change["allowed_origins"] = StringList(*u.AllowedOrigins)
+		}
+		if u.SampleRates != nil {
+			change["sample_rates"] = SampleRates(*u.SampleRates)
+		}
+		if u.NoiseRules != nil {
+			change["noise_rules"] = NoiseRules(*u.NoiseRules)
+		}
+		return change
+	})
+}
+
+// ArchiveService archives the service called name. Archiving an archived
+// service keeps its original ArchivedAt.
+func ArchiveService(ctx context.Context, d *gorm.DB, name string) (*Service, error) {
+	now := time.Now().UTC()
+	return updateService(ctx, d, name, func() map[string]any {
+		return map[string]any{"archived_at": gorm.Expr("COALESCE(archived_at, ?)", now)}
+	})
+}
+
+// RestoreService undoes ArchiveService.
+func RestoreService(ctx context.Context, d *gorm.DB, name string) (*Service, error) {
+	return updateService(ctx, d, name, func() map[string]any {
+		return map[string]any{"archived_at": nil}
+	})
+}
+
+// updateService applies the columns returned by change to one service
+// and returns it as stored.
+func updateService(ctx context.Context, d *gorm.DB, name string, change func() map[string]any) (*Service, error) {
+	var s Service
+	err := d.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("name = ?", name).Take(&s).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrServiceNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("querying service: %w", err)
+		}
+		if cols := change(); len(cols) > 0 {
+			if err := tx.Model(&s).Updates(cols).Error; err != nil {
+				return fmt.Errorf("updating service: %w", err)
+			}
+		}
+		return tx.Where("name = ?", name).Take(&s).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ServiceCache keeps the services table in memory for the ingest path,
+// reloading it at most once per TTL. Changes made by this process should
+// be followed by Invalidate; other replicas see them within the TTL.
+type ServiceCache struct {
+	d   *gorm.DB
+	ttl time.Duration
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	services map[string]*Service
+	// reload is set while a caller reloads the table, and closed when it
+	// is done.
+	reload chan struct{}
+	// gen counts Invalidate calls, so a reload that raced one is not
+	// trusted.
+	gen uint64
+}
+
+// NewServiceCache returns a cache of d's services table.
+func NewServiceCache(d *gorm.DB, ttl time.Duration) *ServiceCache {
+	return &ServiceCache{d: d, ttl: ttl}
+}
+
+// Lookup returns the registered service called name, archived or not, or
+// ErrServiceNotFound. The result is shared and must not be modified. If a
+// reload fails the previous copy is served until the next attempt, and
+// the error is returned only when there is no copy yet.
+func (c *ServiceCache) Lookup(ctx context.Context, name string) (*Service, error) {
+	services, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := services[name]
+	if !ok {
+		return nil, ErrServiceNotFound
+	}
+	return s, nil
+}
+
+// current returns the cached services, reloading them once they are
+// stale. One caller at a time reloads, without holding the lock; the
+// others are served the stale copy meanwhile, or wait if there is none.
+func (c *ServiceCache) current(ctx context.Context) (map[string]*Service, error) {
+	for {
+		c.mu.Lock()
+		if c.services != nil && time.Since(c.loadedAt) < c.ttl {
+			services := c.services
+			c.mu.Unlock()
+			return services, nil
+		}
+		if wait := c.reload; wait != nil {
+			services := c.services
+			c.mu.Unlock()
+			if services != nil {
+				return services, nil
+			}
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		done, gen := make(chan struct{}), c.gen
+		c.reload = done
+		c.mu.Unlock()
+
+		list, err := ListServices(ctx, c.d)
+
+		c.mu.Lock()
+		c.reload = nil
+		close(done)
+		// After an Invalidate the list may predate the change, so go
+		// round again.
+		if c.gen == gen {
+			switch {
+			case err == nil:
+				c.services = make(map[string]*Service, len(list))
+				for i := range list {
+					c.services[list[i].Name] = &list[i]
+				}
+				c.loadedAt = time.Now()
+			case c.services == nil:
+				c.mu.Unlock()
+				return nil, err
+			default:
+				c.loadedAt = time.Now()
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Invalidate makes the next Lookup reload the table.
+func (c *ServiceCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.services = nil
+	c.gen++
+}
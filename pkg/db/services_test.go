@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// seedServiceFixtures stores a Web Vital for service without registering
+// it, as any report to an unregistered service would.
+func seedServiceFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	wv := &WebVital{CreatedAt: time.Now(), Service: service, Name: "LCP", Value: 100}
+	if err := d.Create(wv).Error; err != nil {
+		t.Fatalf("seed web vital: %v", err)
+	}
+}
+
+func assertServiceRegistry(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+
+	listed := func(registeredOnly bool) bool {
+		t.Helper()
+		services, err := GetServices(ctx, d, registeredOnly)
+		if err != nil {
+			t.Fatalf("GetServices(%v) error = %v", registeredOnly, err)
+		}
+		return slices.Contains(services, service)
+	}
+	if !listed(false) || listed(true) {
+		t.Errorf("unregistered service: listed = %v, registered-only = %v; want true, false", listed(false), listed(true))
+	}
+
+	cache := NewServiceCache(d, time.Hour)
+	if _, err := cache.Lookup(ctx, service); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("Lookup before create error = %v, want ErrServiceNotFound", err)
+	}
+
+	s := &Service{Name: service, DisplayName: "Blog", Owner: "web-team", AllowedOrigins: StringList{"https://example.com"}}
+	if err := CreateService(ctx, d, s); err != nil {
+		t.Fatalf("CreateService() error = %v", err)
+	}
+	if err := CreateService(ctx, d, &Service{Name: service}); !errors.Is(err, ErrServiceExists) {
+		t.Errorf("duplicate CreateService() error = %v, want ErrServiceExists", err)
+	}
+	if !listed(true) {
+		t.Error("registered service missing from registered-only listing")
+	}
+
+	// The cache holds its miss until invalidated.
+	if _, err := cache.Lookup(ctx, service); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("Lookup before Invalidate error = %v, want ErrServiceNotFound", err)
+	}
+	cache.Invalidate()
+	if got, err := cache.Lookup(ctx, service); err != nil || got.DisplayName != "Blog" {
+		t.Errorf("Lookup() = %+v, %v; want the Blog service", got, err)
+	}
+
+	name, origins := "Engineering blog", []string{"https://example.com", "*.example.org"}
+	got, err := UpdateService(ctx, d, service, ServiceUpdate{DisplayName: &name, AllowedOrigins: &origins})
+	if err != nil {
+		t.Fatalf("UpdateService() error = %v", err)
+	}
+	if got.DisplayName != name || got.Owner != "web-team" || !slices.Equal(got.AllowedOrigins, origins) {
+		t.Errorf("UpdateService() = %+v, want renamed with both origins and the owner kept", got)
+	}
+	if _, err := UpdateService(ctx, d, service+"-missing", ServiceUpdate{DisplayName: &name}); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("UpdateService(missing) error = %v, want ErrServiceNotFound", err)
+	}
+
+	archived, err := ArchiveService(ctx, d, service)
+	if err != nil || archived.ArchivedAt == nil {
+		t.Fatalf("ArchiveService() = %+v, %v; want archived", archived, err)
+	}
+	again, err := ArchiveService(ctx, d, service)
+	if err != nil || again.ArchivedAt == nil || !again.ArchivedAt.Equal(*archived.ArchivedAt) {
+		t.Errorf("second ArchiveService() = %+v, %v; want the first archive time kept", again, err)
+	}
+	if listed(false) || listed(true) {
+		t.Error("archived service still listed")
+	}
+
+	restored, err := RestoreService(ctx, d, service)
+	if err != nil || restored.ArchivedAt != nil {
+		t.Errorf("RestoreService() = %+v, %v; want unarchived", restored, err)
+	}
+	if !listed(true) {
+		t.Error("restored service missing from listing")
+	}
+}
+
+// TestServiceCacheServesStaleDuringReload checks that lookups do not
+// wait on a slow reload when there is a copy to serve.
+func TestServiceCacheServesStaleDuringReload(t *testing.T) {
+	ctx := context.Background()
+	d, err := Connect(ctx, "sqlite://"+filepath.Join(t.TempDir(), "reportd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateService(ctx, d, &Service{Name: "blog", DisplayName: "Blog"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var block atomic.Bool
+	entered, release := make(chan struct{}), make(chan struct{})
+	err = d.Callback().Query().Before("gorm:query").Register("test:block", func(*gorm.DB) {
+		if block.Load() {
+			entered <- struct{}{}
+			<-release
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewServiceCache(d, time.Millisecond)
+	if _, err := cache.Lookup(ctx, "blog"); err != nil {
+		t.Fatalf("first Lookup() error = %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	block.Store(true)
+	reloaded := make(chan error)
+	go func() {
+		_, err := cache.Lookup(ctx, "blog")
+		reloaded <- err
+	}()
+	<-entered
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if s, err := cache.Lookup(waitCtx, "blog"); err != nil || s.DisplayName != "Blog" {
+		t.Errorf("Lookup() during reload = %+v, %v; want the stale copy at once", s, err)
+	}
+
+	block.Store(false)
+	close(release)
+	if err := <-reloaded; err != nil {
+		t.Errorf("reloading Lookup() error = %v", err)
+	}
+}
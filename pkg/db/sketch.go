@@ -0,0 +1,107 @@
+package db
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+)
+
+// sketchAccuracy is the relative error of quantiles read from a
+// valueSketch: p75 of a 2500ms LCP comes back within 25ms.
+const sketchAccuracy = 0.01
+
+// sketchMinValue is the smallest value given its own bucket. Anything
+// at or below it, including a CLS of 0, counts as zero.
+const sketchMinValue = 1e-9
+
+var sketchLogGamma = math.Log((1 + sketchAccuracy) / (1 - sketchAccuracy))
+
+// valueSketch is a mergeable summary of a value distribution in the
+// style of DDSketch: values are counted in logarithmically sized buckets,
+// so any quantile can be estimated to within sketchAccuracy of the true
+// value from a few hundred counters, and sketches for several days can be
+// added together. Counters are weights rather than row counts, so a row
+// kept at sample rate r can stand for the 1/r samples it represents.
+type valueSketch struct {
+	Zero float64         `json:"z,omitempty"`
+	Bins map[int]float64 `json:"b,omitempty"`
+}
+
+// add counts v w times.
+func (s *valueSketch) add(v, w float64) {
+	if v <= sketchMinValue {
+		s.Zero += w
+		return
+	}
+	if s.Bins == nil {
+		s.Bins = map[int]float64{}
+	}
+	s.Bins[int(math.Ceil(math.Log(v)/sketchLogGamma))] += w
+}
+
+func (s *valueSketch) merge(o valueSketch) {
+	s.Zero += o.Zero
+	for i, n := range o.Bins {
+		if s.Bins == nil {
+			s.Bins = map[int]float64{}
+		}
+		s.Bins[i] += n
+	}
+}
+
+func (s *valueSketch) count() float64 {
+	n := s.Zero
+	for _, c := range s.Bins {
+		n += c
+	}
+	return n
+}
+
+// quantile estimates p, interpolating between neighbouring ranks like
+// percentile_cont.
+func (s *valueSketch) quantile(p Percentile) float64 {
+	n := s.count()
+	if n == 0 {
+		return 0
+	}
+	idx := make([]int, 0, len(s.Bins))
+	for i := range s.Bins {
+		idx = append(idx, i)
+	}
+	slices.Sort(idx)
+
+	// valueAt returns the estimate of the rank'th smallest value.
+	valueAt := func(rank float64) float64 {
+		if rank < s.Zero {
+			return 0
+		}
+		seen := s.Zero
+		for _, i := range idx {
+			seen += s.Bins[i]
+			if rank < seen {
+				// The midpoint, in relative terms, of (gamma^(i-1), gamma^i].
+				return 2 * math.Exp(float64(i)*sketchLogGamma) / (1 + math.Exp(sketchLogGamma))
+			}
+		}
+		return 0
+	}
+
+	pos := p.fraction() * (n - 1)
+	lo, hi := math.Floor(pos), math.Ceil(pos)
+	vlo, vhi := valueAt(lo), valueAt(hi)
+	return vlo + (vhi-vlo)*(pos-lo)
+}
+
+func (s *valueSketch) encode() string {
+	raw, _ := json.Marshal(s)
+	return string(raw)
+}
+
+func decodeSketch(raw string) (valueSketch, error) {
+	var s valueSketch
+	if raw == "" {
+		return s, nil
+	}
+	err := json.Unmarshal([]byte(raw), &s)
+	return s, err
+}
@@ -12,11 +12,67 @@ func TestConnectSQLiteAndQueryHelpers(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Connect() error = %v", err)
 	}
-	if err := AutoMigrate(ctx, d); err != nil {
-		t.Fatalf("AutoMigrate() error = %v", err)
+	if _, err := Migrate(ctx, d); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
 	}
+	assertMigrations(ctx, t, d)
 
 	const service = "svc"
 	seedQueryFixtures(t, d, service)
 	assertQueryHelpers(ctx, t, d, service)
+
+	const nelService = "nel-svc"
+	seedNELFixtures(t, d, nelService)
+	assertNELSummary(ctx, t, d, nelService)
+
+	const vitalsService = "vitals-svc"
+	seedVitalBreakdownFixtures(t, d, vitalsService)
+	assertVitalBreakdowns(ctx, t, d, vitalsService)
+
+	const issueService = "issue-svc"
+	seedIssueFixtures(t, d, issueService)
+	assertIssues(ctx, t, d, issueService)
+
+	assertIssueTriage(ctx, t, d, "triage-svc")
+
+	const retentionService = "retention-svc"
+	seedRetentionFixtures(t, d, retentionService)
+	assertPurge(ctx, t, d, retentionService)
+
+	const timeRangeService = "timerange-svc"
+	seedTimeRangeFixtures(t, d, timeRangeService)
+	assertTimeRange(ctx, t, d, timeRangeService)
+
+	const searchService = "search-svc"
+	seedSearchFixtures(t, d, searchService)
+	assertSearch(ctx, t, d, searchService)
+
+	const exportService = "export-svc"
+	seedExportFixtures(t, d, exportService)
+	assertExport(ctx, t, d, exportService)
+
+	const agentService = "agent-svc"
+	seedAgentFixtures(t, d, agentService)
+	assertAgentBreakdowns(ctx, t, d, agentService)
+
+	const occurredService = "occurred-svc"
+	seedOccurredFixtures(t, d, occurredService)
+	assertOccurredCounts(ctx, t, d, occurredService)
+
+	const registryService = "registry-svc"
+	seedServiceFixtures(t, d, registryService)
+	assertServiceRegistry(ctx, t, d, registryService)
+
+	const samplingService = "sampling-svc"
+	seedSamplingFixtures(t, d, samplingService)
+	assertSampledCounts(ctx, t, d, samplingService)
+
+	const weightedService = "weighted-svc"
+	seedWeightedPercentileFixtures(t, d, weightedService)
+	assertWeightedPercentiles(ctx, t, d, weightedService)
+
+	// Last, since once rolled up the other helpers read estimates.
+	const rollupService = "rollup-svc"
+	seedRollupFixtures(t, d, rollupService)
+	assertRollups(ctx, t, d, rollupService)
 }
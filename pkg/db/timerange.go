@@ -0,0 +1,275 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Interval is the width of the buckets a time series is grouped into.
+type Interval string
+
+// Supported intervals.
+const (
+	IntervalHour Interval = "hour"
+	IntervalDay  Interval = "day"
+	IntervalWeek Interval = "week"
+)
+
+// DefaultInterval is the bucket width used when none is given.
+const DefaultInterval = IntervalDay
+
+// ParseInterval accepts "hour", "day" or "week"; empty means
+// DefaultInterval.
+func ParseInterval(s string) (Interval, error) {
+	switch i := Interval(strings.ToLower(strings.TrimSpace(s))); i {
+	case "":
+		return DefaultInterval, nil
+	case IntervalHour, IntervalDay, IntervalWeek:
+		return i, nil
+	default:
+		return "", fmt.Errorf("unsupported interval %q: want hour, day or week", s)
+	}
+}
+
+// MaxSpan is the longest range a query may cover at i, which keeps a
+// series to at most a few hundred buckets.
+func (i Interval) MaxSpan() time.Duration {
+	const day = 24 * time.Hour
+	switch i {
+	case IntervalHour:
+		return 31 * day
+	case IntervalWeek:
+		return 3 * 366 * day
+	default:
+		return 366 * day
+	}
+}
+
+// TimeRange selects the rows a query reads, [From, To), and how a series
+// over them is bucketed. Buckets start on hour, day or Monday boundaries
+// in Location. The zero TimeRange is each query's default window ending
+// now, in daily UTC buckets.
+type TimeRange struct {
+	From     time.Time
+	To       time.Time
+	Interval Interval
+	Location *time.Location
+}
+
+// ParseTimeRange builds a TimeRange from the from, to, interval and tz
+// query parameters, as ParseBounds reads them. An explicit range may not
+// exceed the interval's MaxSpan.
+func ParseTimeRange(from, to, interval, tz string, now time.Time) (TimeRange, error) {
+	var r TimeRange
+	var err error
+	if r.Interval, err = ParseInterval(interval); err != nil {
+		return r, err
+	}
+	if r.From, r.To, r.Location, err = ParseBounds(from, to, tz, now); err != nil {
+		return r, err
+	}
+	if !r.From.IsZero() && r.To.Sub(r.From) > r.Interval.MaxSpan() {
+		return r, fmt.Errorf("range is longer than the %d days allowed for interval=%s", int(r.Interval.MaxSpan().Hours()/24), r.Interval)
+	}
+	return r, nil
+}
+
+// ParseBounds reads the from, to and tz query parameters of a range that
+// is not bucketed, so may be any length. from and to are RFC 3339
+// timestamps or dates, read in tz (an IANA zone name, UTC if empty); a
+// date for to includes that whole day. to defaults to now and from to the
+// zero time, which callers read as their own window.
+func ParseBounds(from, to, tz string, now time.Time) (start, end time.Time, loc *time.Location, err error) {
+	loc = time.UTC
+	if tz = strings.TrimSpace(tz); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return start, end, nil, fmt.Errorf("unknown time zone %q", tz)
+		}
+	}
+
+	end = now
+	if to != "" {
+		if end, err = parseRangeTime(to, loc, true); err != nil {
+			return start, end, loc, fmt.Errorf("to: %w", err)
+		}
+	}
+	if from != "" {
+		if start, err = parseRangeTime(from, loc, false); err != nil {
+			return start, end, loc, fmt.Errorf("from: %w", err)
+		}
+		if !start.Before(end) {
+			return start, end, loc, fmt.Errorf("from must be before to")
+		}
+	}
+	return start, end, loc, nil
+}
+
+// parseRangeTime parses an RFC 3339 timestamp or a date in loc. A date
+// means the start of that day, or with end set the start of the next.
+func parseRangeTime(s string, loc *time.Location, end bool) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.ParseInLocation(time.DateOnly, s, loc); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("%q is not a date or RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+// window fills in what r leaves unset: To is now, From is years, months
+// and days before To (but no more than the interval's MaxSpan), Interval
+// is DefaultInterval and Location is UTC. From and To are returned in UTC,
+// the form SQLite stores created_at in; see openSQLite.
+func (r TimeRange) window(years, months, days int) TimeRange {
+	if r.To.IsZero() {
+		r.To = time.Now()
+	}
+	if r.Interval == "" {
+		r.Interval = DefaultInterval
+	}
+	if r.Location == nil {
+		r.Location = time.UTC
+	}
+	if r.From.IsZero() {
+		r.From = r.To.AddDate(years, months, days)
+		if earliest := r.To.Add(-r.Interval.MaxSpan()); r.From.Before(earliest) {
+			r.From = earliest
+		}
+	}
+	r.From, r.To = r.From.UTC(), r.To.UTC()
+	return r
+}
+
+// zoneChange is the UTC offset, in seconds, in effect before a time zone
+// transition at unix time at.
+type zoneChange struct {
+	at     int64
+	offset int
+}
+
+// offsets returns r.Location's UTC offset at the end of the range and
+// each transition within it, so a bucket expression can shift rows from
+// both sides of a daylight saving change to local time.
+func (r TimeRange) offsets() (last int, changes []zoneChange) {
+	t := r.From.In(r.Location)
+	_, last = t.Zone()
+	for {
+		_, end := t.ZoneBounds()
+		if end.IsZero() || !end.Before(r.To) {
+			return last, changes
+		}
+		changes = append(changes, zoneChange{at: end.Unix(), offset: last})
+		t = end.In(r.Location)
+		_, last = t.Zone()
+	}
+}
+
+// utc reports whether r's buckets line up with UTC days, so they can be
+// read from the daily rollup tables.
+func (r TimeRange) utc() bool {
+	last, changes := r.offsets()
+	return last == 0 && len(changes) == 0
+}
+
+// bucketLayout is how bucketExpr renders a bucket's local start time.
+const bucketLayout = time.DateTime
+
+// bucketExpr returns SQL evaluating to the local wall-clock start of the
+// bucket holding each row's column, a timestamp such as created_at,
+// formatted as bucketLayout. Only column and integers from r are
+// interpolated, so the result is safe to use in GROUP BY without
+// arguments. An hour repeated when clocks go back is a single bucket.
+func (r TimeRange) bucketExpr(d *gorm.DB, column string) string {
+	sqlite := d.Dialector.Name() == dialectSQLite
+	last, changes := r.offsets()
+	offset := fmt.Sprint(last)
+	if len(changes) > 0 {
+		var b strings.Builder
+		b.WriteString("CASE")
+		for _, c := range changes {
+			if sqlite {
+				fmt.Fprintf(&b, " WHEN CAST(strftime('%%s', %s) AS INTEGER) < %d THEN %d", column, c.at, c.offset)
+			} else {
+				fmt.Fprintf(&b, " WHEN %s < to_timestamp(%d) THEN %d", column, c.at, c.offset)
+			}
+		}
+		fmt.Fprintf(&b, " ELSE %d END", last)
+		offset = b.String()
+	}
+
+	if sqlite {
+		shift := column + ", (" + offset + ") || ' seconds'"
+		switch r.Interval {
+		case IntervalHour:
+			return "strftime('%Y-%m-%d %H:00:00', " + shift + ")"
+		case IntervalWeek:
+			return "strftime('%Y-%m-%d 00:00:00', " + shift + ", '-6 days', 'weekday 1')"
+		default:
+			return "strftime('%Y-%m-%d 00:00:00', " + shift + ")"
+		}
+	}
+	local := "(" + column + " AT TIME ZONE 'UTC') + (" + offset + ") * interval '1 second'"
+	return "to_char(date_trunc('" + string(r.Interval) + "', " + local + "), 'YYYY-MM-DD HH24:MI:SS')"
+}
+
+// parseBucket converts a bucketExpr value back to the instant the bucket
+// starts.
+func (r TimeRange) parseBucket(s string) (time.Time, error) {
+	t, err := time.ParseInLocation(bucketLayout, s, r.Location)
+	if err != nil {
+		return t, fmt.Errorf("parsing bucket %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// rollupDays returns the UTC days [start, end) making up the whole
+// r.Interval buckets that lie inside r and within the rolled-up days
+// since through through, or start == end if r cannot be served from
+// rollups. Only daily and weekly UTC buckets can be.
+func (r TimeRange) rollupDays(since, through time.Time) (start, end time.Time) {
+	if through.IsZero() || (r.Interval != IntervalDay && r.Interval != IntervalWeek) || !r.utc() {
+		return time.Time{}, time.Time{}
+	}
+	step := 1
+	if r.Interval == IntervalWeek {
+		step = 7
+	}
+	// ceil returns the start of the first bucket starting at or after t.
+	ceil := func(t time.Time) time.Time {
+		b := r.rollupBucket(t)
+		if b.Before(t) {
+			b = b.AddDate(0, 0, step)
+		}
+		return b
+	}
+	start = ceil(r.From)
+	if s := ceil(utcDay(since)); start.Before(s) {
+		start = s
+	}
+	end = r.rollupBucket(r.To)
+	if next := r.rollupBucket(utcDay(through).AddDate(0, 0, 1)); next.Before(end) {
+		end = next
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}
+	}
+	return start, end
+}
+
+// rollupBucket returns the start of the daily or weekly UTC bucket
+// holding t, weeks starting on Monday as in bucketExpr.
+func (r TimeRange) rollupBucket(t time.Time) time.Time {
+	day := utcDay(t)
+	if r.Interval == IntervalWeek {
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
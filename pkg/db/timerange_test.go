@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.Date(2025, 11, 5, 12, 0, 0, 0, time.UTC)
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ParseTimeRange("", "", "", "", now)
+	if err != nil || !r.From.IsZero() || !r.To.Equal(now) || r.Interval != IntervalDay || r.Location != time.UTC {
+		t.Errorf("ParseTimeRange() defaults = %+v, %v", r, err)
+	}
+
+	r, err = ParseTimeRange("2025-11-01", "2025-11-02", "hour", "America/New_York", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 11, 1, 0, 0, 0, 0, ny); !r.From.Equal(want) {
+		t.Errorf("From = %v, want %v", r.From, want)
+	}
+	// The end date is inclusive, and the day clocks go back is 25 hours.
+	if want := time.Date(2025, 11, 3, 0, 0, 0, 0, ny); !r.To.Equal(want) || r.To.Sub(r.From) != 49*time.Hour {
+		t.Errorf("To = %v, want %v", r.To, want)
+	}
+
+	r, err = ParseTimeRange("2025-11-01T10:00:00+02:00", "2025-11-01T12:00:00Z", "", "", now)
+	if err != nil || r.To.Sub(r.From) != 4*time.Hour {
+		t.Errorf("ParseTimeRange(RFC 3339) = %+v, %v", r, err)
+	}
+
+	for _, tc := range []struct{ from, to, interval, tz string }{
+		{"", "", "minute", ""},
+		{"", "", "", "Mars/Olympus_Mons"},
+		{"yesterday", "", "", ""},
+		{"", "2025-13-01", "", ""},
+		{"2025-11-03", "2025-11-01", "", ""},
+		{"2025-01-01", "2025-03-01", "hour", ""},
+		{"2023-01-01", "2025-01-02", "day", ""},
+	} {
+		if _, err := ParseTimeRange(tc.from, tc.to, tc.interval, tc.tz, now); err == nil {
+			t.Errorf("ParseTimeRange(%q, %q, %q, %q) expected error", tc.from, tc.to, tc.interval, tc.tz)
+		}
+	}
+}
+
+// timeRangeFixtures are rows either side of New York falling back from
+// EDT to EST at 06:00 UTC on 2025-11-02, which bucket differently in UTC
+// and in New York.
+var timeRangeFixtures = []time.Time{
+	time.Date(2025, 11, 1, 3, 30, 0, 0, time.UTC),  // Fri 31 Oct 23:30 EDT
+	time.Date(2025, 11, 1, 14, 0, 0, 0, time.UTC),  // Sat 1 Nov 10:00 EDT
+	time.Date(2025, 11, 2, 5, 30, 0, 0, time.UTC),  // Sun 2 Nov 01:30 EDT
+	time.Date(2025, 11, 2, 6, 30, 0, 0, time.UTC),  // Sun 2 Nov 01:30 EST
+	time.Date(2025, 11, 3, 4, 30, 0, 0, time.UTC),  // Sun 2 Nov 23:30 EST
+	time.Date(2025, 11, 3, 15, 0, 0, 0, time.UTC),  // Mon 3 Nov 10:00 EST
+	time.Date(2025, 11, 10, 15, 0, 0, 0, time.UTC), // outside every range
+}
+
+// seedTimeRangeFixtures stores a web vital, valued by its index, and a
+// deprecation report at each of timeRangeFixtures.
+func seedTimeRangeFixtures(t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	for i, at := range timeRangeFixtures {
+		if err := d.Create(&WebVital{CreatedAt: at, Service: service, Name: "LCP", Value: float64(i)}).Error; err != nil {
+			t.Fatalf("seed web vital: %v", err)
+		}
+		if err := d.Create(&SecurityReportEntry{CreatedAt: at, Service: service, ReportType: "deprecation", RawJSON: "{}"}).Error; err != nil {
+			t.Fatalf("seed security report: %v", err)
+		}
+	}
+}
+
+func assertTimeRange(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	now := time.Now()
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type bucket struct {
+		start time.Time
+		count int64
+	}
+	countsFor := func(from, to, interval, tz string) []bucket {
+		t.Helper()
+		r, err := ParseTimeRange(from, to, interval, tz, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts, err := GetReportCounts(ctx, d, service, r)
+		if err != nil {
+			t.Fatalf("GetReportCounts(%s, %s) error = %v", interval, tz, err)
+		}
+		out := make([]bucket, 0, len(counts))
+		for _, c := range counts {
+			out = append(out, bucket{c.Bucket, c.Count})
+		}
+		return out
+	}
+	check := func(name string, got, want []bucket) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Errorf("%s = %+v, want %+v", name, got, want)
+			return
+		}
+		for i := range want {
+			if !got[i].start.Equal(want[i].start) || got[i].count != want[i].count {
+				t.Errorf("%s[%d] = %+v, want %+v", name, i, got[i], want[i])
+			}
+		}
+	}
+
+	check("daily UTC", countsFor("2025-11-01", "2025-11-03", "", ""), []bucket{
+		{time.Date(2025, 11, 3, 0, 0, 0, 0, time.UTC), 2},
+		{time.Date(2025, 11, 2, 0, 0, 0, 0, time.UTC), 2},
+		{time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC), 2},
+	})
+	check("daily New York", countsFor("2025-10-31", "2025-11-03", "day", "America/New_York"), []bucket{
+		{time.Date(2025, 11, 3, 0, 0, 0, 0, ny), 1},
+		{time.Date(2025, 11, 2, 0, 0, 0, 0, ny), 3},
+		{time.Date(2025, 11, 1, 0, 0, 0, 0, ny), 1},
+		{time.Date(2025, 10, 31, 0, 0, 0, 0, ny), 1},
+	})
+	check("weekly New York", countsFor("2025-10-27", "2025-11-09", "week", "America/New_York"), []bucket{
+		{time.Date(2025, 11, 3, 0, 0, 0, 0, ny), 1},
+		{time.Date(2025, 10, 27, 0, 0, 0, 0, ny), 5},
+	})
+	// Both 01:30s fall in the one local 01:00 bucket.
+	hourly := countsFor("2025-11-02T05:00:00Z", "2025-11-02T08:00:00Z", "hour", "America/New_York")
+	if len(hourly) != 1 || hourly[0].count != 2 || hourly[0].start.In(ny).Hour() != 1 {
+		t.Errorf("hourly New York = %+v, want both 01:30s in the 01:00 bucket", hourly)
+	}
+
+	r, err := ParseTimeRange("2025-11-02", "2025-11-02", "day", "America/New_York", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summaries, err := GetWebVitalSummaries(ctx, d, service, P50, r)
+	if err != nil {
+		t.Fatalf("GetWebVitalSummaries() error = %v", err)
+	}
+	if len(summaries) != 1 || summaries[0].Value != 3 || time.Time(summaries[0].Day).Format(time.DateOnly) != "2025-11-02" {
+		t.Errorf("GetWebVitalSummaries(New York, 2 Nov) = %+v, want p50 3 of 2, 3 and 4", summaries)
+	}
+	pcts, err := GetWebVitalPercentiles(ctx, d, service, P50, r)
+	if err != nil || len(pcts) != 1 || pcts[0].Value != 3 {
+		t.Errorf("GetWebVitalPercentiles(New York, 2 Nov) = %+v, %v; want p50 3", pcts, err)
+	}
+}
+
+// setLocal runs the rest of t as if on a host in the time zone name.
+func setLocal(t *testing.T, name string) {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := time.Local
+	time.Local = loc
+	t.Cleanup(func() { time.Local = prev })
+}
+
+func TestTimeRangeOnNonUTCHost(t *testing.T) {
+	setLocal(t, "America/New_York")
+	ctx := context.Background()
+	d, err := Connect(ctx, "sqlite://"+filepath.Join(t.TempDir(), "reportd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(ctx, d); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if err := SaveSecurityReportEntries(ctx, d, []*SecurityReportEntry{{Service: "blog", ReportType: "deprecation", RawJSON: "{}"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	if err := d.Raw("SELECT CAST(created_at AS TEXT) FROM security_report_entries").Scan(&stored).Error; err != nil || !strings.HasSuffix(stored, "+00:00") {
+		t.Errorf("stored created_at = %q (err %v), want UTC", stored, err)
+	}
+
+	now := time.Now()
+	r, err := ParseTimeRange(now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Minute).Format(time.RFC3339), "hour", "Asia/Kolkata", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts, err := GetReportCounts(ctx, d, "blog", r)
+	if err != nil {
+		t.Fatalf("GetReportCounts() error = %v", err)
+	}
+	var total int64
+	for _, c := range counts {
+		total += c.Count
+	}
+	if total != 1 {
+		t.Errorf("GetReportCounts(last hour) = %+v, want the report just saved", counts)
+	}
+}
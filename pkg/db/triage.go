@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Issue triage states. New issues are open; resolved issues that see a
+// newer report become regressed, and ignored issues reopen once their
+// ignore runs out.
+const (
+	IssueOpen      = "open"
+	IssueResolved  = "resolved"
+	IssueIgnored   = "ignored"
+	IssueRegressed = "regressed"
+)
+
+// ErrIssueNotFound is returned by the triage functions when no issue has
+// the given service and fingerprint.
+var ErrIssueNotFound = errors.New("issue not found")
+
+// regressesSQL and ignoreEndsSQL are the upsert conditions, in terms of
+// the stored row (issues) and the incoming one (excluded), under which a
+// new report changes an issue's status.
+const (
+	regressesSQL  = "(issues.status = 'resolved' AND excluded.last_seen > issues.resolved_at)"
+	ignoreEndsSQL = "(issues.status = 'ignored' AND ((issues.ignored_until IS NOT NULL AND excluded.last_seen >= issues.ignored_until)" +
+		" OR (issues.ignore_until_count > 0 AND issues.count + excluded.count >= issues.ignore_until_count)))"
+)
+
+// triagedSQL matches rows of table whose issue has been resolved or
+// ignored, for hiding them from recent-report listings.
+func triagedSQL(table string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM issues WHERE issues.service = %[1]s.service"+
+		" AND issues.fingerprint = %[1]s.fingerprint AND issues.status IN ('resolved', 'ignored'))", table)
+}
+
+// ParseIssueStatuses turns a status query parameter into the statuses to
+// match. The default, "open", includes regressed issues since they need
+// attention too; "all" matches everything and returns nil.
+func ParseIssueStatuses(s string) ([]string, error) {
+	switch s {
+	case "", IssueOpen:
+		return []string{IssueOpen, IssueRegressed}, nil
+	case "all":
+		return nil, nil
+	case IssueResolved, IssueIgnored, IssueRegressed:
+		return []string{s}, nil
+	}
+	return nil, fmt.Errorf("unknown issue status %q, want one of open, regressed, resolved, ignored or all", s)
+}
+
+// IgnoreOptions bound an ignore. With both zero the issue stays ignored
+// until reopened by hand.
+type IgnoreOptions struct {
+	// Until reopens the issue once this time passes.
+	Until time.Time
+	// Events reopens the issue after this many more reports.
+	Events int64
+}
+
+// ResolveIssue marks an issue resolved. A report seen after now marks it
+// regressed.
+func ResolveIssue(ctx context.Context, d *gorm.DB, service, fp string) (*Issue, error) {
+	now := time.Now().UTC()
+	return updateIssue(ctx, d, service, fp, func(*Issue) map[string]any {
+		return map[string]any{
+			"status":             IssueResolved,
+			"resolved_at":        now,
+			"ignored_until":      nil,
+			"ignore_until_count": 0,
+		}
+	})
+}
+
+// IgnoreIssue marks an issue ignored, optionally until a time or a number
+// of further reports.
+func IgnoreIssue(ctx context.Context, d *gorm.DB, service, fp string, opts IgnoreOptions) (*Issue, error) {
+	var until *time.Time
+	if !opts.Until.IsZero() {
+		t := opts.Until.UTC()
+		until = &t
+	}
+	return updateIssue(ctx, d, service, fp, func(is *Issue) map[string]any {
+		var untilCount int64
+		if opts.Events > 0 {
+			untilCount = is.Count + opts.Events
+		}
+		return map[string]any{
+			"status":             IssueIgnored,
+			"ignored_until":      until,
+			"ignore_until_count": untilCount,
+		}
+	})
+}
+
+// ReopenIssue returns an issue to open, clearing any ignore.
+func ReopenIssue(ctx context.Context, d *gorm.DB, service, fp string) (*Issue, error) {
+	return updateIssue(ctx, d, service, fp, func(*Issue) map[string]any {
+		return map[string]any{
+			"status":             IssueOpen,
+			"ignored_until":      nil,
+			"ignore_until_count": 0,
+		}
+	})
+}
+
+// updateIssue applies the columns returned by change to one issue and
+// returns it as stored.
+func updateIssue(ctx context.Context, d *gorm.DB, service, fp string, change func(*Issue) map[string]any) (*Issue, error) {
+	var is Issue
+	err := d.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("service = ? AND fingerprint = ?", service, strings.ToLower(fp)).Take(&is).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrIssueNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("querying issue: %w", err)
+		}
+		if err := tx.Model(&is).Updates(change(&is)).Error; err != nil {
+			return fmt.Errorf("updating issue: %w", err)
+		}
+		return tx.Take(&is, is.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &is, nil
+}
+
+// reopenExpiredIgnores reopens service's ignored issues whose time limit
+// has passed without a new report arriving to do it.
+func reopenExpiredIgnores(ctx context.Context, d *gorm.DB, service string) error {
+	err := d.WithContext(ctx).Model(&Issue{}).
+		Where("service = ? AND status = ? AND ignored_until IS NOT NULL AND ignored_until <= ?", service, IssueIgnored, time.Now().UTC()).
+		Updates(map[string]any{"status": IssueOpen, "ignored_until": nil, "ignore_until_count": 0}).Error
+	if err != nil {
+		return fmt.Errorf("reopening expired ignores: %w", err)
+	}
+	return nil
+}
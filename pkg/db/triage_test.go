@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icco/reportd/pkg/reporting"
+	"gorm.io/gorm"
+)
+
+func TestParseIssueStatuses(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{IssueOpen, IssueRegressed}},
+		{"open", []string{IssueOpen, IssueRegressed}},
+		{"all", nil},
+		{"resolved", []string{IssueResolved}},
+		{"ignored", []string{IssueIgnored}},
+		{"regressed", []string{IssueRegressed}},
+	} {
+		got, err := ParseIssueStatuses(tc.in)
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("ParseIssueStatuses(%q) = %v, %v; want %v", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := ParseIssueStatuses("closed"); err == nil {
+		t.Error("ParseIssueStatuses(closed) expected error")
+	}
+}
+
+func TestIssueTriageOnNonUTCHost(t *testing.T) {
+	setLocal(t, "America/New_York")
+	ctx := context.Background()
+	d, err := Connect(ctx, "sqlite://"+filepath.Join(t.TempDir(), "reportd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(ctx, d); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	assertIssueTriage(ctx, t, d, "triage-svc")
+
+	var stored []string
+	if err := d.Raw("SELECT CAST(first_seen AS TEXT) FROM issues UNION ALL SELECT CAST(resolved_at AS TEXT) FROM issues WHERE resolved_at IS NOT NULL").Scan(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stored {
+		if !strings.HasSuffix(s, "+00:00") {
+			t.Errorf("stored issue time %q, want UTC", s)
+		}
+	}
+}
+
+// assertIssueTriage walks issues through each triage transition, storing
+// reports as the handlers do so the upsert applies regressions and
+// ignore limits.
+func assertIssueTriage(ctx context.Context, t *testing.T, d *gorm.DB, service string) {
+	t.Helper()
+	// Reports carry local times, as they do when the handlers stamp them.
+	now := time.Now().Truncate(time.Second)
+
+	report := func(id string, at time.Time) *SecurityReportEntry {
+		e := SecurityReportEntryFromReport(&reporting.SecurityReport{
+			Deprecation: &reporting.DeprecationReport{
+				Type: "deprecation",
+				Body: reporting.DeprecationReportBody{ID: nullStr(id)},
+			},
+			ReportType: nullStr("deprecation"),
+			RawJSON:    "{}",
+			Service:    nullStr(service),
+		})
+		e.CreatedAt = at
+		return e
+	}
+	save := func(id string, at time.Time) string {
+		t.Helper()
+		e := report(id, at)
+		if err := SaveSecurityReportEntries(ctx, d, []*SecurityReportEntry{e}); err != nil {
+			t.Fatalf("SaveSecurityReportEntries: %v", err)
+		}
+		return e.Fingerprint
+	}
+	status := func(fp string) Issue {
+		t.Helper()
+		var is Issue
+		if err := d.WithContext(ctx).Where("service = ? AND fingerprint = ?", service, fp).Take(&is).Error; err != nil {
+			t.Fatalf("loading issue %s: %v", fp, err)
+		}
+		return is
+	}
+
+	resolved := save("Resolved", now.Add(-time.Hour))
+	byCount := save("IgnoredByCount", now.Add(-time.Hour))
+	byTime := save("IgnoredByTime", now.Add(-time.Hour))
+	expired := save("IgnoredExpired", now.Add(-time.Hour))
+
+	if _, err := ResolveIssue(ctx, d, service, "0123456789abcdef0123456789abcdef"); !errors.Is(err, ErrIssueNotFound) {
+		t.Errorf("ResolveIssue(unknown) error = %v, want ErrIssueNotFound", err)
+	}
+
+	is, err := ResolveIssue(ctx, d, service, resolved)
+	if err != nil || is.Status != IssueResolved || is.ResolvedAt == nil {
+		t.Fatalf("ResolveIssue() = %+v, %v", is, err)
+	}
+	if is, err := IgnoreIssue(ctx, d, service, byCount, IgnoreOptions{Events: 2}); err != nil || is.IgnoreUntilCount != 3 {
+		t.Fatalf("IgnoreIssue(events) = %+v, %v; want ignored until count 3", is, err)
+	}
+	if _, err := IgnoreIssue(ctx, d, service, byTime, IgnoreOptions{Until: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("IgnoreIssue(until) error = %v", err)
+	}
+	if _, err := IgnoreIssue(ctx, d, service, expired, IgnoreOptions{Until: now.Add(-time.Minute)}); err != nil {
+		t.Fatalf("IgnoreIssue(expired) error = %v", err)
+	}
+
+	// Triaged issues drop out of the default listing and their reports
+	// out of the recent tables.
+	recent, err := GetRecentReports(ctx, d, service, 10, false)
+	if err != nil {
+		t.Fatalf("GetRecentReports() error = %v", err)
+	}
+	if len(recent) != 0 {
+		t.Errorf("GetRecentReports() = %d rows, want triaged rows hidden", len(recent))
+	}
+	if all, err := GetRecentReports(ctx, d, service, 10, true); err != nil || len(all) != 4 {
+		t.Errorf("GetRecentReports(includeTriaged) = %d rows, %v; want 4", len(all), err)
+	}
+
+	// Listing reopens the expired ignore.
+	open, err := GetIssues(ctx, d, service, IssueFilter{Statuses: []string{IssueOpen, IssueRegressed}})
+	if err != nil {
+		t.Fatalf("GetIssues(open) error = %v", err)
+	}
+	if len(open) != 1 || open[0].Fingerprint != expired || open[0].IgnoredUntil != nil {
+		t.Errorf("open issues = %+v, want only the expired ignore", open)
+	}
+
+	save("Resolved", now.Add(time.Minute))
+	if is := status(resolved); is.Status != IssueRegressed || is.RegressedAt == nil || !is.RegressedAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("resolved issue after a new report = %+v, want regressed", is)
+	}
+
+	save("IgnoredByCount", now)
+	if is := status(byCount); is.Status != IssueIgnored {
+		t.Errorf("issue after 1 of 2 ignored reports = %+v, want still ignored", is)
+	}
+	save("IgnoredByCount", now)
+	if is := status(byCount); is.Status != IssueOpen || is.IgnoreUntilCount != 0 {
+		t.Errorf("issue after 2 of 2 ignored reports = %+v, want open", is)
+	}
+
+	save("IgnoredByTime", now.Add(30*time.Minute))
+	if is := status(byTime); is.Status != IssueIgnored {
+		t.Errorf("issue with a report inside its ignore = %+v, want still ignored", is)
+	}
+	save("IgnoredByTime", now.Add(2*time.Hour))
+	if is := status(byTime); is.Status != IssueOpen || is.IgnoredUntil != nil {
+		t.Errorf("issue with a report past its ignore = %+v, want open", is)
+	}
+
+	if is, err := ReopenIssue(ctx, d, service, resolved); err != nil || is.Status != IssueOpen {
+		t.Errorf("ReopenIssue() = %+v, %v", is, err)
+	}
+	if n, err := GetIssues(ctx, d, service, IssueFilter{Statuses: []string{IssueOpen}}); err != nil || len(n) != 4 {
+		t.Errorf("GetIssues(open) = %d issues, %v; want all 4 open", len(n), err)
+	}
+}
@@ -0,0 +1,227 @@
+// Package ingest guards reportd's POST endpoints: it caps request body
+// size, decodes compressed bodies and answers failures with a JSON error
+// carrying a stable code.
+package ingest
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DefaultMaxBytes is the body size limit used when none is configured.
+const DefaultMaxBytes = 1 << 20
+
+// Error codes. They are part of the API: clients may match on them, so
+// existing codes must not change meaning.
+const (
+	CodeInvalidService       = "invalid_service"
+	CodeUnknownService       = "unknown_service"
+	CodeOriginNotAllowed     = "origin_not_allowed"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeUnsupportedEncoding  = "unsupported_encoding"
+	CodeMalformedEncoding    = "malformed_encoding"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeRateLimited          = "rate_limited"
+	CodeReadFailed           = "read_failed"
+	CodeMalformedPayload     = "malformed_payload"
+	CodeStorageFailed        = "storage_failed"
+	CodeInternal             = "internal"
+)
+
+// Error is an ingest failure. It is sent as {"error": {"code": ...,
+// "message": ...}} with Status as the response status.
+type Error struct {
+	Status int `json:"-"`
+	// Code is one of the Code constants.
+	Code string `json:"code"`
+	// Message explains the failure to a person and may change.
+	Message string `json:"message"`
+	// RetryAfter, if set, is sent as a Retry-After header in whole
+	// seconds, rounded up.
+	RetryAfter time.Duration `json:"-"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Errorf returns an *Error with a formatted message.
+func Errorf(status int, code, format string, args ...any) *Error {
+	return &Error{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// WriteError answers with err, which is sent as is if it is an *Error and
+// as a generic 500 otherwise, so internal details stay in the logs.
+func WriteError(w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "internal error"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
+	w.WriteHeader(e.Status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error *Error `json:"error"`
+	}{e})
+}
+
+// errUnsupportedEncoding is returned by decode for a Content-Encoding it
+// does not know.
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// decodeError marks a failure to decompress the body, as opposed to a
+// failure to read it.
+type decodeError struct{ err error }
+
+func (e *decodeError) Error() string { return "decoding body: " + e.err.Error() }
+func (e *decodeError) Unwrap() error { return e.err }
+
+// Middleware limits request bodies to maxBytes and decodes gzip, deflate
+// and br Content-Encodings, so handlers read plain bodies through
+// ReadBody. The limit applies both to the bytes on the wire and to the
+// decoded body, so a small compressed payload cannot expand without
+// bound.
+func Middleware(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				WriteError(w, tooLarge(maxBytes))
+				return
+			}
+			raw := http.MaxBytesReader(w, r.Body, maxBytes)
+			decoded, err := decode(r.Header.Get("Content-Encoding"), raw)
+			var maxErr *http.MaxBytesError
+			switch {
+			case errors.Is(err, errUnsupportedEncoding):
+				WriteError(w, Errorf(http.StatusUnsupportedMediaType, CodeUnsupportedEncoding, "%v; want gzip, deflate or br", err))
+				return
+			case errors.As(err, &maxErr):
+				WriteError(w, tooLarge(maxBytes))
+				return
+			case err != nil:
+				WriteError(w, Errorf(http.StatusBadRequest, CodeMalformedEncoding, "%v", err))
+				return
+			}
+			r.Header.Del("Content-Encoding")
+			if decoded != raw {
+				r.Header.Del("Content-Length")
+				r.ContentLength = -1
+				decoded = http.MaxBytesReader(w, decoded, maxBytes)
+			}
+			r.Body = decoded
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ReadBody reads r's body. Failures are returned as an *Error: 413 past
+// Middleware's limit, 400 for a corrupt compressed body or a read that
+// broke off.
+func ReadBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		return body, nil
+	}
+	var maxErr *http.MaxBytesError
+	var decErr *decodeError
+	switch {
+	case errors.As(err, &maxErr):
+		return nil, tooLarge(maxErr.Limit)
+	case errors.As(err, &decErr):
+		return nil, Errorf(http.StatusBadRequest, CodeMalformedEncoding, "%v", decErr)
+	default:
+		return nil, Errorf(http.StatusBadRequest, CodeReadFailed, "reading body: %v", err)
+	}
+}
+
+func tooLarge(limit int64) *Error {
+	return Errorf(http.StatusRequestEntityTooLarge, CodeBodyTooLarge, "request body exceeds %d bytes", limit)
+}
+
+// decode unwraps body according to a Content-Encoding header, which lists
+// encodings in the order they were applied.
+func decode(header string, body io.ReadCloser) (io.ReadCloser, error) {
+	encodings := strings.Split(header, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var (
+			r   io.Reader
+			err error
+		)
+		switch enc := strings.ToLower(strings.TrimSpace(encodings[i])); enc {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(body)
+		case "deflate":
+			r, err = newDeflateReader(body)
+		case "br":
+			r = brotli.NewReader(body)
+		default:
+			return nil, fmt.Errorf("%w %q", errUnsupportedEncoding, enc)
+		}
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return nil, err
+			}
+			return nil, &decodeError{err}
+		}
+		body = &decodedBody{r: r, raw: body}
+	}
+	return body, nil
+}
+
+// newDeflateReader reads HTTP's "deflate", which is zlib-wrapped, but
+// also accepts the raw DEFLATE stream some clients send instead.
+func newDeflateReader(body io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(body)
+	header, err := br.Peek(2)
+	if err != nil && len(header) < 2 {
+		return nil, err
+	}
+	// A zlib header names DEFLATE as its method and is a multiple of 31.
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decodedBody reads a decompressed body, marking decompression failures
+// as decodeErrors, and closes the body it was decoded from.
+type decodedBody struct {
+	r   io.Reader
+	raw io.Closer
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		var maxErr *http.MaxBytesError
+		var decErr *decodeError
+		if !errors.As(err, &maxErr) && !errors.As(err, &decErr) {
+			err = &decodeError{err}
+		}
+	}
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	return b.raw.Close()
+}
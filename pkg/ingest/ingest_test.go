@@ -0,0 +1,138 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func compress(t *testing.T, newWriter func(io.Writer) io.WriteCloser, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	if _, err := io.WriteString(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// serve posts body through Middleware(max) to a handler that echoes what
+// ReadBody returns.
+func serve(t *testing.T, max int64, encoding string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	h := Middleware(max)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			t.Error("Content-Encoding was not stripped")
+		}
+		got, err := ReadBody(r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		_, _ = w.Write(got)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func errorCode(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var got struct {
+		Error Error `json:"error"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("error body: %v body=%s", err, rr.Body.String())
+	}
+	return got.Error.Code
+}
+
+func TestMiddlewareDecodes(t *testing.T) {
+	const payload = `{"name":"LCP","value":2500}`
+	gz := func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+	zl := func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+	raw := func(w io.Writer) io.WriteCloser { fw, _ := flate.NewWriter(w, flate.DefaultCompression); return fw }
+	br := func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }
+
+	for _, tc := range []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"identity", "", []byte(payload)},
+		{"explicit identity", "identity", []byte(payload)},
+		{"gzip", "gzip", compress(t, gz, payload)},
+		{"deflate", "deflate", compress(t, zl, payload)},
+		{"raw deflate", "deflate", compress(t, raw, payload)},
+		{"br", "BR", compress(t, br, payload)},
+		{"stacked", "br, gzip", compress(t, gz, string(compress(t, br, payload)))},
+	} {
+		rr := serve(t, 1024, tc.encoding, tc.body)
+		if rr.Code != http.StatusOK || rr.Body.String() != payload {
+			t.Errorf("%s: got %d %q, want 200 %q", tc.name, rr.Code, rr.Body.String(), payload)
+		}
+	}
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+	gz := func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+	bomb := compress(t, gz, strings.Repeat("a", 4096))
+	truncated := compress(t, gz, strings.Repeat("abc", 100))
+	truncated = truncated[:len(truncated)/2]
+
+	for _, tc := range []struct {
+		name       string
+		encoding   string
+		body       []byte
+		wantStatus int
+		wantCode   string
+	}{
+		{"too large", "", bytes.Repeat([]byte("a"), 2048), http.StatusRequestEntityTooLarge, CodeBodyTooLarge},
+		{"too large decoded", "gzip", bomb, http.StatusRequestEntityTooLarge, CodeBodyTooLarge},
+		{"unknown encoding", "zstd", []byte("{}"), http.StatusUnsupportedMediaType, CodeUnsupportedEncoding},
+		{"not gzip", "gzip", []byte("{}"), http.StatusBadRequest, CodeMalformedEncoding},
+		{"truncated gzip", "gzip", truncated, http.StatusBadRequest, CodeMalformedEncoding},
+	} {
+		if tc.name == "too large decoded" && len(tc.body) >= 1024 {
+			t.Fatalf("%s: compressed body is %d bytes, want it under the limit", tc.name, len(tc.body))
+		}
+		rr := serve(t, 1024, tc.encoding, tc.body)
+		if rr.Code != tc.wantStatus {
+			t.Errorf("%s: status = %d, want %d, body=%s", tc.name, rr.Code, tc.wantStatus, rr.Body.String())
+			continue
+		}
+		if got := errorCode(t, rr); got != tc.wantCode {
+			t.Errorf("%s: code = %q, want %q", tc.name, got, tc.wantCode)
+		}
+	}
+}
+
+func TestWriteErrorHidesInternalErrors(t *testing.T) {
+	rr := httptest.NewRecorder()
+	WriteError(rr, io.ErrUnexpectedEOF)
+	if rr.Code != http.StatusInternalServerError || errorCode(t, rr) != CodeInternal {
+		t.Errorf("got %d %s, want 500 %s", rr.Code, rr.Body.String(), CodeInternal)
+	}
+	if strings.Contains(rr.Body.String(), "EOF") {
+		t.Errorf("body leaks the underlying error: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,112 @@
+package ingest
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/icco/reportd/pkg/lib"
+)
+
+const meterName = "github.com/icco/reportd/pkg/ingest"
+
+// Origin rejection reasons, the "reason" attribute of
+// reportd.ingest.origin.rejected.
+const (
+	// OriginReasonHeader: the Origin or Referer header is not allowed.
+	OriginReasonHeader = "header"
+	// OriginReasonURL: the page URL in the report is not allowed.
+	OriginReasonURL = "url"
+	// OriginReasonMissing: neither a header nor the report gave a URL.
+	OriginReasonMissing = "missing"
+)
+
+// AllowedOriginsFunc returns the origins service accepts reports from, as
+// lib.NormalizeOrigin entries. Empty means any.
+type AllowedOriginsFunc func(ctx context.Context, service string) ([]string, error)
+
+// OriginVerifier refuses ingest from origins a service has not allowed,
+// counting what it refuses in reportd.ingest.origin.rejected.
+type OriginVerifier struct {
+	allowed  AllowedOriginsFunc
+	rejected metric.Int64Counter
+}
+
+// NewOriginVerifier returns an OriginVerifier that looks services'
+// allowed origins up with allowed.
+func NewOriginVerifier(allowed AllowedOriginsFunc) *OriginVerifier {
+	rejected, _ := otel.Meter(meterName).Int64Counter("reportd.ingest.origin.rejected",
+		metric.WithDescription("Requests and reports refused by origin verification, by service and reason."))
+	return &OriginVerifier{allowed: allowed, rejected: rejected}
+}
+
+// OriginCheck verifies the reports in one request. A nil *OriginCheck
+// allows everything.
+type OriginCheck struct {
+	v       *OriginVerifier
+	service string
+	allowed []string
+	// headerOK is set when an Origin or Referer header was allowed.
+	headerOK bool
+}
+
+// Check verifies r's Origin header, or its Referer when it has no Origin,
+// against service's allowed origins. A disallowed header fails the whole
+// request with a 403 *Error. Otherwise the returned check is used to
+// verify each report's own URL; it is nil if service allows any origin.
+func (v *OriginVerifier) Check(r *http.Request, service string) (*OriginCheck, error) {
+	ctx := r.Context()
+	allowed, err := v.allowed(ctx, service)
+	if err != nil || len(allowed) == 0 {
+		return nil, err
+	}
+	c := &OriginCheck{v: v, service: service, allowed: allowed}
+
+	header := r.Header.Get("Origin")
+	if header == "" {
+		header = r.Referer()
+	}
+	if header != "" {
+		if !lib.OriginAllowed(allowed, header) {
+			v.reject(ctx, service, OriginReasonHeader)
+			return nil, Errorf(http.StatusForbidden, CodeOriginNotAllowed, "origin %q is not allowed for service %q", header, service)
+		}
+		c.headerOK = true
+	}
+	return c, nil
+}
+
+// Allows reports whether a report about the page at url may be stored,
+// counting it if not. A report without a URL is allowed only when the
+// request's header was.
+func (c *OriginCheck) Allows(ctx context.Context, url string) bool {
+	if c == nil {
+		return true
+	}
+	switch {
+	case url == "" && c.headerOK:
+		return true
+	case url == "":
+		c.v.reject(ctx, c.service, OriginReasonMissing)
+		return false
+	case !lib.OriginAllowed(c.allowed, url):
+		c.v.reject(ctx, c.service, OriginReasonURL)
+		return false
+	}
+	return true
+}
+
+// Refused is the error for a request none of whose reports was allowed.
+func (c *OriginCheck) Refused() error {
+	return Errorf(http.StatusForbidden, CodeOriginNotAllowed, "no report is from an origin allowed for service %q", c.service)
+}
+
+func (v *OriginVerifier) reject(ctx context.Context, service, reason string) {
+	v.rejected.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("service", service),
+		attribute.String("reason", reason),
+	))
+}
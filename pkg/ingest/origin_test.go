@@ -0,0 +1,76 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginVerifier(t *testing.T) {
+	v := NewOriginVerifier(func(_ context.Context, service string) ([]string, error) {
+		switch service {
+		case "blog":
+			return []string{"https://example.com"}, nil
+		case "broken":
+			return nil, errors.New("db down")
+		}
+		return nil, nil
+	})
+	ctx := context.Background()
+	check := func(service, origin, referer string) (*OriginCheck, error) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/report/"+service, nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if referer != "" {
+			r.Header.Set("Referer", referer)
+		}
+		return v.Check(r, service)
+	}
+
+	c, err := check("open", "https://evil.com", "")
+	if err != nil || c != nil || !c.Allows(ctx, "https://evil.com/") {
+		t.Errorf("no allowlist: Check() = %v, %v; want a nil check allowing anything", c, err)
+	}
+	if _, err := check("broken", "", ""); err == nil {
+		t.Error("lookup failure: Check() error = nil")
+	}
+
+	for _, tc := range []struct{ origin, referer string }{
+		{"https://evil.com", ""},
+		{"", "https://evil.com/page"},
+		{"https://evil.com", "https://example.com/"},
+	} {
+		_, err := check("blog", tc.origin, tc.referer)
+		var e *Error
+		if !errors.As(err, &e) || e.Status != http.StatusForbidden || e.Code != CodeOriginNotAllowed {
+			t.Errorf("Origin %q Referer %q: Check() error = %v, want 403 %s", tc.origin, tc.referer, err, CodeOriginNotAllowed)
+		}
+	}
+
+	c, err = check("blog", "", "https://example.com/about")
+	if err != nil {
+		t.Fatalf("allowed referer: Check() error = %v", err)
+	}
+	for url, want := range map[string]bool{
+		"":                      true,
+		"https://example.com/a": true,
+		"http://example.com/a":  false,
+		"https://evil.com/":     false,
+	} {
+		if got := c.Allows(ctx, url); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", url, got, want)
+		}
+	}
+
+	c, err = check("blog", "", "")
+	if err != nil {
+		t.Fatalf("no header: Check() error = %v", err)
+	}
+	if c.Allows(ctx, "") || !c.Allows(ctx, "https://example.com/") {
+		t.Error("without a header only reports with an allowed URL should pass")
+	}
+}
@@ -0,0 +1,298 @@
+package ingest
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+)
+
+// Rate limits, the "limit" attribute of reportd.ingest.throttled.
+const (
+	// LimitService: the service as a whole is over its rate.
+	LimitService = "service"
+	// LimitClient: one client is over its rate for the service.
+	LimitClient = "client"
+	// LimitClientTotal: one client is over its rate across all services.
+	LimitClientTotal = "client_total"
+)
+
+// sweepInterval is how often idle buckets are looked for.
+const sweepInterval = time.Minute
+
+// RateLimits configures a RateLimiter. A zero or negative rate disables
+// that limit; a burst below 1 defaults to one second's worth of requests.
+type RateLimits struct {
+	// Service is the requests per second one service accepts from all
+	// clients together.
+	Service      float64
+	ServiceBurst int
+	// Client is the requests per second one client may send to one
+	// service.
+	Client      float64
+	ClientBurst int
+	// ClientTotal is the requests per second one client may send to all
+	// services together.
+	ClientTotal      float64
+	ClientTotalBurst int
+	// TrustedProxies are the networks whose X-Forwarded-For is believed
+	// when finding the client; see ClientIP.
+	TrustedProxies []netip.Prefix
+}
+
+// Enabled reports whether any limit is set.
+func (l RateLimits) Enabled() bool {
+	return l.Service > 0 || l.Client > 0 || l.ClientTotal > 0
+}
+
+// RateLimiter throttles ingest with token buckets kept per service, per
+// client of each service and per client, counting what it throttles in
+// reportd.ingest.throttled. Buckets are held in memory, so each replica
+// enforces the limits on its own. Callers should only pass service names
+// they have checked, since each new name gets a bucket and a metric
+// label.
+type RateLimiter struct {
+	limits    RateLimits
+	throttled metric.Int64Counter
+	now       func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	// service is empty for a client's bucket across all services.
+	service string
+	// client is empty for the service-wide bucket.
+	client string
+}
+
+type bucket struct {
+	limiter *rate.Limiter
+	used    time.Time
+}
+
+// NewRateLimiter returns a RateLimiter enforcing limits.
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	limits.ServiceBurst = burst(limits.Service, limits.ServiceBurst)
+	limits.ClientBurst = burst(limits.Client, limits.ClientBurst)
+	limits.ClientTotalBurst = burst(limits.ClientTotal, limits.ClientTotalBurst)
+	throttled, _ := otel.Meter(meterName).Int64Counter("reportd.ingest.throttled",
+		metric.WithDescription("Ingest requests refused by rate limiting, by service and limit."))
+	return &RateLimiter{
+		limits:    limits,
+		throttled: throttled,
+		now:       time.Now,
+		buckets:   map[bucketKey]*bucket{},
+	}
+}
+
+func burst(r float64, b int) int {
+	if b >= 1 {
+		return b
+	}
+	return max(1, int(math.Ceil(r)))
+}
+
+// Middleware throttles requests to the service named by service(r),
+// answering 429 with a Retry-After header once a limit is spent.
+func (l *RateLimiter) Middleware(service func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := l.Allow(r.Context(), service(r), ClientIP(r, l.limits.TrustedProxies)); err != nil {
+				WriteError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Allow takes a token from service's bucket, from client's bucket for
+// service and from client's bucket across services. If any is empty none
+// is taken, and the returned 429 *Error says when to retry.
+func (l *RateLimiter) Allow(ctx context.Context, service string, client netip.Addr) error {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	type take struct {
+		limit string
+		r     *rate.Reservation
+	}
+	var taken []take
+	for _, k := range []struct {
+		limit string
+		key   bucketKey
+	}{
+		{LimitClient, bucketKey{service, clientKey(client)}},
+		{LimitClientTotal, bucketKey{client: clientKey(client)}},
+		{LimitService, bucketKey{service: service}},
+	} {
+		if b := l.bucket(k.key, now); b != nil {
+			taken = append(taken, take{k.limit, b.ReserveN(now, 1)})
+		}
+	}
+
+	var (
+		wait  time.Duration
+		limit string
+	)
+	for _, t := range taken {
+		if d := t.r.DelayFrom(now); d > wait {
+			wait, limit = d, t.limit
+		}
+	}
+	if wait == 0 {
+		return nil
+	}
+	for _, t := range taken {
+		t.r.CancelAt(now)
+	}
+	l.throttled.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("service", service),
+		attribute.String("limit", limit),
+	))
+	err := Errorf(http.StatusTooManyRequests, CodeRateLimited, "%s rate limit exceeded for service %q", limit, service)
+	err.RetryAfter = wait
+	return err
+}
+
+// bucket returns the limiter for key, creating it full, or nil if its
+// limit is off.
+func (l *RateLimiter) bucket(key bucketKey, now time.Time) *rate.Limiter {
+	r, burst := l.rate(key)
+	if r <= 0 {
+		return nil
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(r), burst)}
+		l.buckets[key] = b
+	}
+	b.used = now
+	return b.limiter
+}
+
+// rate returns the rate and burst of key's limit.
+func (l *RateLimiter) rate(key bucketKey) (float64, int) {
+	switch {
+	case key.client == "":
+		return l.limits.Service, l.limits.ServiceBurst
+	case key.service == "":
+		return l.limits.ClientTotal, l.limits.ClientTotalBurst
+	default:
+		return l.limits.Client, l.limits.ClientBurst
+	}
+}
+
+// sweep forgets buckets that have had time to refill, since a full
+// bucket is the same as a new one.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		r, burst := l.rate(key)
+		refill := time.Duration(float64(burst) / r * float64(time.Second))
+		if now.Sub(b.used) > max(refill, sweepInterval) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientKey groups IPv6 clients by /64, the block one host is usually
+// given, so a client cannot dodge its limit by changing address.
+func clientKey(ip netip.Addr) string {
+	if ip.Is6() {
+		p, _ := ip.Prefix(64)
+		return p.String()
+	}
+	return ip.String()
+}
+
+// ClientIP returns the address r came from. When the peer is a trusted
+// proxy, X-Forwarded-For is read right to left and the first address not
+// in trusted is the client; if every hop is trusted the leftmost is. An
+// unparsable hop ends the walk at the last address that was parsed, so a
+// forged header cannot pick the client's key.
+func ClientIP(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	ip := parseIP(r.RemoteAddr)
+	if !isTrusted(ip, trusted) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseIP(strings.TrimSpace(hops[i]))
+		if !hop.IsValid() {
+			break
+		}
+		ip = hop
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// parseIP accepts an address with or without a port.
+func parseIP(s string) netip.Addr {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap().WithZone("")
+}
+
+func isTrusted(ip netip.Addr, trusted []netip.Prefix) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	for _, p := range trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or single
+// addresses.
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !strings.Contains(f, "/") {
+			ip, err := netip.ParseAddr(f)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(f)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
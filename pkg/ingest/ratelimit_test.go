@@ -0,0 +1,136 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := NewRateLimiter(RateLimits{Service: 10, ServiceBurst: 3, Client: 1, ClientBurst: 2})
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+	a, b := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")
+
+	limited := func(err error) *Error {
+		t.Helper()
+		var e *Error
+		if !errors.As(err, &e) || e.Status != http.StatusTooManyRequests || e.Code != CodeRateLimited {
+			t.Fatalf("error = %v, want 429 %s", err, CodeRateLimited)
+		}
+		return e
+	}
+
+	for i := range 2 {
+		if err := l.Allow(ctx, "blog", a); err != nil {
+			t.Fatalf("request %d from a: %v", i, err)
+		}
+	}
+	if e := limited(l.Allow(ctx, "blog", a)); e.RetryAfter != time.Second {
+		t.Errorf("client RetryAfter = %v, want 1s", e.RetryAfter)
+	}
+	// Other services have their own buckets.
+	if err := l.Allow(ctx, "shop", a); err != nil {
+		t.Errorf("other service: %v", err)
+	}
+
+	// b spends the service's last token; a throttled request takes none.
+	if err := l.Allow(ctx, "blog", b); err != nil {
+		t.Fatalf("first request from b: %v", err)
+	}
+	if e := limited(l.Allow(ctx, "blog", b)); e.RetryAfter != 100*time.Millisecond {
+		t.Errorf("service RetryAfter = %v, want 100ms", e.RetryAfter)
+	}
+
+	now = now.Add(time.Second)
+	if err := l.Allow(ctx, "blog", a); err != nil {
+		t.Errorf("after refill: %v", err)
+	}
+
+	// Idle buckets are forgotten once they would be full again.
+	now = now.Add(time.Hour)
+	_ = l.Allow(ctx, "shop", b)
+	if n := len(l.buckets); n != 2 {
+		t.Errorf("%d buckets after sweep, want the 2 just used", n)
+	}
+}
+
+func TestRateLimiterClientTotal(t *testing.T) {
+	l := NewRateLimiter(RateLimits{Client: 10, ClientTotal: 1, ClientTotalBurst: 3})
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+	a, b := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")
+
+	// A new service name each time still draws on the one client bucket.
+	for _, service := range []string{"s1", "s2", "s3"} {
+		if err := l.Allow(ctx, service, a); err != nil {
+			t.Fatalf("request to %s: %v", service, err)
+		}
+	}
+	var e *Error
+	if err := l.Allow(ctx, "s4", a); !errors.As(err, &e) || e.Status != http.StatusTooManyRequests || e.RetryAfter != time.Second {
+		t.Errorf("fourth service: error = %v, want 429 after 1s", err)
+	}
+	if err := l.Allow(ctx, "s4", b); err != nil {
+		t.Errorf("other client: %v", err)
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	l := NewRateLimiter(RateLimits{Client: 0.5})
+	h := l.Middleware(func(*http.Request) string { return "blog" })(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	var codes []int
+	for range 2 {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/reporting/blog", nil))
+		codes = append(codes, rr.Code)
+		if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") != "2" {
+			t.Errorf("Retry-After = %q, want 2", rr.Header().Get("Retry-After"))
+		}
+	}
+	if codes[0] != http.StatusNoContent || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("statuses = %v, want [204 429]", codes)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, remote, xff, want string
+	}{
+		{"direct", "203.0.113.5:1234", "198.51.100.1", "203.0.113.5"},
+		{"via proxy", "10.1.2.3:80", "198.51.100.1", "198.51.100.1"},
+		{"forged prefix", "10.1.2.3:80", "1.1.1.1, 198.51.100.1, 10.0.0.9", "198.51.100.1"},
+		{"all trusted", "10.1.2.3:80", "192.0.2.1, 10.0.0.9", "192.0.2.1"},
+		{"garbage hop", "10.1.2.3:80", "198.51.100.1, junk, 10.0.0.9", "10.0.0.9"},
+		{"no header", "10.1.2.3:80", "", "10.1.2.3"},
+		{"ipv6", "[2001:db8::1]:443", "", "2001:db8::1"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if got := ClientIP(r, trusted); got.String() != tc.want {
+			t.Errorf("%s: ClientIP() = %v, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("ParseTrustedProxies accepted an invalid CIDR")
+	}
+	if clientKey(netip.MustParseAddr("2001:db8::1")) != clientKey(netip.MustParseAddr("2001:db8::ffff")) {
+		t.Error("IPv6 clients in one /64 should share a bucket")
+	}
+}
@@ -0,0 +1,98 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Sampled event types besides report types, which are sampled under the
+// type the browser gave them ("deprecation", "csp-violation", ...).
+const (
+	// EventTypeWebVital is every Web Vitals beacon.
+	EventTypeWebVital = "web-vital"
+	// EventTypeDefault sets the rate for event types without their own.
+	EventTypeDefault = "*"
+)
+
+var validEventType = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// ValidateSampleRates checks that every key of rates is an event type or
+// EventTypeDefault, and every rate is in (0, 1].
+func ValidateSampleRates(rates map[string]float64) error {
+	for t, r := range rates {
+		if t != EventTypeDefault && !validEventType.MatchString(t) {
+			return fmt.Errorf("invalid event type %q", t)
+		}
+		if !(r > 0 && r <= 1) {
+			return fmt.Errorf("sample rate %v for %q is not in (0, 1]", r, t)
+		}
+	}
+	return nil
+}
+
+// SampleRatesFunc returns service's sample rates by event type. Nil
+// keeps everything.
+type SampleRatesFunc func(ctx context.Context, service string) (map[string]float64, error)
+
+// Sampler keeps a configured fraction of each service's events, counting
+// those it drops in reportd.ingest.sampled_out.
+type Sampler struct {
+	rates   SampleRatesFunc
+	dropped metric.Int64Counter
+	// random returns a number in [0, 1); tests replace it.
+	random func() float64
+}
+
+// NewSampler returns a Sampler that looks services' rates up with rates.
+func NewSampler(rates SampleRatesFunc) *Sampler {
+	dropped, _ := otel.Meter(meterName).Int64Counter("reportd.ingest.sampled_out",
+		metric.WithDescription("Ingested events dropped by sampling, by service and event type."))
+	return &Sampler{rates: rates, dropped: dropped, random: rand.Float64}
+}
+
+// Sampling decides which of one request's events are kept.
+type Sampling struct {
+	s       *Sampler
+	service string
+	rates   map[string]float64
+}
+
+// For returns the sampling decisions for a request to service.
+func (s *Sampler) For(ctx context.Context, service string) (*Sampling, error) {
+	rates, err := s.rates(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	return &Sampling{s: s, service: service, rates: rates}, nil
+}
+
+// Rate is the fraction of eventType events kept: its own rate, else the
+// EventTypeDefault rate, else 1.
+func (s *Sampling) Rate(eventType string) float64 {
+	if r, ok := s.rates[eventType]; ok {
+		return r
+	}
+	if r, ok := s.rates[EventTypeDefault]; ok {
+		return r
+	}
+	return 1
+}
+
+// Keep decides whether to keep one eventType event, counting it if not.
+func (s *Sampling) Keep(ctx context.Context, eventType string) bool {
+	r := s.Rate(eventType)
+	if r >= 1 || s.s.random() < r {
+		return true
+	}
+	s.s.dropped.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("service", s.service),
+		attribute.String("event_type", eventType),
+	))
+	return false
+}
@@ -0,0 +1,65 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSampler(t *testing.T) {
+	s := NewSampler(func(_ context.Context, service string) (map[string]float64, error) {
+		switch service {
+		case "blog":
+			return map[string]float64{EventTypeWebVital: 0.1, EventTypeDefault: 0.5, "csp-violation": 1}, nil
+		case "broken":
+			return nil, errors.New("db down")
+		}
+		return nil, nil
+	})
+	ctx := context.Background()
+
+	if _, err := s.For(ctx, "broken"); err == nil {
+		t.Error("For(broken) error = nil")
+	}
+	open, err := s.For(ctx, "open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := open.Rate(EventTypeWebVital); r != 1 {
+		t.Errorf("unconfigured Rate() = %v, want 1", r)
+	}
+
+	blog, err := s.For(ctx, "blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for eventType, want := range map[string]float64{EventTypeWebVital: 0.1, "csp-violation": 1, "deprecation": 0.5} {
+		if got := blog.Rate(eventType); got != want {
+			t.Errorf("Rate(%q) = %v, want %v", eventType, got, want)
+		}
+	}
+
+	s.random = func() float64 { return 0.3 }
+	for eventType, want := range map[string]bool{EventTypeWebVital: false, "csp-violation": true, "deprecation": true} {
+		if got := blog.Keep(ctx, eventType); got != want {
+			t.Errorf("Keep(%q) at 0.3 = %v, want %v", eventType, got, want)
+		}
+	}
+}
+
+func TestValidateSampleRates(t *testing.T) {
+	for _, tc := range []struct {
+		rates map[string]float64
+		ok    bool
+	}{
+		{map[string]float64{EventTypeWebVital: 0.1, EventTypeDefault: 1}, true},
+		{map[string]float64{"deprecation": 0}, false},
+		{map[string]float64{"deprecation": 1.5}, false},
+		{map[string]float64{"Deprecation": 0.5}, false},
+		{map[string]float64{"": 0.5}, false},
+	} {
+		if err := ValidateSampleRates(tc.rates); (err == nil) != tc.ok {
+			t.Errorf("ValidateSampleRates(%v) error = %v, want ok = %v", tc.rates, err, tc.ok)
+		}
+	}
+}
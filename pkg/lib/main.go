@@ -3,7 +3,10 @@ package lib
 
 import (
 	"fmt"
+	"net/url"
 	"regexp"
+	"strings"
+	"time"
 )
 
 var validServiceName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
@@ -25,3 +28,95 @@ func ValidateService(name string) error {
 
 	return nil
 }
+
+// MaxReportAge caps the age a report may claim. Browsers drop reports
+// long before this, and keeping it under a day means a report's
+// occurrence always falls on a day the rollup job still rebuilds.
+const MaxReportAge = 24 * time.Hour
+
+// OccurredAt returns when a report delivered at received happened, given
+// the Reporting API's age in milliseconds. Negative ages count as zero and
+// ages over MaxReportAge as MaxReportAge.
+func OccurredAt(received time.Time, ageMillis int64) time.Time {
+	ageMillis = min(max(ageMillis, 0), MaxReportAge.Milliseconds())
+	return received.Add(-time.Duration(ageMillis) * time.Millisecond)
+}
+
+// validHostPattern matches a lower-case DNS name or IPv4 address,
+// optionally behind a "*." subdomain wildcard.
+var validHostPattern = regexp.MustCompile(`^(\*\.)?[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// NormalizeOrigin canonicalizes an allowed-origin entry. It is either an
+// origin, "https://example.com" or "http://localhost:8080", or a bare
+// hostname, "example.com", standing for that host over any scheme and
+// port. Either form may start its host with "*." to cover subdomains.
+// Results are lower case, and default ports are dropped.
+func NormalizeOrigin(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return "", fmt.Errorf("origin must not be empty")
+	}
+	if !strings.Contains(s, "://") {
+		if !validHostPattern.MatchString(s) {
+			return "", fmt.Errorf("invalid hostname %q", s)
+		}
+		return s, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid origin %q: %w", s, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("origin %q must be http or https", s)
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("origin %q must be scheme://host[:port] only", s)
+	}
+	host := u.Hostname()
+	if !validHostPattern.MatchString(host) {
+		return "", fmt.Errorf("invalid hostname %q in origin %q", host, s)
+	}
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	return u.Scheme + "://" + host, nil
+}
+
+// OriginAllowed reports whether rawURL, a URL or an Origin header value,
+// falls under one of allowed, which are entries as NormalizeOrigin returns
+// them. A "*." wildcard covers subdomains but not the domain itself.
+func OriginAllowed(allowed []string, rawURL string) bool {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	hostPort := host
+	if port := u.Port(); port != "" && !(scheme == "http" && port == "80") && !(scheme == "https" && port == "443") {
+		hostPort += ":" + port
+	}
+	for _, a := range allowed {
+		if pattern, ok := strings.CutPrefix(a, scheme+"://"); ok {
+			if hostMatches(pattern, hostPort) {
+				return true
+			}
+		} else if !strings.Contains(a, "://") && hostMatches(a, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostMatches compares host to pattern, which may start with "*.".
+func hostMatches(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return len(host) > len(suffix) && strings.HasSuffix(host, suffix)
+	}
+	return host == pattern
+}
@@ -3,6 +3,7 @@ package lib
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestValidateService(t *testing.T) {
@@ -171,3 +172,76 @@ func TestValidateService(t *testing.T) {
 		})
 	}
 }
+
+func TestOccurredAt(t *testing.T) {
+	received := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		age  int64
+		want time.Time
+	}{
+		{0, received},
+		{1500, received.Add(-1500 * time.Millisecond)},
+		{-60000, received},
+		{MaxReportAge.Milliseconds() * 10, received.Add(-MaxReportAge)},
+	} {
+		if got := OccurredAt(received, tc.age); !got.Equal(tc.want) {
+			t.Errorf("OccurredAt(%d) = %v, want %v", tc.age, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"https://Example.com", "https://example.com", false},
+		{"https://example.com/", "https://example.com", false},
+		{"https://example.com:443", "https://example.com", false},
+		{"http://localhost:8080", "http://localhost:8080", false},
+		{" example.com ", "example.com", false},
+		{"*.example.com", "*.example.com", false},
+		{"https://*.example.com", "https://*.example.com", false},
+		{"http://127.0.0.1", "http://127.0.0.1", false},
+		{"", "", true},
+		{"ftp://example.com", "", true},
+		{"https://example.com/path", "", true},
+		{"https://user@example.com", "", true},
+		{"https://example.com?q=1", "", true},
+		{"exa mple.com", "", true},
+		{"example.*.com", "", true},
+	} {
+		got, err := NormalizeOrigin(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("NormalizeOrigin(%q) = %q, %v; want %q, error %v", tc.in, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://example.com", "http://localhost:8080", "*.example.org", "blog.example.net"}
+	for _, tc := range []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com", true},
+		{"https://EXAMPLE.com:443/posts/1?q=2", true},
+		{"http://example.com/", false},
+		{"https://www.example.com/", false},
+		{"http://localhost:8080/", true},
+		{"http://localhost/", false},
+		{"https://a.example.org/page", true},
+		{"http://a.b.example.org:3000/", true},
+		{"https://example.org/", false},
+		{"https://evilexample.org/", false},
+		{"http://blog.example.net:8080/", true},
+		{"null", false},
+		{"", false},
+		{"/relative/path", false},
+	} {
+		if got := OriginAllowed(allowed, tc.url); got != tc.want {
+			t.Errorf("OriginAllowed(%q) = %v, want %v", tc.url, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package noise filters reports that say nothing about the site that got
+// them, such as CSP violations caused by browser extensions. Rules match
+// a report's fields and drop it, tag it or keep it as is.
+package noise
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const meterName = "github.com/icco/reportd/pkg/noise"
+
+// Action is what happens to a report a rule matches.
+type Action string
+
+// Actions.
+const (
+	// Drop discards the report.
+	Drop Action = "drop"
+	// Tag stores the report with the rule's name.
+	Tag Action = "tag"
+	// Keep stores the report untouched, overriding later rules.
+	Keep Action = "keep"
+)
+
+// Rule matches a report when each of its fields with patterns has one
+// that matches the report's value; fields without patterns match
+// anything. Patterns are case-insensitive and * matches any run of
+// characters.
+type Rule struct {
+	// Name identifies the rule in tags and metrics.
+	Name       string   `json:"name"`
+	Action     Action   `json:"action"`
+	ReportType []string `json:"report_type,omitempty"`
+	BlockedURI []string `json:"blocked_uri,omitempty"`
+	SourceFile []string `json:"source_file,omitempty"`
+	Directive  []string `json:"directive,omitempty"`
+	UserAgent  []string `json:"user_agent,omitempty"`
+}
+
+// Report holds the fields rules match on.
+type Report struct {
+	Type       string
+	BlockedURI string
+	SourceFile string
+	// Directive is the effective CSP directive, or the violated one for
+	// browsers that send only that.
+	Directive string
+	UserAgent string
+}
+
+// Match reports whether r matches every field of the rule.
+func (rule Rule) Match(r Report) bool {
+	return matchAny(rule.ReportType, r.Type) &&
+		matchAny(rule.BlockedURI, r.BlockedURI) &&
+		matchAny(rule.SourceFile, r.SourceFile) &&
+		matchAny(rule.Directive, r.Directive) &&
+		matchAny(rule.UserAgent, r.UserAgent)
+}
+
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	s = strings.ToLower(s)
+	for _, p := range patterns {
+		if glob(strings.ToLower(p), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// glob matches s against pattern, where * matches any run of characters
+// and everything else matches itself.
+func glob(pattern, s string) bool {
+	// On a mismatch, backtrack to the last * and let it take one more
+	// character of s.
+	p, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case p < len(pattern) && pattern[p] == s[i]:
+			p++
+			i++
+		case star >= 0:
+			p = star + 1
+			mark++
+			i = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// extensionURIs match scripts and pages served by browser extensions.
+var extensionURIs = []string{
+	"chrome-extension://*",
+	"moz-extension://*",
+	"safari-extension://*",
+	"safari-web-extension://*",
+	"ms-browser-extension://*",
+}
+
+// Defaults returns the built-in rules, which drop reports caused by
+// browser extensions and about:blank frames.
+func Defaults() []Rule {
+	return []Rule{
+		{Name: "extension-blocked-uri", Action: Drop, BlockedURI: extensionURIs},
+		{Name: "extension-inline-script", Action: Drop, BlockedURI: []string{"inline", "eval"}, SourceFile: extensionURIs},
+		{Name: "about-blank", Action: Drop, BlockedURI: []string{"about", "about:blank"}},
+	}
+}
+
+var validRuleName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// ValidateRules checks that each rule has a valid name that no other
+// rule, built-in ones included, uses, a known action and at least one
+// pattern.
+func ValidateRules(rules []Rule) error {
+	seen := map[string]bool{}
+	for _, d := range Defaults() {
+		seen[d.Name] = true
+	}
+	for _, r := range rules {
+		if !validRuleName.MatchString(r.Name) {
+			return fmt.Errorf("invalid rule name %q", r.Name)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("duplicate rule name %q", r.Name)
+		}
+		seen[r.Name] = true
+		switch r.Action {
+		case Drop, Tag, Keep:
+		default:
+			return fmt.Errorf("rule %q: action %q is not drop, tag or keep", r.Name, r.Action)
+		}
+		if len(r.ReportType)+len(r.BlockedURI)+len(r.SourceFile)+len(r.Directive)+len(r.UserAgent) == 0 {
+			return fmt.Errorf("rule %q has no patterns, so it would match every report", r.Name)
+		}
+	}
+	return nil
+}
+
+// RulesFunc returns service's own rules.
+type RulesFunc func(ctx context.Context, service string) ([]Rule, error)
+
+// Filter applies each service's rules, then the defaults, to reports,
+// counting matches in reportd.noise.matched by service, rule and action.
+type Filter struct {
+	defaults []Rule
+	rules    RulesFunc
+	matched  metric.Int64Counter
+}
+
+// NewFilter returns a Filter that applies defaults after the rules
+// looked up with rules.
+func NewFilter(defaults []Rule, rules RulesFunc) *Filter {
+	matched, _ := otel.Meter(meterName).Int64Counter("reportd.noise.matched",
+		metric.WithDescription("Reports matched by a noise rule, by service, rule and action."))
+	return &Filter{defaults: defaults, rules: rules, matched: matched}
+}
+
+// ServiceFilter applies the rules for one service.
+type ServiceFilter struct {
+	f       *Filter
+	service string
+	rules   []Rule
+}
+
+// For returns the rules for service: its own, then the defaults.
+func (f *Filter) For(ctx context.Context, service string) (*ServiceFilter, error) {
+	rules, err := f.rules(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceFilter{f: f, service: service, rules: append(rules[:len(rules):len(rules)], f.defaults...)}, nil
+}
+
+// Apply finds the first rule r matches. It returns drop for a Drop rule
+// and the rule's name as tag for a Tag rule.
+func (s *ServiceFilter) Apply(ctx context.Context, r Report) (tag string, drop bool) {
+	for _, rule := range s.rules {
+		if !rule.Match(r) {
+			continue
+		}
+		s.f.matched.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("service", s.service),
+			attribute.String("rule", rule.Name),
+			attribute.String("action", string(rule.Action)),
+		))
+		switch rule.Action {
+		case Drop:
+			return "", true
+		case Tag:
+			return rule.Name, false
+		}
+		return "", false
+	}
+	return "", false
+}
@@ -0,0 +1,118 @@
+package noise
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"inline", "inline", true},
+		{"inline", "inline2", false},
+		{"chrome-extension://*", "chrome-extension://abc/content.js", true},
+		{"chrome-extension://*", "https://chrome-extension://", false},
+		{"*.example.com/*", "https://cdn.example.com/a.js", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+	} {
+		if got := glob(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("glob(%q, %q) = %v, want %v", tc.pattern, tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	f := NewFilter(Defaults(), func(context.Context, string) ([]Rule, error) { return nil, nil })
+	ctx := context.Background()
+	s, err := f.For(ctx, "blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		r    Report
+		drop bool
+	}{
+		{"chrome extension", Report{Type: "csp-violation", BlockedURI: "chrome-extension://abcdef/inject.js"}, true},
+		{"firefox extension", Report{Type: "csp", BlockedURI: "MOZ-EXTENSION://1234/x.js"}, true},
+		{"about:blank", Report{Type: "csp-violation", BlockedURI: "about:blank"}, true},
+		{"legacy about", Report{Type: "csp", BlockedURI: "about"}, true},
+		{"extension inline script", Report{Type: "csp-violation", BlockedURI: "inline", SourceFile: "chrome-extension://abcdef/content.js"}, true},
+		{"site inline script", Report{Type: "csp-violation", BlockedURI: "inline", SourceFile: "https://example.com/app.js"}, false},
+		{"third-party script", Report{Type: "csp-violation", BlockedURI: "https://evil.com/x.js"}, false},
+	} {
+		tag, drop := s.Apply(ctx, tc.r)
+		if drop != tc.drop || tag != "" {
+			t.Errorf("%s: Apply() = %q, %v, want \"\", %v", tc.name, tag, drop, tc.drop)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f := NewFilter(Defaults(), func(_ context.Context, service string) ([]Rule, error) {
+		switch service {
+		case "blog":
+			return []Rule{
+				{Name: "allow-own-extension", Action: Keep, BlockedURI: []string{"chrome-extension://ourid/*"}},
+				{Name: "analytics", Action: Tag, BlockedURI: []string{"https://*.analytics.example/*"}, Directive: []string{"script-src*"}},
+				{Name: "old-edge", Action: Drop, UserAgent: []string{"*Edge/18*"}},
+			}, nil
+		case "broken":
+			return nil, errors.New("db down")
+		}
+		return nil, nil
+	})
+	ctx := context.Background()
+
+	if _, err := f.For(ctx, "broken"); err == nil {
+		t.Error("For(broken) error = nil")
+	}
+	s, err := f.For(ctx, "blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		r    Report
+		tag  string
+		drop bool
+	}{
+		{"keep overrides default", Report{BlockedURI: "chrome-extension://ourid/x.js"}, "", false},
+		{"other extension", Report{BlockedURI: "chrome-extension://theirs/x.js"}, "", true},
+		{"tagged", Report{BlockedURI: "https://t.analytics.example/a.js", Directive: "script-src-elem"}, "analytics", false},
+		{"directive must match", Report{BlockedURI: "https://t.analytics.example/a.js", Directive: "img-src"}, "", false},
+		{"user agent", Report{BlockedURI: "https://cdn.example.com/", UserAgent: "Mozilla/5.0 Edge/18.17763"}, "", true},
+	} {
+		tag, drop := s.Apply(ctx, tc.r)
+		if tag != tc.tag || drop != tc.drop {
+			t.Errorf("%s: Apply() = %q, %v, want %q, %v", tc.name, tag, drop, tc.tag, tc.drop)
+		}
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	for _, tc := range []struct {
+		rules []Rule
+		ok    bool
+	}{
+		{nil, true},
+		{[]Rule{{Name: "gtm", Action: Tag, BlockedURI: []string{"https://www.googletagmanager.com/*"}}}, true},
+		{[]Rule{{Name: "Bad Name", Action: Drop, BlockedURI: []string{"x"}}}, false},
+		{[]Rule{{Name: "about-blank", Action: Keep, BlockedURI: []string{"about:blank"}}}, false},
+		{[]Rule{{Name: "a", Action: Drop, BlockedURI: []string{"x"}}, {Name: "a", Action: Tag, BlockedURI: []string{"y"}}}, false},
+		{[]Rule{{Name: "a", Action: "ignore", BlockedURI: []string{"x"}}}, false},
+		{[]Rule{{Name: "everything", Action: Drop}}, false},
+	} {
+		if err := ValidateRules(tc.rules); (err == nil) != tc.ok {
+			t.Errorf("ValidateRules(%+v) = %v, want ok %v", tc.rules, err, tc.ok)
+		}
+	}
+}
@@ -2,13 +2,18 @@
 package reporting
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
+
+	"github.com/icco/reportd/pkg/lib"
+	"github.com/icco/reportd/pkg/useragent"
 )
 
 // CSPReport is a Content-Security-Policy violation.
@@ -155,7 +160,62 @@ type DocumentPolicyReportBody struct {
 	Message      string `json:"message,omitempty"`
 }
 
-// SecurityReport is the parsed envelope returned by ParseReport. Exactly
+// NELReport is a Network Error Logging report; see
+// https://w3c.github.io/network-error-logging/.
+type NELReport struct {
+	Type string        `json:"type"`
+	URL  string        `json:"url"`
+	Body NELReportBody `json:"body"`
+}
+
+// NELReportBody is the body of a NELReport. Request and response header
+// captures are left in RawJSON; BigQuery can't infer map columns.
+type NELReportBody struct {
+	SamplingFraction float64 `json:"sampling_fraction"`
+	Referrer         string  `json:"referrer,omitempty"`
+	ServerIP         string  `json:"server_ip,omitempty"`
+	Protocol         string  `json:"protocol,omitempty"`
+	Method           string  `json:"method,omitempty"`
+	StatusCode       int32   `json:"status_code,omitempty"`
+	ElapsedTime      int64   `json:"elapsed_time,omitempty"`
+	Phase            string  `json:"phase,omitempty"`
+	Type             string  `json:"type,omitempty"`
+}
+
+// NELPolicy is the value of a NEL response header. ReportTo names a
+// Report-To group; fractions are in [0, 1] and default to the browser's
+// own (no successes, all failures) when zero.
+type NELPolicy struct {
+	ReportTo          string  `json:"report_to"`
+	MaxAge            int     `json:"max_age"`
+	IncludeSubdomains bool    `json:"include_subdomains,omitempty"`
+	SuccessFraction   float64 `json:"success_fraction,omitempty"`
+	FailureFraction   float64 `json:"failure_fraction,omitempty"`
+}
+
+// NELHeader renders p as a NEL header value.
+func NELHeader(p NELPolicy) (string, error) {
+	if p.ReportTo == "" {
+		return "", fmt.Errorf("report_to must not be empty")
+	}
+	if p.MaxAge < 0 {
+		return "", fmt.Errorf("max_age must not be negative")
+	}
+	if p.SuccessFraction < 0 || p.SuccessFraction > 1 {
+		return "", fmt.Errorf("success_fraction %v must be in [0, 1]", p.SuccessFraction)
+	}
+	if p.FailureFraction < 0 || p.FailureFraction > 1 {
+		return "", fmt.Errorf("failure_fraction %v must be in [0, 1]", p.FailureFraction)
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// SecurityReport is one parsed report returned by ParseReport. Exactly
 // one typed pointer is populated; unknown types fall through with only
 // RawJSON set.
 type SecurityReport struct {
@@ -167,38 +227,133 @@ type SecurityReport struct {
 	COEP              *COEPReport              `bigquery:",nullable"`
 	COOP              *COOPReport              `bigquery:",nullable"`
 	DocumentPolicy    *DocumentPolicyReport    `bigquery:",nullable"`
+	NEL               *NELReport               `bigquery:",nullable"`
 
 	ReportType bigquery.NullString
 
-	// RawJSON is the original request body, kept for forward-compatibility
-	// with unknown report types. Excluded from BigQuery (typed columns
-	// above) but stored in the SQL layer.
+	// RawJSON is the report's original JSON (one element of a batch),
+	// kept for forward-compatibility with unknown report types. Excluded
+	// from BigQuery (typed columns above) but stored in the SQL layer.
 	RawJSON string `bigquery:"-"`
 
+	// Agent is the browser that sent the report, parsed from the
+	// report's user_agent, or from the request's User-Agent header when
+	// the report has none.
+	Agent *useragent.Agent `bigquery:",nullable"`
+
+	// Time is when reportd received the report.
 	Time bigquery.NullDateTime
 
+	// OccurredAt is when the browser saw the event: Time less the
+	// report's age, as lib.OccurredAt bounds it.
+	OccurredAt bigquery.NullDateTime
+
 	Service bigquery.NullString
 }
 
-// ParseReport decodes a Reporting API v1 payload into a SecurityReport.
-// The "type" field selects which typed pointer is populated; unknown
-// types are preserved in RawJSON.
-func ParseReport(data, srv string) (*SecurityReport, error) {
+// URL returns the URL of the page the report is about.
+func (sr *SecurityReport) URL() string {
+	switch {
+	case sr.CSP != nil:
+		return sr.CSP.URL
+	case sr.Deprecation != nil:
+		return sr.Deprecation.URL
+	case sr.PermissionsPolicy != nil:
+		return sr.PermissionsPolicy.URL
+	case sr.Intervention != nil:
+		return sr.Intervention.URL
+	case sr.Crash != nil:
+		return sr.Crash.URL
+	case sr.COEP != nil:
+		return sr.COEP.URL
+	case sr.COOP != nil:
+		return sr.COOP.URL
+	case sr.DocumentPolicy != nil:
+		return sr.DocumentPolicy.URL
+	case sr.NEL != nil:
+		return sr.NEL.URL
+	default:
+		return ""
+	}
+}
+
+// ElementError records a batch element that ParseReport could not
+// decode. Index is the element's position in the delivered array.
+type ElementError struct {
+	Index int
+	Err   error
+}
+
+// Error implements error.
+func (e *ElementError) Error() string {
+	return fmt.Sprintf("report %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the underlying decode error.
+func (e *ElementError) Unwrap() error {
+	return e.Err
+}
+
+// ParseReport decodes a Reporting API v1 payload into SecurityReports.
+// Browsers batch deliveries into a JSON array; a bare object is accepted
+// too. Elements that fail to decode are skipped and returned as
+// *ElementError values joined into the error, so callers can keep the
+// rest of the batch. An array with nothing decodable, or no elements,
+// gives an empty slice rather than nil; nil comes only with the error
+// for a bare object that fails to decode or a malformed array.
+func ParseReport(data, srv string) ([]*SecurityReport, error) {
+	trimmed := bytes.TrimLeft([]byte(data), " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		sr, err := parseReport(data, srv)
+		if err != nil {
+			return nil, err
+		}
+		return []*SecurityReport{sr}, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(trimmed, &items); err != nil {
+		return nil, err
+	}
+
+	reports := make([]*SecurityReport, 0, len(items))
+	var errs []error
+	for i, item := range items {
+		sr, err := parseReport(string(item), srv)
+		if err != nil {
+			errs = append(errs, &ElementError{Index: i, Err: err})
+			continue
+		}
+		reports = append(reports, sr)
+	}
+
+	return reports, errors.Join(errs...)
+}
+
+// parseReport decodes a single report object. The "type" field selects
+// which typed pointer is populated; unknown types are preserved in
+// RawJSON.
+func parseReport(data, srv string) (*SecurityReport, error) {
+	received := time.Now()
 	sr := &SecurityReport{
-		Time:    bigquery.NullDateTime{DateTime: civil.DateTimeOf(time.Now()), Valid: true},
+		Time:    bigquery.NullDateTime{DateTime: civil.DateTimeOf(received), Valid: true},
 		Service: bigquery.NullString{StringVal: srv, Valid: true},
 	}
 
 	tmp := struct {
-		Type string `json:"type"`
+		Type      string `json:"type"`
+		Age       int64  `json:"age"`
+		UserAgent string `json:"user_agent"`
 	}{}
 
 	if err := json.Unmarshal([]byte(data), &tmp); err != nil {
 		return nil, err
 	}
+	sr.OccurredAt = bigquery.NullDateTime{DateTime: civil.DateTimeOf(lib.OccurredAt(received, tmp.Age)), Valid: true}
 
 	sr.ReportType = bigquery.NullString{StringVal: tmp.Type, Valid: true}
 	sr.RawJSON = data
+	sr.Agent = useragent.Parse(tmp.UserAgent)
 
 	switch tmp.Type {
 	case "csp-violation":
@@ -246,6 +401,10 @@ func ParseReport(data, srv string) (*SecurityReport, error) {
 		if err := json.Unmarshal([]byte(data), &sr.DocumentPolicy); err != nil {
 			return nil, err
 		}
+	case "network-error":
+		if err := json.Unmarshal([]byte(data), &sr.NEL); err != nil {
+			return nil, err
+		}
 	default:
 		// Unknown type: preserved in RawJSON.
 	}
@@ -279,6 +438,8 @@ func ParseLegacyCSPReport(data, srv string) (*SecurityReport, error) {
 		return nil, fmt.Errorf("missing csp-report key")
 	}
 
+	// Legacy reports carry no age, so they happened when they arrived.
+	now := bigquery.NullDateTime{DateTime: civil.DateTimeOf(time.Now()), Valid: true}
 	body := wrapper.CSPReport
 	return &SecurityReport{
 		CSP: &CSPReport{
@@ -298,7 +459,8 @@ func ParseLegacyCSPReport(data, srv string) (*SecurityReport, error) {
 		},
 		ReportType: bigquery.NullString{StringVal: "csp-violation", Valid: true},
 		RawJSON:    data,
-		Time:       bigquery.NullDateTime{DateTime: civil.DateTimeOf(time.Now()), Valid: true},
+		Time:       now,
+		OccurredAt: now,
 		Service:    bigquery.NullString{StringVal: srv, Valid: true},
 	}, nil
 }
@@ -309,9 +471,19 @@ func WriteReportsToBigQuery(ctx context.Context, project, dataset, table string,
 	if err != nil {
 		return fmt.Errorf("connecting to bq: %w", err)
 	}
+	defer func() { _ = bq.Close() }()
+
+	return InsertReports(ctx, bq, dataset, table, []*SecurityReport{report})
+}
 
+// InsertReports streams reports into dataset.table using an existing
+// client, so long-lived callers can reuse one connection. Rows the table
+// rejects are skipped and listed in a bigquery.PutMultiError; the rest
+// are still inserted.
+func InsertReports(ctx context.Context, bq *bigquery.Client, dataset, table string, reports []*SecurityReport) error {
 	ins := bq.Dataset(dataset).Table(table).Inserter()
-	if err := ins.Put(ctx, report); err != nil {
+	ins.SkipInvalidRows = true
+	if err := ins.Put(ctx, reports); err != nil {
 		return fmt.Errorf("uploading to bq: %w", err)
 	}
 	return nil
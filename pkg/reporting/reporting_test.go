@@ -1,10 +1,14 @@
 package reporting
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/icco/reportd/pkg/lib"
 )
 
 func TestGetReportSchema(t *testing.T) {
@@ -17,6 +21,20 @@ func TestGetReportSchema(t *testing.T) {
 	}
 }
 
+// parseSingle parses a one-object payload and fails the test unless it
+// yields exactly one report.
+func parseSingle(t *testing.T, body, srv string) *SecurityReport {
+	t.Helper()
+	reports, err := ParseReport(body, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(reports))
+	}
+	return reports[0]
+}
+
 func TestParseReportAllExamples(t *testing.T) {
 	files, err := os.ReadDir("./examples")
 	if err != nil {
@@ -32,25 +50,27 @@ func TestParseReportAllExamples(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			data, err := ParseReport(string(body), "test")
+			reports, err := ParseReport(string(body), "test")
 			if err != nil {
 				t.Fatalf("ParseReport returned error: %v", err)
 			}
 
-			if data == nil {
-				t.Fatal("ParseReport returned nil")
+			if len(reports) == 0 {
+				t.Fatal("ParseReport returned no reports")
 			}
 
-			if data.Service.String() != "test" {
-				t.Errorf("expected service 'test', got %q", data.Service.StringVal)
-			}
+			for _, data := range reports {
+				if data.Service.String() != "test" {
+					t.Errorf("expected service 'test', got %q", data.Service.StringVal)
+				}
 
-			if !data.ReportType.Valid || data.ReportType.StringVal == "" {
-				t.Error("ReportType should not be empty")
-			}
+				if !data.ReportType.Valid || data.ReportType.StringVal == "" {
+					t.Error("ReportType should not be empty")
+				}
 
-			if data.RawJSON == "" {
-				t.Error("RawJSON should not be empty")
+				if data.RawJSON == "" {
+					t.Error("RawJSON should not be empty")
+				}
 			}
 		})
 	}
@@ -71,10 +91,7 @@ func TestParseCSPViolation(t *testing.T) {
 		}
 	}`
 
-	data, err := ParseReport(body, "mysite")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "mysite")
 
 	if data.ReportType.StringVal != "csp-violation" {
 		t.Errorf("expected type 'csp-violation', got %q", data.ReportType.StringVal)
@@ -100,6 +117,7 @@ func TestParseDeprecation(t *testing.T) {
 	body := `{
 		"type": "deprecation",
 		"url": "https://example.com/",
+		"user_agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.4; rv:125.0) Gecko/20100101 Firefox/125.0",
 		"body": {
 			"id": "websql",
 			"message": "WebSQL is deprecated",
@@ -109,10 +127,7 @@ func TestParseDeprecation(t *testing.T) {
 		}
 	}`
 
-	data, err := ParseReport(body, "mysite")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "mysite")
 
 	if data.ReportType.StringVal != "deprecation" {
 		t.Errorf("expected type 'deprecation', got %q", data.ReportType.StringVal)
@@ -126,6 +141,29 @@ func TestParseDeprecation(t *testing.T) {
 	if data.Deprecation.Body.Message.StringVal != "WebSQL is deprecated" {
 		t.Errorf("expected message 'WebSQL is deprecated', got %q", data.Deprecation.Body.Message.StringVal)
 	}
+	if data.Agent == nil || data.Agent.Family != "firefox" || data.Agent.Major != 125 {
+		t.Errorf("expected a Firefox 125 agent from user_agent, got %+v", data.Agent)
+	}
+}
+
+func TestParseReportAge(t *testing.T) {
+	for _, tc := range []struct {
+		age  string
+		want time.Duration
+	}{
+		{`"age": 90000,`, 90 * time.Second},
+		{``, 0},
+		{`"age": -5000,`, 0},
+		{`"age": 864000000,`, lib.MaxReportAge},
+	} {
+		data := parseSingle(t, `{"type": "deprecation", `+tc.age+` "body": {}}`, "mysite")
+		if !data.Time.Valid || !data.OccurredAt.Valid {
+			t.Fatalf("age %q: time = %v, occurred_at = %v; want both set", tc.age, data.Time, data.OccurredAt)
+		}
+		if got := data.Time.DateTime.In(time.UTC).Sub(data.OccurredAt.DateTime.In(time.UTC)); got != tc.want {
+			t.Errorf("age %q: time - occurred_at = %v, want %v", tc.age, got, tc.want)
+		}
+	}
 }
 
 func TestParsePermissionsPolicy(t *testing.T) {
@@ -142,10 +180,7 @@ func TestParsePermissionsPolicy(t *testing.T) {
 		}
 	}`
 
-	data, err := ParseReport(body, "mysite")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "mysite")
 
 	if data.ReportType.StringVal != "permissions-policy-violation" {
 		t.Errorf("expected type 'permissions-policy-violation', got %q", data.ReportType.StringVal)
@@ -177,10 +212,7 @@ func TestParseIntervention(t *testing.T) {
 		}
 	}`
 
-	data, err := ParseReport(body, "mysite")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "mysite")
 
 	if data.ReportType.StringVal != "intervention" {
 		t.Errorf("expected type 'intervention', got %q", data.ReportType.StringVal)
@@ -205,10 +237,7 @@ func TestParseCrash(t *testing.T) {
 		}
 	}`
 
-	data, err := ParseReport(body, "mysite")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "mysite")
 
 	if data.ReportType.StringVal != "crash" {
 		t.Errorf("expected type 'crash', got %q", data.ReportType.StringVal)
@@ -233,10 +262,7 @@ func TestParseCOEP(t *testing.T) {
 		}
 	}`
 
-	data, err := ParseReport(body, "mysite")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "mysite")
 
 	if data.ReportType.StringVal != "coep" {
 		t.Errorf("expected type 'coep', got %q", data.ReportType.StringVal)
@@ -265,10 +291,7 @@ func TestParseCOOP(t *testing.T) {
 		}
 	}`
 
-	data, err := ParseReport(body, "mysite")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "mysite")
 
 	if data.ReportType.StringVal != "coop" {
 		t.Errorf("expected type 'coop', got %q", data.ReportType.StringVal)
@@ -298,10 +321,7 @@ func TestParseDocumentPolicy(t *testing.T) {
 		}
 	}`
 
-	data, err := ParseReport(body, "mysite")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "mysite")
 
 	if data.ReportType.StringVal != "document-policy-violation" {
 		t.Errorf("expected type 'document-policy-violation', got %q", data.ReportType.StringVal)
@@ -317,6 +337,74 @@ func TestParseDocumentPolicy(t *testing.T) {
 	}
 }
 
+func TestParseNetworkError(t *testing.T) {
+	body := `{
+		"type": "network-error",
+		"url": "https://example.com/data.json",
+		"body": {
+			"elapsed_time": 30076,
+			"method": "GET",
+			"phase": "application",
+			"protocol": "h2",
+			"referrer": "https://example.com/",
+			"sampling_fraction": 0.5,
+			"server_ip": "192.0.2.1",
+			"status_code": 502,
+			"type": "http.error"
+		}
+	}`
+
+	data := parseSingle(t, body, "mysite")
+
+	if data.NEL == nil {
+		t.Fatal("NEL should not be nil")
+	}
+	b := data.NEL.Body
+	if b.Type != "http.error" {
+		t.Errorf("type = %q, want http.error", b.Type)
+	}
+	if b.Phase != "application" {
+		t.Errorf("phase = %q, want application", b.Phase)
+	}
+	if b.ServerIP != "192.0.2.1" {
+		t.Errorf("server_ip = %q", b.ServerIP)
+	}
+	if b.SamplingFraction != 0.5 {
+		t.Errorf("sampling_fraction = %v, want 0.5", b.SamplingFraction)
+	}
+	if b.ElapsedTime != 30076 || b.StatusCode != 502 || b.Method != "GET" || b.Protocol != "h2" {
+		t.Errorf("unexpected body: %+v", b)
+	}
+}
+
+func TestNELHeader(t *testing.T) {
+	got, err := NELHeader(NELPolicy{ReportTo: "default", MaxAge: 86400, SuccessFraction: 0.01, FailureFraction: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"report_to":"default","max_age":86400,"success_fraction":0.01,"failure_fraction":1}`
+	if got != want {
+		t.Errorf("NELHeader() = %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		name string
+		p    NELPolicy
+	}{
+		{name: "missing group", p: NELPolicy{MaxAge: 1}},
+		{name: "negative max_age", p: NELPolicy{ReportTo: "default", MaxAge: -1}},
+		{name: "success fraction too large", p: NELPolicy{ReportTo: "default", SuccessFraction: 1.5}},
+		{name: "negative failure fraction", p: NELPolicy{ReportTo: "default", FailureFraction: -0.1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NELHeader(tc.p); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
 func TestParseUnknownType(t *testing.T) {
 	body := `{
 		"type": "some-future-type",
@@ -324,10 +412,7 @@ func TestParseUnknownType(t *testing.T) {
 		"body": { "foo": "bar" }
 	}`
 
-	data, err := ParseReport(body, "mysite")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "mysite")
 
 	if data.ReportType.StringVal != "some-future-type" {
 		t.Errorf("expected type 'some-future-type', got %q", data.ReportType.StringVal)
@@ -360,12 +445,7 @@ func TestParseReportEdgeCases(t *testing.T) {
 			service: "test",
 			wantErr: false, // json.Unmarshal(null, &struct{}) succeeds with zero values
 		},
-		{
-			name:    "JSON array",
-			body:    `[{"type":"csp-violation"}]`,
-			service: "test",
-			wantErr: true,
-		},
+
 		{
 			name:    "just a string",
 			body:    `"hello"`,
@@ -549,16 +629,17 @@ func TestParseReportEdgeCases(t *testing.T) {
 
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			data, err := ParseReport(tc.body, tc.service)
+			reports, err := ParseReport(tc.body, tc.service)
 			if (err != nil) != tc.wantErr {
 				t.Errorf("ParseReport() error = %v, wantErr %v", err, tc.wantErr)
 				return
 			}
 			if err == nil {
-				if data == nil {
-					t.Error("expected non-nil data when no error")
+				if len(reports) != 1 {
+					t.Errorf("expected 1 report when no error, got %d", len(reports))
 					return
 				}
+				data := reports[0]
 				if data.RawJSON != tc.body {
 					t.Errorf("RawJSON should preserve original body")
 				}
@@ -576,10 +657,7 @@ func TestParseReportEdgeCases(t *testing.T) {
 func TestParseReportCaseSensitiveTypes(t *testing.T) {
 	// Verify that type matching is case-sensitive (uppercase should NOT match known types)
 	body := `{"type":"CSP-VIOLATION","url":"https://example.com/","body":{"blocked_uri":"https://evil.com/"}}`
-	data, err := ParseReport(body, "test")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "test")
 	// Should be treated as unknown — CSP field should remain nil
 	if data.CSP != nil {
 		t.Error("uppercase CSP-VIOLATION should not match csp-violation handler")
@@ -604,6 +682,9 @@ func TestParseLegacyCSPReport(t *testing.T) {
 	if !data.Time.Valid {
 		t.Error("time should be set")
 	}
+	if data.OccurredAt != data.Time {
+		t.Errorf("occurred_at = %v, want the receive time %v", data.OccurredAt, data.Time)
+	}
 	if data.RawJSON != body {
 		t.Error("RawJSON should exactly match the input body")
 	}
@@ -670,10 +751,7 @@ func TestParseLegacyCSPReportErrors(t *testing.T) {
 
 func TestParseReportPreservesRawJSON(t *testing.T) {
 	body := `{"type":"csp-violation","url":"https://example.com/","body":{"document_uri":"https://example.com/"}}`
-	data, err := ParseReport(body, "test")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "test")
 	if data.RawJSON != body {
 		t.Error("RawJSON should exactly match the input body")
 	}
@@ -682,10 +760,7 @@ func TestParseReportPreservesRawJSON(t *testing.T) {
 func TestParseReportDeprecationNullStringFields(t *testing.T) {
 	// Test that deprecation NullString fields are properly populated
 	body := `{"type":"deprecation","url":"https://example.com/","body":{"id":"websql","anticipated_removal":"2025-01-01","message":"deprecated"}}`
-	data, err := ParseReport(body, "test")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "test")
 	if !data.Deprecation.Body.ID.Valid || data.Deprecation.Body.ID.StringVal != "websql" {
 		t.Errorf("expected id 'websql', got %+v", data.Deprecation.Body.ID)
 	}
@@ -700,10 +775,7 @@ func TestParseReportDeprecationNullStringFields(t *testing.T) {
 func TestParseReportDeprecationEmptyNullFields(t *testing.T) {
 	// When deprecation fields are missing, NullString should be invalid
 	body := `{"type":"deprecation","url":"https://example.com/","body":{}}`
-	data, err := ParseReport(body, "test")
-	if err != nil {
-		t.Fatal(err)
-	}
+	data := parseSingle(t, body, "test")
 	if data.Deprecation.Body.ID.Valid {
 		t.Error("id should not be valid when missing")
 	}
@@ -714,3 +786,88 @@ func TestParseReportDeprecationEmptyNullFields(t *testing.T) {
 		t.Error("message should not be valid when missing")
 	}
 }
+
+func TestParseReportBatch(t *testing.T) {
+	body := `[
+		{"type":"csp-violation","url":"https://example.com/","body":{"blocked_uri":"https://evil.com/"}},
+		{"type":"deprecation","url":"https://example.com/","body":{"id":"websql"}},
+		{"type":"crash","url":"https://example.com/","body":{"reason":"oom"}}
+	]`
+
+	reports, err := ParseReport(body, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("expected 3 reports, got %d", len(reports))
+	}
+	if reports[0].CSP == nil || reports[1].Deprecation == nil || reports[2].Crash == nil {
+		t.Errorf("reports decoded into wrong types: %+v", reports)
+	}
+	for i, r := range reports {
+		if r.Service.StringVal != "test" {
+			t.Errorf("report %d: expected service 'test', got %q", i, r.Service.StringVal)
+		}
+		if !strings.HasPrefix(r.RawJSON, "{") {
+			t.Errorf("report %d: RawJSON should hold only the element, got %q", i, r.RawJSON)
+		}
+	}
+}
+
+func TestParseReportBatchPartialFailure(t *testing.T) {
+	body := `[
+		{"type":"crash","url":"https://example.com/","body":{"reason":"oom"}},
+		{"type":42},
+		"garbage",
+		{"type":"coop","url":"https://example.com/","body":{}}
+	]`
+
+	reports, err := ParseReport(body, "test")
+	if err == nil {
+		t.Fatal("expected an error for the malformed elements")
+	}
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 good reports, got %d", len(reports))
+	}
+	if reports[0].Crash == nil || reports[1].COOP == nil {
+		t.Errorf("good elements decoded into wrong types: %+v", reports)
+	}
+
+	var elemErr *ElementError
+	if !errors.As(err, &elemErr) {
+		t.Fatalf("error should wrap *ElementError, got %T", err)
+	}
+	if elemErr.Index != 1 {
+		t.Errorf("first failing element index = %d, want 1", elemErr.Index)
+	}
+	if !strings.Contains(err.Error(), "report 2:") {
+		t.Errorf("error should mention every failing element, got %q", err.Error())
+	}
+}
+
+func TestParseReportBatchEdgeCases(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantCount int
+		wantErr   bool
+	}{
+		{name: "empty array", body: `[]`, wantCount: 0},
+		{name: "leading whitespace", body: "\n  [{\"type\":\"crash\",\"body\":{}}]", wantCount: 1},
+		{name: "truncated array", body: `[{"type":"crash"}`, wantErr: true},
+		{name: "all elements bad", body: `[1, 2]`, wantCount: 0, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			reports, err := ParseReport(tc.body, "test")
+			if (err != nil) != tc.wantErr {
+				t.Errorf("ParseReport() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if len(reports) != tc.wantCount {
+				t.Errorf("got %d reports, want %d", len(reports), tc.wantCount)
+			}
+		})
+	}
+}
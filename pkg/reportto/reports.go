@@ -5,12 +5,16 @@ package reportto
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"mime"
 	"time"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
+
+	"github.com/icco/reportd/pkg/lib"
+	"github.com/icco/reportd/pkg/useragent"
 )
 
 // Content-Type values accepted by ParseReport.
@@ -20,6 +24,10 @@ const (
 	ContentTypeCSPReport      = "application/csp-report"
 )
 
+// ErrUnsupportedContentType is returned by ParseReport when ct is not one
+// of the ContentType* constants.
+var ErrUnsupportedContentType = errors.New("unsupported content-type")
+
 // Report is the parsed envelope returned by ParseReport; exactly one of
 // ExpectCT, CSP, or ReportTo is populated.
 type Report struct {
@@ -27,6 +35,10 @@ type Report struct {
 	CSP      *CSPReport      `bigquery:",nullable"`
 	ReportTo []*Entry
 
+	// Agent is the browser that delivered the report, parsed from the
+	// request's User-Agent header. Entries also carry their own.
+	Agent *useragent.Agent `bigquery:",nullable"`
+
 	Time bigquery.NullDateTime
 
 	Service bigquery.NullString
@@ -61,6 +73,36 @@ type ExpectCTSubReport struct {
 	ValidatedCertificateChain []string  `json:"validated-certificate-chain"`
 }
 
+// Report types of the legacy envelopes, as stored. Report-To entries
+// carry their own.
+const (
+	TypeCSP      = "csp"
+	TypeExpectCT = "expect-ct"
+)
+
+// FilterByURL drops the entries of r whose page URL keep rejects. It
+// returns false if that drops all of r, leaving nothing to store. A CSP
+// report's URL is its document URI and an Expect-CT report's is its
+// hostname over https.
+func (r *Report) FilterByURL(keep func(url string) bool) bool {
+	switch {
+	case r.CSP != nil:
+		return keep(r.CSP.CSPReport.DocumentURI)
+	case r.ExpectCT != nil:
+		host := r.ExpectCT.ExpectCTReport.Hostname
+		return host != "" && keep("https://"+host)
+	}
+	n := len(r.ReportTo)
+	kept := r.ReportTo[:0]
+	for _, e := range r.ReportTo {
+		if e != nil && keep(e.URL) {
+			kept = append(kept, e)
+		}
+	}
+	r.ReportTo = kept
+	return len(kept) > 0 || n == 0
+}
+
 // CSPReport carries a Content-Security-Policy violation; see
 // https://www.w3.org/TR/CSP3/#violation.
 type CSPReport struct {
@@ -87,7 +129,12 @@ type Entry struct {
 	Age       int    `json:"age"`
 	URL       string `json:"url"`
 	UserAgent string `json:"user_agent"`
-	Body      struct {
+
+	// OccurredAt is when the browser saw the event: the report's Time
+	// less Age, as lib.OccurredAt bounds it. Set by ParseReport.
+	OccurredAt bigquery.NullDateTime `json:"-"`
+
+	Body struct {
 		AnticipatedRemoval float64 `json:"anticipatedRemoval,omitempty"`
 		Blocked            string  `json:"blocked,omitempty"`
 		BlockedURL         string  `json:"blockedURL,omitempty"`
@@ -119,12 +166,13 @@ type Entry struct {
 // ParseReport decodes body according to the Content-Type ct (one of the
 // ContentType* constants), scopes it to service srv, and validates it.
 func ParseReport(ct, body, srv string) (*Report, error) {
-	now := bigquery.NullDateTime{DateTime: civil.DateTimeOf(time.Now()), Valid: true}
+	received := time.Now()
+	now := bigquery.NullDateTime{DateTime: civil.DateTimeOf(received), Valid: true}
 	service := bigquery.NullString{StringVal: srv, Valid: true}
 
 	media, _, err := mime.ParseMediaType(ct)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedContentType, err)
 	}
 
 	var r *Report
@@ -135,6 +183,13 @@ func ParseReport(ct, body, srv string) (*Report, error) {
 		if err := json.Unmarshal([]byte(body), &data); err != nil {
 			return nil, err
 		}
+		for _, e := range data {
+			if e == nil {
+				continue
+			}
+			at := lib.OccurredAt(received, int64(e.Age))
+			e.OccurredAt = bigquery.NullDateTime{DateTime: civil.DateTimeOf(at), Valid: true}
+		}
 		r = &Report{ReportTo: data, Time: now, Service: service}
 	case ContentTypeExpectCTReport:
 		var data ExpectCTReport
@@ -149,7 +204,7 @@ func ParseReport(ct, body, srv string) (*Report, error) {
 		}
 		r = &Report{CSP: &data, Time: now, Service: service}
 	default:
-		return nil, fmt.Errorf("%q is not a valid content-type", media)
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedContentType, media)
 	}
 
 	return r, r.Validate()
@@ -191,8 +246,18 @@ func WriteReportToBigQuery(ctx context.Context, project, dataset, table string,
 	if err != nil {
 		return fmt.Errorf("connecting to bq: %w", err)
 	}
+	defer func() { _ = client.Close() }()
+
+	return InsertReports(ctx, client, dataset, table, reports)
+}
 
+// InsertReports streams reports into dataset.table using an existing
+// client, so long-lived callers can reuse one connection. Rows the table
+// rejects are skipped and listed in a bigquery.PutMultiError; the rest
+// are still inserted.
+func InsertReports(ctx context.Context, client *bigquery.Client, dataset, table string, reports []*Report) error {
 	ins := client.Dataset(dataset).Table(table).Inserter()
+	ins.SkipInvalidRows = true
 	if err := ins.Put(ctx, reports); err != nil {
 		return fmt.Errorf("uploading to bq: %w", err)
 	}
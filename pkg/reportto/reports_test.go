@@ -1,10 +1,12 @@
 package reportto
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/bigquery"
 )
@@ -97,6 +99,27 @@ func TestParseReportParsesReportTo(t *testing.T) {
 	}
 }
 
+func TestParseReportEntryAge(t *testing.T) {
+	body := `[{"type":"csp-violation","age":12000,"body":{}},{"type":"deprecation","body":{}}]`
+	data, err := ParseReport(ContentTypeReports, body, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.ReportTo) != 2 {
+		t.Fatalf("got %d entries, want 2", len(data.ReportTo))
+	}
+	received := data.Time.DateTime.In(time.UTC)
+	for i, want := range []time.Duration{12 * time.Second, 0} {
+		at := data.ReportTo[i].OccurredAt
+		if !at.Valid {
+			t.Fatalf("entry %d: occurred_at not set", i)
+		}
+		if got := received.Sub(at.DateTime.In(time.UTC)); got != want {
+			t.Errorf("entry %d: time - occurred_at = %v, want %v", i, got, want)
+		}
+	}
+}
+
 func TestParseReportEdgeCases(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -270,6 +293,17 @@ func TestParseReportEdgeCases(t *testing.T) {
 	}
 }
 
+func TestParseReportUnsupportedContentType(t *testing.T) {
+	for _, ct := range []string{"", ";;;", "text/plain", "application/json"} {
+		if _, err := ParseReport(ct, "{}", "test"); !errors.Is(err, ErrUnsupportedContentType) {
+			t.Errorf("ParseReport(%q) error = %v, want ErrUnsupportedContentType", ct, err)
+		}
+	}
+	if _, err := ParseReport(ContentTypeCSPReport, "not json", "test"); errors.Is(err, ErrUnsupportedContentType) {
+		t.Errorf("malformed body reported as unsupported content-type: %v", err)
+	}
+}
+
 func TestReportValidate(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -305,3 +339,27 @@ func TestReportValidate(t *testing.T) {
 func bqStr(s string) bigquery.NullString {
 	return bigquery.NullString{StringVal: s, Valid: true}
 }
+
+func TestReportFilterByURL(t *testing.T) {
+	keep := func(url string) bool { return strings.HasPrefix(url, "https://example.com/") }
+
+	body := `[{"type":"csp","url":"https://example.com/a","body":{}},{"type":"csp","url":"https://evil.com/","body":{}}]`
+	r, err := ParseReport(ContentTypeReports, body, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.FilterByURL(keep) || len(r.ReportTo) != 1 || r.ReportTo[0].URL != "https://example.com/a" {
+		t.Errorf("FilterByURL kept %+v, want only the example.com entry", r.ReportTo)
+	}
+	if r.FilterByURL(func(string) bool { return false }) {
+		t.Error("FilterByURL() = true with every entry dropped")
+	}
+
+	csp, err := ParseReport(ContentTypeCSPReport, `{"csp-report":{"document-uri":"https://evil.com/"}}`, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if csp.FilterByURL(keep) {
+		t.Error("FilterByURL() = true for a CSP report from a disallowed page")
+	}
+}
@@ -0,0 +1,130 @@
+// Package routes normalizes page URLs into route templates such as
+// /posts/:id so Web Vitals can be grouped per route rather than per URL.
+package routes
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hashSegment    = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+)
+
+// Normalizer maps URL paths onto route templates. Configured patterns are
+// tried in order; paths matching none have numeric, UUID and long hex
+// segments replaced with :id, :uuid and :hash. The zero value (and a nil
+// *Normalizer) only applies those fallbacks.
+type Normalizer struct {
+	patterns [][]string
+}
+
+// NewNormalizer parses patterns such as "/posts/:id" or "/docs/*". A
+// segment starting with ":" matches any single segment; a trailing "*"
+// matches the rest of the path.
+func NewNormalizer(patterns []string) (*Normalizer, error) {
+	n := &Normalizer{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("route pattern %q must start with /", p)
+		}
+		segs := splitPath(p)
+		for i, s := range segs {
+			if s == "*" && i != len(segs)-1 {
+				return nil, fmt.Errorf("route pattern %q: * is only allowed as the last segment", p)
+			}
+			if s == ":" {
+				return nil, fmt.Errorf("route pattern %q: parameter needs a name", p)
+			}
+		}
+		n.patterns = append(n.patterns, segs)
+	}
+	return n, nil
+}
+
+// Page returns raw without its query string and fragment, which often
+// carry tokens or other per-user data. Unparseable input returns "".
+func Page(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return ""
+	}
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.User = nil
+	return u.String()
+}
+
+// Normalize returns the route template for raw, which may be an absolute
+// URL or a bare path. It returns "" if raw has no usable path.
+func (n *Normalizer) Normalize(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+
+	segs := splitPath(u.Path)
+	if n != nil {
+		for _, p := range n.patterns {
+			if match(p, segs) {
+				return "/" + strings.Join(p, "/")
+			}
+		}
+	}
+
+	for i, s := range segs {
+		switch {
+		case numericSegment.MatchString(s):
+			segs[i] = ":id"
+		case uuidSegment.MatchString(s):
+			segs[i] = ":uuid"
+		case hashSegment.MatchString(s):
+			segs[i] = ":hash"
+		}
+	}
+	return "/" + strings.Join(segs, "/")
+}
+
+func match(pattern, segs []string) bool {
+	for i, p := range pattern {
+		if p == "*" {
+			return true
+		}
+		if i >= len(segs) {
+			return false
+		}
+		if !strings.HasPrefix(p, ":") && p != segs[i] {
+			return false
+		}
+	}
+	return len(pattern) == len(segs)
+}
+
+// splitPath splits p into its non-empty segments, so "/a//b/" and "/a/b"
+// normalize alike.
+func splitPath(p string) []string {
+	var segs []string
+	for s := range strings.SplitSeq(p, "/") {
+		if s != "" {
+			segs = append(segs, s)
+		}
+	}
+	return segs
+}
@@ -0,0 +1,284 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/icco/gutil/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+// BatchOptions tunes a batching sink. Zero fields take the defaults
+// noted on each.
+type BatchOptions struct {
+	// MaxBatch flushes once this many events are buffered. Default 500.
+	MaxBatch int
+	// FlushInterval flushes a partial batch this long after the previous
+	// flush. Default 5s.
+	FlushInterval time.Duration
+	// QueueSize bounds events waiting to be batched; beyond it new events
+	// are spilled to the spool, or dropped without one. Default 10000.
+	QueueSize int
+	// MaxRetries is how many times a failed flush is retried before its
+	// events are spilled or dropped. Default 5; negative disables retries.
+	MaxRetries int
+	// InitialBackoff is the first retry delay, doubled per attempt up to
+	// MaxBackoff. Defaults 500ms and 30s.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func (o BatchOptions) withDefaults() BatchOptions {
+	if o.MaxBatch <= 0 {
+		o.MaxBatch = 500
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = 5 * time.Second
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 10000
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	} else if o.MaxRetries == 0 {
+		o.MaxRetries = 5
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = 500 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 30 * time.Second
+	}
+	return o
+}
+
+// ErrQueueFull is returned by a batching sink's Write when its queue is
+// full and events were dropped.
+var ErrQueueFull = errors.New("sink queue full")
+
+// permanentError marks a flush failure that retrying cannot fix, such as
+// rows rejected by the destination's schema.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so batchers drop the batch instead of retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// rejectedError marks a flush that delivered everything but events,
+// which the destination refused for good.
+type rejectedError struct {
+	events []Event
+	err    error
+}
+
+func (e *rejectedError) Error() string { return e.err.Error() }
+func (e *rejectedError) Unwrap() error { return e.err }
+
+// Rejected wraps err to say a flush delivered every event except events,
+// so batchers drop only those instead of retrying the batch.
+func Rejected(events []Event, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &rejectedError{events: events, err: err}
+}
+
+// batcher buffers events on a bounded queue and hands them to flush in
+// batches from a single goroutine, retrying failures with exponential
+// backoff. Events it gives up on go to spill, when set, before being
+// counted as dropped.
+type batcher struct {
+	name  string
+	opts  BatchOptions
+	flush func(ctx context.Context, events []Event) error
+	// spill is set before the first enqueue and not changed after.
+	spill func(events []Event) error
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan Event
+
+	// ctx is canceled when Close gives up, aborting retries.
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	dropped metric.Int64Counter
+	retries metric.Int64Counter
+}
+
+func newBatcher(name string, opts BatchOptions, flush func(ctx context.Context, events []Event) error) *batcher {
+	opts = opts.withDefaults()
+	meter := otel.Meter(meterName)
+	dropped, _ := meter.Int64Counter("reportd.sink.dropped",
+		metric.WithDescription("Events a sink discarded, by reason."))
+	retries, _ := meter.Int64Counter("reportd.sink.retries",
+		metric.WithDescription("Batch flushes retried after a failure."))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &batcher{
+		name:    name,
+		opts:    opts,
+		flush:   flush,
+		queue:   make(chan Event, opts.QueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		dropped: dropped,
+		retries: retries,
+	}
+	go b.run()
+	return b
+}
+
+// enqueue adds events without blocking. Events that do not fit are
+// spilled; ErrQueueFull is returned only if they had to be dropped.
+func (b *batcher) enqueue(events []Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		if b.abandon(events, "closed") {
+			return nil
+		}
+		return fmt.Errorf("sink %s is closed", b.name)
+	}
+	for i, e := range events {
+		select {
+		case b.queue <- e:
+		default:
+			if b.abandon(events[i:], "queue_full") {
+				return nil
+			}
+			return fmt.Errorf("%s: dropped %d events: %w", b.name, len(events)-i, ErrQueueFull)
+		}
+	}
+	return nil
+}
+
+func (b *batcher) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.opts.FlushInterval)
+	defer ticker.Stop()
+
+	buf := make([]Event, 0, b.opts.MaxBatch)
+	for {
+		select {
+		case e, ok := <-b.queue:
+			if !ok {
+				if len(buf) > 0 {
+					b.send(buf)
+				}
+				return
+			}
+			buf = append(buf, e)
+			if len(buf) >= b.opts.MaxBatch {
+				b.send(buf)
+				buf = make([]Event, 0, b.opts.MaxBatch)
+			}
+		case <-ticker.C:
+			if len(buf) > 0 {
+				b.send(buf)
+				buf = make([]Event, 0, b.opts.MaxBatch)
+			}
+		}
+	}
+}
+
+// send flushes batch, retrying with jittered exponential backoff.
+func (b *batcher) send(batch []Event) {
+	l := logging.FromContext(b.ctx)
+	backoff := b.opts.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := b.flush(b.ctx, batch)
+		if err == nil {
+			return
+		}
+
+		var (
+			perm *permanentError
+			rej  *rejectedError
+		)
+		switch {
+		case errors.As(err, &rej):
+			l.Errorw("sink rejected events", zap.Error(err), "sink", b.name, "events", len(batch), "rejected", len(rej.events))
+			b.drop(rej.events, "rejected")
+			return
+		case errors.As(err, &perm):
+			l.Errorw("sink rejected batch", zap.Error(err), "sink", b.name, "events", len(batch))
+			b.drop(batch, "rejected")
+			return
+		case attempt >= b.opts.MaxRetries:
+			l.Errorw("sink batch failed after retries", zap.Error(err), "sink", b.name, "events", len(batch), "attempts", attempt+1)
+			b.abandon(batch, "retries_exhausted")
+			return
+		}
+
+		l.Warnw("sink batch failed, retrying", zap.Error(err), "sink", b.name, "events", len(batch), "attempt", attempt+1, "backoff", backoff)
+		b.retries.Add(b.ctx, 1, metric.WithAttributes(attribute.String("sink", b.name)))
+
+		// Jitter keeps replicas from retrying in lockstep.
+		wait := time.Duration(rand.Int64N(int64(backoff))) + backoff/2
+		select {
+		case <-time.After(wait):
+		case <-b.ctx.Done():
+			l.Errorw("sink shut down before batch was delivered", zap.Error(err), "sink", b.name, "events", len(batch))
+			b.abandon(batch, "shutdown")
+			return
+		}
+		backoff = min(backoff*2, b.opts.MaxBackoff)
+	}
+}
+
+// abandon hands undeliverable events to spill, which accounts for any
+// it cannot keep, or else drops them under reason. It reports whether
+// they were spilled.
+func (b *batcher) abandon(events []Event, reason string) bool {
+	if b.spill != nil {
+		return b.spill(events) == nil
+	}
+	b.drop(events, reason)
+	return false
+}
+
+func (b *batcher) drop(events []Event, reason string) {
+	addPerKind(context.Background(), b.dropped, events,
+		attribute.String("sink", b.name),
+		attribute.String("reason", reason),
+	)
+}
+
+// close stops accepting events and flushes what is queued. If ctx ends
+// first, pending retries are abandoned and their events spilled or
+// dropped.
+func (b *batcher) close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		b.cancel()
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		<-b.done
+		return fmt.Errorf("flushing %s: %w", b.name, ctx.Err())
+	}
+}
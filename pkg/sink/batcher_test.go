@@ -0,0 +1,208 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flushRecorder collects the batches a batcher flushes. The first
+// failures calls return err instead, and every call waits on block if set.
+type flushRecorder struct {
+	mu       sync.Mutex
+	batches  [][]Event
+	calls    int
+	failures int
+	err      error
+	block    chan struct{}
+}
+
+func (r *flushRecorder) flush(_ context.Context, events []Event) error {
+	if r.block != nil {
+		<-r.block
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.calls <= r.failures {
+		return r.err
+	}
+	r.batches = append(r.batches, append([]Event(nil), events...))
+	return nil
+}
+
+func (r *flushRecorder) snapshot() (calls int, batches [][]Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls, append([][]Event(nil), r.batches...)
+}
+
+func fastOptions() BatchOptions {
+	return BatchOptions{
+		MaxBatch:       3,
+		FlushInterval:  time.Hour,
+		QueueSize:      10,
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+	}
+}
+
+func testEvents(n int) []Event {
+	out := make([]Event, n)
+	for i := range out {
+		out[i] = testVital("svc")
+	}
+	return out
+}
+
+func TestBatcherFlushesOnSize(t *testing.T) {
+	rec := &flushRecorder{}
+	b := newBatcher("test", fastOptions(), rec.flush)
+
+	if err := b.enqueue(testEvents(7)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, batches := rec.snapshot()
+	if len(batches) != 3 || len(batches[0]) != 3 || len(batches[1]) != 3 || len(batches[2]) != 1 {
+		t.Errorf("batch sizes = %v, want [3 3 1]", batchSizes(batches))
+	}
+}
+
+func TestBatcherFlushesOnInterval(t *testing.T) {
+	rec := &flushRecorder{}
+	opts := fastOptions()
+	opts.FlushInterval = 10 * time.Millisecond
+	b := newBatcher("test", opts, rec.flush)
+	defer func() { _ = b.close(context.Background()) }()
+
+	if err := b.enqueue(testEvents(1)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, batches := rec.snapshot(); len(batches) == 1 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("partial batch was not flushed on interval")
+}
+
+func TestBatcherRetries(t *testing.T) {
+	rec := &flushRecorder{failures: 2, err: errors.New("unavailable")}
+	b := newBatcher("test", fastOptions(), rec.flush)
+	if err := b.enqueue(testEvents(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	calls, batches := rec.snapshot()
+	if calls != 3 || len(batches) != 1 {
+		t.Errorf("calls = %d, batches = %d; want 3 calls delivering 1 batch", calls, len(batches))
+	}
+}
+
+func TestBatcherGivesUp(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{"retries exhausted", errors.New("unavailable"), 3},
+		{"permanent", Permanent(errors.New("bad row")), 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &flushRecorder{failures: 100, err: tc.err}
+			b := newBatcher("test", fastOptions(), rec.flush)
+			if err := b.enqueue(testEvents(1)); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if calls, batches := rec.snapshot(); calls != tc.wantCalls || len(batches) != 0 {
+				t.Errorf("calls = %d, batches = %d; want %d calls, none delivered", calls, len(batches), tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestBatcherDropsOnlyRejected(t *testing.T) {
+	events := testEvents(3)
+	var calls int
+	b := newBatcher("test", fastOptions(), func(_ context.Context, batch []Event) error {
+		calls++
+		return Rejected(batch[1:2], errors.New("row 1: invalid"))
+	})
+	var spilled []Event
+	b.spill = func(events []Event) error {
+		spilled = append(spilled, events...)
+		return nil
+	}
+	if err := b.enqueue(events); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || len(spilled) != 0 {
+		t.Errorf("calls = %d, spilled = %d; want 1 call and nothing spilled or retried", calls, len(spilled))
+	}
+}
+
+func TestBatcherQueueFull(t *testing.T) {
+	rec := &flushRecorder{block: make(chan struct{})}
+	opts := fastOptions()
+	opts.MaxBatch = 1
+	opts.QueueSize = 2
+	b := newBatcher("test", opts, rec.flush)
+
+	// One event is held by the blocked flush and two fill the queue, so
+	// some of the rest must be dropped.
+	err := b.enqueue(testEvents(10))
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("enqueue() error = %v, want ErrQueueFull", err)
+	}
+
+	close(rec.block)
+	if err := b.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.enqueue(testEvents(1)); err == nil {
+		t.Error("enqueue after close should fail")
+	}
+}
+
+func TestBatcherCloseTimeout(t *testing.T) {
+	rec := &flushRecorder{failures: 100, err: errors.New("unavailable")}
+	opts := fastOptions()
+	opts.MaxRetries = 100
+	opts.InitialBackoff = time.Hour
+	opts.MaxBackoff = time.Hour
+	b := newBatcher("test", opts, rec.flush)
+	if err := b.enqueue(testEvents(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("close() error = %v, want deadline exceeded", err)
+	}
+}
+
+func batchSizes(batches [][]Event) []int {
+	out := make([]int, len(batches))
+	for i, b := range batches {
+		out[i] = len(b)
+	}
+	return out
+}
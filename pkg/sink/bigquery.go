@@ -0,0 +1,210 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/icco/reportd/pkg/analytics"
+	"github.com/icco/reportd/pkg/reporting"
+	"github.com/icco/reportd/pkg/reportto"
+)
+
+// BigQueryConfig names the tables the bigquery sink writes each Kind to.
+// An empty table disables forwarding for that Kind.
+type BigQueryConfig struct {
+	Project        string
+	Dataset        string
+	AnalyticsTable string
+	ReportsTable   string
+	ReportsV2Table string
+
+	// Batch tunes the per-table insert batching.
+	Batch BatchOptions
+}
+
+// UpdateSchemas reconciles each configured table's schema with the Go
+// types, skipping tables left empty.
+func (c BigQueryConfig) UpdateSchemas(ctx context.Context) error {
+	var errs []error
+	if c.ReportsTable != "" {
+		if err := reportto.UpdateReportsBQSchema(ctx, c.Project, c.Dataset, c.ReportsTable); err != nil {
+			errs = append(errs, fmt.Errorf("table %s: %w", c.ReportsTable, err))
+		}
+	}
+	if c.AnalyticsTable != "" {
+		if err := analytics.UpdateAnalyticsBQSchema(ctx, c.Project, c.Dataset, c.AnalyticsTable); err != nil {
+			errs = append(errs, fmt.Errorf("table %s: %w", c.AnalyticsTable, err))
+		}
+	}
+	if c.ReportsV2Table != "" {
+		if err := reporting.UpdateReportsBQSchema(ctx, c.Project, c.Dataset, c.ReportsV2Table); err != nil {
+			errs = append(errs, fmt.Errorf("table %s: %w", c.ReportsV2Table, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func init() {
+	Register("bigquery", func(_ context.Context, _ string, cfg Config) (Sink, error) {
+		bq := cfg.BigQuery
+		if bq.Project == "" || bq.Dataset == "" {
+			return nil, fmt.Errorf("project and dataset are required")
+		}
+		if bq.AnalyticsTable == "" && bq.ReportsTable == "" && bq.ReportsV2Table == "" {
+			return nil, fmt.Errorf("at least one of analytics_table, reports_table or reports_v2_table is required")
+		}
+		return newBigQuerySink(bq), nil
+	})
+}
+
+// bigQuerySink streams each Kind into its own table through a long-lived
+// client, with one batcher per table so a failing table does not hold up
+// the others.
+type bigQuerySink struct {
+	cfg      BigQueryConfig
+	batchers map[Kind]*batcher
+
+	mu     sync.Mutex
+	client *bigquery.Client
+}
+
+func newBigQuerySink(cfg BigQueryConfig) *bigQuerySink {
+	s := &bigQuerySink{cfg: cfg, batchers: map[Kind]*batcher{}}
+	tables := map[Kind]string{
+		KindReportTo:       cfg.ReportsTable,
+		KindWebVital:       cfg.AnalyticsTable,
+		KindSecurityReport: cfg.ReportsV2Table,
+	}
+	for kind, table := range tables {
+		if table == "" {
+			continue
+		}
+		s.batchers[kind] = newBatcher("bigquery/"+table, cfg.Batch, func(ctx context.Context, events []Event) error {
+			return s.insert(ctx, table, events)
+		})
+	}
+	return s
+}
+
+func (s *bigQuerySink) Name() string { return "bigquery" }
+
+func (s *bigQuerySink) setSpill(spill func([]Event) error) {
+	for _, b := range s.batchers {
+		b.spill = spill
+	}
+}
+
+// Write queues events for their tables; events whose Kind has no table
+// are skipped.
+func (s *bigQuerySink) Write(_ context.Context, events []Event) error {
+	byKind := map[Kind][]Event{}
+	var errs []error
+	for _, e := range events {
+		if e.Kind == KindWebVital {
+			if err := e.WebVital.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("invalid web vital: %w", err))
+				continue
+			}
+		}
+		if _, ok := s.batchers[e.Kind]; ok {
+			byKind[e.Kind] = append(byKind[e.Kind], e)
+		}
+	}
+	for kind, batch := range byKind {
+		if err := s.batchers[kind].enqueue(batch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// bqClient returns the shared client, connecting on first use so a
+// missing credential surfaces as a retryable flush error rather than at
+// startup.
+func (s *bigQuerySink) bqClient(ctx context.Context) (*bigquery.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		c, err := bigquery.NewClient(ctx, s.cfg.Project)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to bq: %w", err)
+		}
+		s.client = c
+	}
+	return s.client, nil
+}
+
+// insert writes one table's batch. Rows the table rejects are dropped on
+// their own, since the rest of the batch was inserted; anything else is
+// retried by the batcher.
+func (s *bigQuerySink) insert(ctx context.Context, table string, events []Event) error {
+	client, err := s.bqClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		reports   []*reportto.Report
+		vitals    []*analytics.WebVital
+		secReport []*reporting.SecurityReport
+	)
+	for _, e := range events {
+		switch e.Kind {
+		case KindReportTo:
+			reports = append(reports, e.ReportTo)
+		case KindWebVital:
+			vitals = append(vitals, e.WebVital)
+		case KindSecurityReport:
+			secReport = append(secReport, e.SecurityReport)
+		}
+	}
+
+	switch {
+	case len(reports) > 0:
+		err = reportto.InsertReports(ctx, client, s.cfg.Dataset, table, reports)
+	case len(vitals) > 0:
+		err = analytics.InsertAnalytics(ctx, client, s.cfg.Dataset, table, vitals)
+	case len(secReport) > 0:
+		err = reporting.InsertReports(ctx, client, s.cfg.Dataset, table, secReport)
+	}
+	if err == nil {
+		return nil
+	}
+
+	var rowErrs bigquery.PutMultiError
+	if errors.As(err, &rowErrs) {
+		// Each table's batcher holds one Kind, so row i is events[i].
+		rejected := make([]Event, 0, len(rowErrs))
+		for _, re := range rowErrs {
+			if re.RowIndex >= 0 && re.RowIndex < len(events) {
+				rejected = append(rejected, events[re.RowIndex])
+			}
+		}
+		return Rejected(rejected, fmt.Errorf("table %s: %w", table, err))
+	}
+	return fmt.Errorf("table %s: %w", table, err)
+}
+
+// Close flushes every table's queue, then closes the client.
+func (s *bigQuerySink) Close(ctx context.Context) error {
+	var errs []error
+	for _, b := range s.batchers {
+		if err := b.close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		if err := s.client.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing bq client: %w", err))
+		}
+		s.client = nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,366 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/icco/gutil/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+const meterName = "github.com/icco/reportd/pkg/sink"
+
+// Fanout delivers published events to every sink in the background. Each
+// sink is written independently: a slow, failing or panicking sink does
+// not affect the others or the publishing handler. A nil *Fanout drops
+// everything, so handlers need not check whether forwarding is on.
+type Fanout struct {
+	sinks []Sink
+	wg    sync.WaitGroup
+
+	// spools parallels sinks once EnableSpool has been called.
+	spools     []*spool
+	stopReplay chan struct{}
+	replayWG   sync.WaitGroup
+	gauges     metric.Registration
+
+	events   metric.Int64Counter
+	duration metric.Float64Histogram
+	spooled  metric.Int64Counter
+	replayed metric.Int64Counter
+	dropped  metric.Int64Counter
+	corrupt  metric.Int64Counter
+}
+
+// NewFanout returns a Fanout over sinks.
+func NewFanout(sinks ...Sink) *Fanout {
+	meter := otel.Meter(meterName)
+	// Instrument errors only occur for invalid names, which are constant.
+	events, _ := meter.Int64Counter("reportd.sink.events",
+		metric.WithDescription("Events written to each sink, by kind and result."))
+	duration, _ := meter.Float64Histogram("reportd.sink.write.duration",
+		metric.WithDescription("Time spent in each sink's Write."),
+		metric.WithUnit("s"))
+	spooled, _ := meter.Int64Counter("reportd.sink.spooled",
+		metric.WithDescription("Events appended to a sink's on-disk spool after a failed write."))
+	replayed, _ := meter.Int64Counter("reportd.sink.replayed",
+		metric.WithDescription("Spooled events a sink accepted on replay."))
+	dropped, _ := meter.Int64Counter("reportd.sink.dropped",
+		metric.WithDescription("Events a sink discarded, by reason."))
+	corrupt, _ := meter.Int64Counter("reportd.sink.spool.corrupt",
+		metric.WithDescription("Bytes of unreadable spool data skipped on replay."),
+		metric.WithUnit("By"))
+	return &Fanout{
+		sinks:    sinks,
+		events:   events,
+		duration: duration,
+		spooled:  spooled,
+		replayed: replayed,
+		dropped:  dropped,
+		corrupt:  corrupt,
+	}
+}
+
+// asyncSink is a Sink that queues events and delivers them after Write
+// returns, so its failures surface too late for Fanout to spool. Fanout
+// hands it a spill function for the events it gives up on instead.
+type asyncSink interface {
+	Sink
+	setSpill(spill func(events []Event) error)
+}
+
+// EnableSpool gives each sink an on-disk spool under opts.Dir. Events a
+// sink fails to store are appended there and replayed every
+// opts.ReplayInterval until the sink takes them, so an outage delays
+// forwarding instead of losing data. Delivery is at least once: a replay
+// that fails partway resends the whole segment. It must be called before
+// the first Publish.
+func (f *Fanout) EnableSpool(opts SpoolOptions) error {
+	if f == nil || len(f.sinks) == 0 {
+		return nil
+	}
+	opts = opts.withDefaults()
+
+	names := spoolNames(f.sinks)
+	spools := make([]*spool, len(f.sinks))
+	for i := range f.sinks {
+		sp, err := openSpool(filepath.Join(opts.Dir, names[i]), opts)
+		if err != nil {
+			return fmt.Errorf("opening %s spool: %w", names[i], err)
+		}
+		spools[i] = sp
+	}
+	f.spools = spools
+
+	gauges, err := registerSpoolGauges(f, names)
+	if err != nil {
+		return err
+	}
+	f.gauges = gauges
+
+	f.stopReplay = make(chan struct{})
+	for i, s := range f.sinks {
+		if a, ok := s.(asyncSink); ok {
+			a.setSpill(func(events []Event) error {
+				return f.spool(context.Background(), i, events)
+			})
+		}
+		f.replayWG.Go(func() { f.replayLoop(i, opts.ReplayInterval) })
+	}
+	return nil
+}
+
+// spoolNames names each sink's spool directory after the sink, numbering
+// repeats (webhook, webhook-2) so the order of --sinks only matters among
+// sinks of one type.
+func spoolNames(sinks []Sink) []string {
+	seen := map[string]int{}
+	names := make([]string, len(sinks))
+	for i, s := range sinks {
+		seen[s.Name()]++
+		names[i] = s.Name()
+		if n := seen[s.Name()]; n > 1 {
+			names[i] = fmt.Sprintf("%s-%d", s.Name(), n)
+		}
+	}
+	return names
+}
+
+func registerSpoolGauges(f *Fanout, names []string) (metric.Registration, error) {
+	meter := otel.Meter(meterName)
+	records, _ := meter.Int64ObservableGauge("reportd.sink.spool.records",
+		metric.WithDescription("Events waiting in each sink's spool."))
+	size, _ := meter.Int64ObservableGauge("reportd.sink.spool.size",
+		metric.WithDescription("On-disk size of each sink's spool."),
+		metric.WithUnit("By"))
+	reg, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for i, sp := range f.spools {
+			st := sp.stats()
+			attrs := metric.WithAttributes(attribute.String("sink", names[i]))
+			o.ObserveInt64(records, st.Records, attrs)
+			o.ObserveInt64(size, st.Bytes, attrs)
+		}
+		return nil
+	}, records, size)
+	if err != nil {
+		return nil, fmt.Errorf("registering spool gauges: %w", err)
+	}
+	return reg, nil
+}
+
+// SpoolStats reports each sink's replay backlog, or nil if spooling is
+// off.
+func (f *Fanout) SpoolStats() []SpoolStats {
+	if f == nil || f.spools == nil {
+		return nil
+	}
+	names := spoolNames(f.sinks)
+	out := make([]SpoolStats, len(f.spools))
+	for i, sp := range f.spools {
+		out[i] = sp.stats()
+		out[i].Sink = names[i]
+	}
+	return out
+}
+
+// Sinks returns the sinks f writes to.
+func (f *Fanout) Sinks() []Sink {
+	if f == nil {
+		return nil
+	}
+	return f.sinks
+}
+
+// Publish hands events to every sink and returns immediately. ctx
+// supplies values such as the request logger; its cancellation is
+// ignored so writes outlive the request.
+func (f *Fanout) Publish(ctx context.Context, events ...Event) {
+	if f == nil || len(events) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	for i := range f.sinks {
+		f.wg.Go(func() { f.write(ctx, i, events) })
+	}
+}
+
+func (f *Fanout) write(ctx context.Context, i int, events []Event) {
+	s := f.sinks[i]
+	start := time.Now()
+	err := safeWrite(ctx, s, events)
+	f.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attribute.String("sink", s.Name())))
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+		logging.FromContext(ctx).Errorw("error writing to sink", zap.Error(err), "sink", s.Name(), "events", len(events))
+
+		var perm *permanentError
+		_, async := s.(asyncSink)
+		if f.spools != nil && !async && !errors.As(err, &perm) && f.spool(ctx, i, events) == nil {
+			result = "spooled"
+		}
+	}
+	addPerKind(ctx, f.events, events,
+		attribute.String("sink", s.Name()),
+		attribute.String("result", result),
+	)
+}
+
+// spool appends events to sink i's spool, counting them as dropped if
+// that fails.
+func (f *Fanout) spool(ctx context.Context, i int, events []Event) error {
+	name := f.sinks[i].Name()
+	if err := f.spools[i].append(events); err != nil {
+		logging.FromContext(ctx).Errorw("could not spool events", zap.Error(err), "sink", name, "events", len(events))
+		reason := "spool_error"
+		if errors.Is(err, errSpoolFull) {
+			reason = "spool_full"
+		}
+		addPerKind(ctx, f.dropped, events, attribute.String("sink", name), attribute.String("reason", reason))
+		return err
+	}
+	addPerKind(ctx, f.spooled, events, attribute.String("sink", name))
+	return nil
+}
+
+func (f *Fanout) replayLoop(i int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stopReplay:
+			return
+		case <-ticker.C:
+			f.replay(i)
+		}
+	}
+}
+
+// replay writes sink i's spooled segments, oldest first, deleting each
+// once the sink accepts it. It stops at the first retryable failure and
+// leaves the rest for the next tick.
+func (f *Fanout) replay(i int) {
+	ctx := context.Background()
+	l := logging.FromContext(ctx)
+	s, sp := f.sinks[i], f.spools[i]
+	for {
+		select {
+		case <-f.stopReplay:
+			return
+		default:
+		}
+
+		seq, events, skipped, ok, err := sp.oldest()
+		if err != nil {
+			l.Errorw("error reading spool", zap.Error(err), "sink", s.Name())
+			return
+		}
+		if !ok {
+			return
+		}
+		if skipped > 0 {
+			l.Warnw("skipped corrupt spool data", "sink", s.Name(), "segment", seq, "bytes", skipped)
+			f.corrupt.Add(ctx, int64(skipped), metric.WithAttributes(attribute.String("sink", s.Name())))
+		}
+
+		if len(events) > 0 {
+			var perm *permanentError
+			err := safeWrite(ctx, s, events)
+			switch {
+			case errors.As(err, &perm):
+				l.Errorw("sink rejected spooled events", zap.Error(err), "sink", s.Name(), "events", len(events))
+				addPerKind(ctx, f.dropped, events, attribute.String("sink", s.Name()), attribute.String("reason", "rejected"))
+			case err != nil:
+				l.Warnw("spool replay failed, will retry", zap.Error(err), "sink", s.Name(), "events", len(events))
+				return
+			default:
+				addPerKind(ctx, f.replayed, events, attribute.String("sink", s.Name()))
+			}
+		}
+
+		if err := sp.remove(seq); err != nil {
+			l.Errorw("error removing replayed spool segment", zap.Error(err), "sink", s.Name())
+			return
+		}
+	}
+}
+
+// addPerKind adds one to c per event, split by event kind.
+func addPerKind(ctx context.Context, c metric.Int64Counter, events []Event, attrs ...attribute.KeyValue) {
+	counts := map[Kind]int64{}
+	for _, e := range events {
+		counts[e.Kind]++
+	}
+	for kind, n := range counts {
+		c.Add(ctx, n, metric.WithAttributes(append(attrs, attribute.String("kind", string(kind)))...))
+	}
+}
+
+// safeWrite turns a panicking sink into an error.
+func safeWrite(ctx context.Context, s Sink, events []Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("sink %s panicked: %v", s.Name(), r)
+		}
+	}()
+	return s.Write(ctx, events)
+}
+
+// Close waits for in-flight writes and replays, then closes every sink
+// and finally the spools, so events a sink gives up on while closing are
+// still spooled. It gives up waiting when ctx is done.
+func (f *Fanout) Close(ctx context.Context) error {
+	if f == nil {
+		return nil
+	}
+
+	if err := waitCtx(ctx, &f.wg); err != nil {
+		return fmt.Errorf("waiting for sink writes: %w", err)
+	}
+	if f.stopReplay != nil {
+		close(f.stopReplay)
+		if err := waitCtx(ctx, &f.replayWG); err != nil {
+			return fmt.Errorf("waiting for spool replay: %w", err)
+		}
+	}
+
+	var errs []error
+	for _, s := range f.sinks {
+		if err := s.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("closing %s sink: %w", s.Name(), err))
+		}
+	}
+	for _, sp := range f.spools {
+		if err := sp.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if f.gauges != nil {
+		if err := f.gauges.Unregister(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func waitCtx(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,112 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type stubSink struct {
+	name  string
+	write func(context.Context, []Event) error
+
+	mu     sync.Mutex
+	got    []Event
+	closed bool
+}
+
+func (s *stubSink) Name() string { return s.name }
+
+func (s *stubSink) Write(ctx context.Context, events []Event) error {
+	s.mu.Lock()
+	s.got = append(s.got, events...)
+	s.mu.Unlock()
+	if s.write != nil {
+		return s.write(ctx, events)
+	}
+	return nil
+}
+
+func (s *stubSink) Close(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestFanoutIsolatesSinks(t *testing.T) {
+	good := &stubSink{name: "good"}
+	failing := &stubSink{name: "failing", write: func(context.Context, []Event) error { return errors.New("boom") }}
+	panicking := &stubSink{name: "panicking", write: func(context.Context, []Event) error { panic("kaboom") }}
+	slow := &stubSink{name: "slow", write: func(context.Context, []Event) error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}}
+
+	f := NewFanout(failing, panicking, slow, good)
+	f.Publish(context.Background(), testVital("a"), testVital("b"))
+
+	if err := f.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	for _, s := range []*stubSink{good, failing, panicking, slow} {
+		if len(s.got) != 2 {
+			t.Errorf("%s got %d events, want 2", s.name, len(s.got))
+		}
+		if !s.closed {
+			t.Errorf("%s was not closed", s.name)
+		}
+	}
+}
+
+func TestFanoutPublishIgnoresRequestCancel(t *testing.T) {
+	var sinkCtxErr error
+	s := &stubSink{name: "ctx", write: func(ctx context.Context, _ []Event) error {
+		sinkCtxErr = ctx.Err()
+		return nil
+	}}
+
+	f := NewFanout(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.Publish(ctx, testVital("a"))
+	if err := f.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.got) != 1 {
+		t.Errorf("got %d events, want 1", len(s.got))
+	}
+	if sinkCtxErr != nil {
+		t.Errorf("sink saw canceled context: %v", sinkCtxErr)
+	}
+}
+
+func TestFanoutCloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	s := &stubSink{name: "stuck", write: func(context.Context, []Event) error {
+		<-release
+		return nil
+	}}
+	f := NewFanout(s)
+	f.Publish(context.Background(), testVital("a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := f.Close(ctx); err == nil {
+		t.Error("expected Close to time out")
+	}
+	close(release)
+}
+
+func TestNilFanout(t *testing.T) {
+	var f *Fanout
+	f.Publish(context.Background(), testVital("a"))
+	if err := f.Close(context.Background()); err != nil {
+		t.Errorf("nil Close() = %v", err)
+	}
+	if f.Sinks() != nil {
+		t.Error("nil Sinks() should be nil")
+	}
+}
@@ -0,0 +1,95 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+func init() {
+	Register("file", func(_ context.Context, dir string, _ Config) (Sink, error) {
+		if dir == "" {
+			return nil, fmt.Errorf("file sink needs a directory, e.g. file:/var/lib/reportd/archive")
+		}
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, fmt.Errorf("creating %s: %w", dir, err)
+		}
+		return &fileSink{dir: dir, files: map[string]*os.File{}}, nil
+	})
+}
+
+// maxOpenFiles bounds fileSink's handle cache: three kinds over three days.
+const maxOpenFiles = 9
+
+// fileSink appends events as NDJSON to one file per kind and UTC day,
+// e.g. web_vital-2024-11-28.ndjson.
+type fileSink struct {
+	dir string
+
+	mu    sync.Mutex
+	files map[string]*os.File
+}
+
+func (s *fileSink) Name() string { return "file" }
+
+func (s *fileSink) Write(_ context.Context, events []Event) error {
+	byFile := map[string][]Event{}
+	for _, e := range events {
+		name := fmt.Sprintf("%s-%s.ndjson", e.Kind, e.ReceivedAt.UTC().Format("2006-01-02"))
+		byFile[name] = append(byFile[name], e)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for name, batch := range byFile {
+		f, err := s.open(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := writeNDJSON(f, batch); err != nil {
+			errs = append(errs, fmt.Errorf("writing %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// open returns the handle for name, closing handles for earlier days.
+// Callers hold s.mu.
+func (s *fileSink) open(name string) (*os.File, error) {
+	if f, ok := s.files[name]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", name, err)
+	}
+	// Files for past days only see writes around midnight; once more
+	// than a few days' worth are open, drop them all and reopen lazily.
+	if len(s.files) > maxOpenFiles {
+		for n, old := range s.files {
+			_ = old.Close()
+			delete(s.files, n)
+		}
+	}
+	s.files[name] = f
+	return f, nil
+}
+
+func (s *fileSink) Close(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for name, f := range s.files {
+		if err := f.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing %s: %w", name, err))
+		}
+		delete(s.files, name)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,151 @@
+// Package sink forwards ingested events to long-term destinations such as
+// BigQuery, a local file archive, a webhook or stdout. Handlers publish to
+// a Fanout, which writes to every configured Sink independently.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/icco/reportd/pkg/analytics"
+	"github.com/icco/reportd/pkg/reporting"
+	"github.com/icco/reportd/pkg/reportto"
+)
+
+// Kind names the ingestion path an Event came from.
+type Kind string
+
+// Event kinds, one per POST endpoint.
+const (
+	KindReportTo       Kind = "report_to"
+	KindWebVital       Kind = "web_vital"
+	KindSecurityReport Kind = "security_report"
+)
+
+// Event is one ingested item. Exactly one of ReportTo, WebVital and
+// SecurityReport is set, matching Kind.
+type Event struct {
+	Kind       Kind      `json:"kind"`
+	Service    string    `json:"service"`
+	ReceivedAt time.Time `json:"received_at"`
+
+	ReportTo       *reportto.Report          `json:"report_to,omitempty"`
+	WebVital       *analytics.WebVital       `json:"web_vital,omitempty"`
+	SecurityReport *reporting.SecurityReport `json:"security_report,omitempty"`
+}
+
+// ReportToEvent wraps a legacy Report-To report.
+func ReportToEvent(r *reportto.Report) Event {
+	return Event{Kind: KindReportTo, Service: r.Service.StringVal, ReceivedAt: time.Now(), ReportTo: r}
+}
+
+// WebVitalEvent wraps a Web Vitals beacon.
+func WebVitalEvent(wv *analytics.WebVital) Event {
+	return Event{Kind: KindWebVital, Service: wv.Service.StringVal, ReceivedAt: time.Now(), WebVital: wv}
+}
+
+// SecurityReportEvent wraps a Reporting API v1 report.
+func SecurityReportEvent(sr *reporting.SecurityReport) Event {
+	return Event{Kind: KindSecurityReport, Service: sr.Service.StringVal, ReceivedAt: time.Now(), SecurityReport: sr}
+}
+
+// Sink is a destination for events. Write may be called concurrently.
+type Sink interface {
+	// Name identifies the sink in logs and metrics.
+	Name() string
+	// Write delivers events, returning an error if any were not stored.
+	Write(ctx context.Context, events []Event) error
+	// Close flushes and releases resources.
+	Close(ctx context.Context) error
+}
+
+// Config carries settings shared by every sink factory.
+type Config struct {
+	BigQuery BigQueryConfig
+}
+
+// Factory builds a Sink from the argument following "type:" in a sink
+// spec (empty if there was none).
+type Factory func(ctx context.Context, arg string, cfg Config) (Sink, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a sink type available to Open. It panics if name is
+// already registered.
+func Register(name string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic("sink: Register called twice for " + name)
+	}
+	registry[name] = f
+}
+
+// Types returns the registered sink types, sorted.
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	out := make([]string, 0, len(registry))
+	for name := range registry {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Open builds one Sink per spec. A spec is a registered type optionally
+// followed by ":" and an argument, e.g. "stdout", "file:/var/lib/reportd"
+// or "webhook:https://example.com/hook". Blank specs are skipped. On
+// error, sinks opened so far are closed.
+func Open(ctx context.Context, specs []string, cfg Config) ([]Sink, error) {
+	var sinks []Sink
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		typ, arg, _ := strings.Cut(spec, ":")
+
+		registryMu.RLock()
+		f, ok := registry[typ]
+		registryMu.RUnlock()
+		if !ok {
+			closeAll(ctx, sinks)
+			return nil, fmt.Errorf("unknown sink type %q: want one of %s", typ, strings.Join(Types(), ", "))
+		}
+
+		s, err := f(ctx, arg, cfg)
+		if err != nil {
+			closeAll(ctx, sinks)
+			return nil, fmt.Errorf("opening %s sink: %w", typ, err)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+func closeAll(ctx context.Context, sinks []Sink) {
+	for _, s := range sinks {
+		_ = s.Close(ctx)
+	}
+}
+
+// writeNDJSON writes events to w as newline-delimited JSON.
+func writeNDJSON(w io.Writer, events []Event) error {
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("encoding %s event: %w", e.Kind, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/icco/reportd/pkg/analytics"
+)
+
+func testVital(service string) Event {
+	e := WebVitalEvent(&analytics.WebVital{
+		Name:    "LCP",
+		Value:   1200,
+		Service: bigquery.NullString{StringVal: service, Valid: true},
+	})
+	e.ReceivedAt = time.Date(2024, 11, 28, 12, 0, 0, 0, time.UTC)
+	return e
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+
+	sinks, err := Open(ctx, []string{"stdout", " ", "file:" + t.TempDir()}, Config{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if len(sinks) != 2 || sinks[0].Name() != "stdout" || sinks[1].Name() != "file" {
+		t.Errorf("Open() = %v, want stdout and file sinks", sinks)
+	}
+
+	for _, specs := range [][]string{
+		{"kafka"},
+		{"file"},
+		{"webhook:ftp://example.com"},
+		{"bigquery"},
+	} {
+		if _, err := Open(ctx, specs, Config{}); err == nil {
+			t.Errorf("Open(%q) expected error", specs)
+		}
+	}
+
+	// BigQuery needs a project, a dataset and at least one table, but no
+	// network access until the first Write.
+	bq := BigQueryConfig{Project: "p", Dataset: "d"}
+	if _, err := Open(ctx, []string{"bigquery"}, Config{BigQuery: bq}); err == nil {
+		t.Error("Open(bigquery) without tables expected error")
+	}
+	bq.AnalyticsTable = "analytics"
+	if _, err := Open(ctx, []string{"bigquery"}, Config{BigQuery: bq}); err != nil {
+		t.Errorf("Open(bigquery) error = %v", err)
+	}
+
+	// A bad spec after a good one fails the whole Open.
+	if _, err := Open(ctx, []string{"stdout", "kafka"}, Config{}); err == nil {
+		t.Error("Open(stdout, kafka) expected error")
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	s := &writerSink{name: "buf", w: &buf}
+	if err := s.Write(context.Background(), []Event{testVital("a"), testVital("b")}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	var got Event
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != KindWebVital || got.Service != "b" || got.WebVital == nil || got.WebVital.Name != "LCP" {
+		t.Errorf("decoded event = %+v", got)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	sinks, err := Open(ctx, []string{"file:" + dir}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sinks[0]
+
+	for range 2 {
+		if err := s.Write(ctx, []Event{testVital("svc")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "web_vital-2024-11-28.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	n := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); n++ {
+	}
+	if n != 2 {
+		t.Errorf("got %d lines, want 2", n)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	ctx := context.Background()
+	status := http.StatusAccepted
+	var gotBody, gotCT string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		_, _ = buf.ReadFrom(r.Body)
+		gotBody = buf.String()
+		gotCT = r.Header.Get("Content-Type")
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sinks, err := Open(ctx, []string{"webhook:" + srv.URL}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sinks[0]
+
+	if err := s.Write(ctx, []Event{testVital("svc")}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if gotCT != "application/x-ndjson" || !strings.Contains(gotBody, `"kind":"web_vital"`) {
+		t.Errorf("webhook got content-type %q body %q", gotCT, gotBody)
+	}
+
+	status = http.StatusBadGateway
+	if err := s.Write(ctx, []Event{testVital("svc")}); err == nil {
+		t.Error("expected error on 502")
+	}
+}
+
+func TestBigQuerySinkWrite(t *testing.T) {
+	ctx := context.Background()
+	// Only the analytics table is configured, and the flush interval is
+	// long enough that no insert (and so no network access) happens.
+	s := newBigQuerySink(BigQueryConfig{
+		Project:        "p",
+		Dataset:        "d",
+		AnalyticsTable: "analytics",
+		Batch:          BatchOptions{FlushInterval: time.Hour, MaxBatch: 100},
+	})
+	defer func() { _ = s.Close(ctx) }()
+	if len(s.batchers) != 1 {
+		t.Fatalf("got %d batchers, want 1", len(s.batchers))
+	}
+
+	invalid := WebVitalEvent(&analytics.WebVital{Name: "LCP"})
+	if err := s.Write(ctx, []Event{invalid}); err == nil {
+		t.Error("expected error for web vital without service")
+	}
+
+	// Kinds without a table are skipped silently.
+	report := Event{Kind: KindReportTo, Service: "svc"}
+	if err := s.Write(ctx, []Event{report}); err != nil {
+		t.Errorf("Write(report_to) error = %v", err)
+	}
+	if n := len(s.batchers[KindWebVital].queue); n != 0 {
+		t.Errorf("queued %d events, want 0", n)
+	}
+}
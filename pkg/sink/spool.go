@@ -0,0 +1,309 @@
+package sink
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpoolOptions configures the on-disk spool that holds events a sink
+// failed to deliver until they can be replayed. Zero fields take the
+// defaults noted on each.
+type SpoolOptions struct {
+	// Dir is the spool root; each sink gets its own subdirectory.
+	Dir string
+	// MaxBytes caps each sink's spool on disk. Once reached, further
+	// failed events are dropped rather than spooled. Default 256 MiB.
+	MaxBytes int64
+	// SegmentBytes starts a new segment file once the current one grows
+	// past this size. Default 4 MiB.
+	SegmentBytes int64
+	// ReplayInterval is how often a sink's backlog is retried. Default 30s.
+	ReplayInterval time.Duration
+}
+
+func (o SpoolOptions) withDefaults() SpoolOptions {
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = 256 << 20
+	}
+	if o.SegmentBytes <= 0 {
+		o.SegmentBytes = 4 << 20
+	}
+	if o.ReplayInterval <= 0 {
+		o.ReplayInterval = 30 * time.Second
+	}
+	return o
+}
+
+// SpoolStats describes one sink's replay backlog.
+type SpoolStats struct {
+	Sink     string `json:"sink"`
+	Segments int    `json:"segments"`
+	Records  int64  `json:"records"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// errSpoolFull is returned by spool.append when MaxBytes would be exceeded.
+var errSpoolFull = errors.New("spool full")
+
+// Records are framed as magic, payload length, CRC-32C of the payload,
+// then the gob-encoded Event. A reader that hits a torn write or garbage
+// skips ahead to the next magic that starts a frame with a valid
+// checksum, so one bad record never hides the ones after it.
+var (
+	frameMagic = []byte{'r', 'p', 'd', 0x01}
+	crcTable   = crc32.MakeTable(crc32.Castagnoli)
+)
+
+const (
+	frameHeaderLen = 12
+	maxFrameLen    = 16 << 20
+	segmentExt     = ".spool"
+)
+
+// encodeFrame appends e's frame to buf. Events are gob-encoded rather
+// than JSON so fields hidden from JSON (such as the deprecation report
+// ID) survive the round trip.
+func encodeFrame(buf []byte, e Event) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(e); err != nil {
+		return buf, fmt.Errorf("encoding event: %w", err)
+	}
+	if payload.Len() > maxFrameLen {
+		return buf, fmt.Errorf("event is %d bytes, over the %d byte limit", payload.Len(), maxFrameLen)
+	}
+	buf = append(buf, frameMagic...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(payload.Len()))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(payload.Bytes(), crcTable))
+	return append(buf, payload.Bytes()...), nil
+}
+
+// decodeFrames returns the events framed in data and how many bytes had
+// to be skipped as corrupt.
+func decodeFrames(data []byte) (events []Event, skipped int) {
+	for len(data) > 0 {
+		i := bytes.Index(data, frameMagic)
+		if i < 0 {
+			return events, skipped + len(data)
+		}
+		skipped += i
+		data = data[i:]
+		if len(data) < frameHeaderLen {
+			return events, skipped + len(data)
+		}
+
+		n := int(binary.BigEndian.Uint32(data[4:8]))
+		sum := binary.BigEndian.Uint32(data[8:12])
+		if n > maxFrameLen || frameHeaderLen+n > len(data) || crc32.Checksum(data[frameHeaderLen:frameHeaderLen+n], crcTable) != sum {
+			// Not a real frame start; resync past this magic.
+			skipped++
+			data = data[1:]
+			continue
+		}
+
+		var e Event
+		if err := gob.NewDecoder(bytes.NewReader(data[frameHeaderLen : frameHeaderLen+n])).Decode(&e); err != nil {
+			skipped += frameHeaderLen + n
+		} else {
+			events = append(events, e)
+		}
+		data = data[frameHeaderLen+n:]
+	}
+	return events, skipped
+}
+
+// segment is one spool file. Segments are append-only and never reopened
+// for writing after a restart, so a torn tail only affects that file.
+type segment struct {
+	seq     uint64
+	size    int64
+	records int64
+}
+
+// spool is a directory of segment files holding one sink's undelivered
+// events, oldest first.
+type spool struct {
+	dir  string
+	opts SpoolOptions
+
+	mu       sync.Mutex
+	segments []segment
+	cur      *os.File // the last segment, if it is open for appending
+	nextSeq  uint64
+}
+
+// openSpool opens or creates the spool in dir, counting the records left
+// by a previous run.
+func openSpool(dir string, opts SpoolOptions) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating spool dir: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading spool dir: %w", err)
+	}
+
+	s := &spool{dir: dir, opts: opts.withDefaults()}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), segmentExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading spool segment: %w", err)
+		}
+		events, _ := decodeFrames(data)
+		s.segments = append(s.segments, segment{seq: seq, size: int64(len(data)), records: int64(len(events))})
+		s.nextSeq = max(s.nextSeq, seq+1)
+	}
+	slices.SortFunc(s.segments, func(a, b segment) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	return s, nil
+}
+
+func (s *spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// append durably writes events to the newest segment, rotating first if
+// it is full. It returns errSpoolFull, writing nothing, if events would
+// take the spool past MaxBytes.
+func (s *spool) append(events []Event) error {
+	var buf []byte
+	for _, e := range events {
+		var err error
+		if buf, err = encodeFrame(buf, e); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bytes()+int64(len(buf)) > s.opts.MaxBytes {
+		return errSpoolFull
+	}
+
+	if s.cur != nil && s.segments[len(s.segments)-1].size+int64(len(buf)) > s.opts.SegmentBytes {
+		if err := s.seal(); err != nil {
+			return err
+		}
+	}
+	if s.cur == nil {
+		f, err := os.OpenFile(s.path(s.nextSeq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return fmt.Errorf("creating spool segment: %w", err)
+		}
+		s.cur = f
+		s.segments = append(s.segments, segment{seq: s.nextSeq})
+		s.nextSeq++
+	}
+
+	last := &s.segments[len(s.segments)-1]
+	n, err := s.cur.Write(buf)
+	// Count a partial write too: the bytes are on disk and the reader
+	// will skip the torn frame.
+	last.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("writing spool segment: %w", err)
+	}
+	if err := s.cur.Sync(); err != nil {
+		return fmt.Errorf("syncing spool segment: %w", err)
+	}
+	last.records += int64(len(events))
+	return nil
+}
+
+// seal closes the open segment so the next append starts a new one.
+func (s *spool) seal() error {
+	if s.cur == nil {
+		return nil
+	}
+	err := s.cur.Close()
+	s.cur = nil
+	if err != nil {
+		return fmt.Errorf("closing spool segment: %w", err)
+	}
+	return nil
+}
+
+// oldest seals and reads the oldest segment. ok is false when the spool
+// is empty.
+func (s *spool) oldest() (seq uint64, events []Event, skipped int, ok bool, err error) {
+	s.mu.Lock()
+	if len(s.segments) == 0 {
+		s.mu.Unlock()
+		return 0, nil, 0, false, nil
+	}
+	seq = s.segments[0].seq
+	if len(s.segments) == 1 {
+		if err := s.seal(); err != nil {
+			s.mu.Unlock()
+			return 0, nil, 0, false, err
+		}
+	}
+	s.mu.Unlock()
+
+	// Sealed segments are immutable, so the read needs no lock.
+	data, err := os.ReadFile(s.path(seq))
+	if err != nil {
+		return 0, nil, 0, false, fmt.Errorf("reading spool segment: %w", err)
+	}
+	events, skipped = decodeFrames(data)
+	return seq, events, skipped, true, nil
+}
+
+// remove deletes a replayed segment.
+func (s *spool) remove(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.segments, func(seg segment) bool { return seg.seq == seq })
+	if i < 0 {
+		return nil
+	}
+	if err := os.Remove(s.path(seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing spool segment: %w", err)
+	}
+	s.segments = slices.Delete(s.segments, i, i+1)
+	return nil
+}
+
+func (s *spool) bytes() int64 {
+	var n int64
+	for _, seg := range s.segments {
+		n += seg.size
+	}
+	return n
+}
+
+func (s *spool) stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := SpoolStats{Segments: len(s.segments), Bytes: s.bytes()}
+	for _, seg := range s.segments {
+		st.Records += seg.records
+	}
+	return st
+}
+
+func (s *spool) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seal()
+}
@@ -0,0 +1,242 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/icco/reportd/pkg/reporting"
+)
+
+func testDeprecation(service string) Event {
+	return SecurityReportEvent(&reporting.SecurityReport{
+		Deprecation: &reporting.DeprecationReport{
+			Type: "deprecation",
+			URL:  "https://example.com/",
+			Body: reporting.DeprecationReportBody{
+				ID:      bigquery.NullString{StringVal: "PrefixedStorageInfo", Valid: true},
+				Message: bigquery.NullString{StringVal: "use navigator.storage", Valid: true},
+			},
+		},
+		ReportType: bigquery.NullString{StringVal: "deprecation", Valid: true},
+		Service:    bigquery.NullString{StringVal: service, Valid: true},
+	})
+}
+
+func TestSpoolRoundTrip(t *testing.T) {
+	sp, err := openSpool(t.TempDir(), SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = sp.close() }()
+
+	if err := sp.append([]Event{testVital("a"), testDeprecation("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if st := sp.stats(); st.Segments != 1 || st.Records != 2 || st.Bytes == 0 {
+		t.Errorf("stats() = %+v, want 1 segment holding 2 records", st)
+	}
+
+	seq, events, skipped, ok, err := sp.oldest()
+	if err != nil || !ok {
+		t.Fatalf("oldest() = ok %v, err %v", ok, err)
+	}
+	if skipped != 0 || len(events) != 2 {
+		t.Fatalf("oldest() = %d events, %d bytes skipped; want 2, 0", len(events), skipped)
+	}
+	if events[0].WebVital == nil || events[0].WebVital.Name != "LCP" || !events[0].ReceivedAt.Equal(testVital("a").ReceivedAt) {
+		t.Errorf("events[0] = %+v", events[0])
+	}
+	// Fields excluded from JSON must survive spooling.
+	if body := events[1].SecurityReport.Deprecation.Body; body.ID.StringVal != "PrefixedStorageInfo" || body.Message.StringVal != "use navigator.storage" {
+		t.Errorf("deprecation body = %+v", body)
+	}
+
+	// Appends after oldest() sealed the segment start a new one.
+	if err := sp.append([]Event{testVital("c")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.remove(seq); err != nil {
+		t.Fatal(err)
+	}
+	if st := sp.stats(); st.Segments != 1 || st.Records != 1 {
+		t.Errorf("stats() after remove = %+v, want 1 segment holding 1 record", st)
+	}
+}
+
+func TestSpoolSkipsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := openSpool(dir, SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, svc := range []string{"a", "b", "c"} {
+		if err := sp.append([]Event{testVital(svc)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sp.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := sp.path(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frameLen := len(data) / 3
+	// Flip a payload byte in the middle record, then add garbage and a
+	// torn frame at the tail as a crash mid-append would.
+	data[frameLen+frameHeaderLen+5] ^= 0xff
+	data = append(data, "garbage"...)
+	data = append(data, data[:frameLen/2]...)
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	events, skipped := decodeFrames(data)
+	if len(events) != 2 || events[0].Service != "a" || events[1].Service != "c" {
+		t.Errorf("decoded %d events %v, want services a and c", len(events), events)
+	}
+	if skipped != frameLen+len("garbage")+frameLen/2 {
+		t.Errorf("skipped %d bytes, want %d", skipped, frameLen+len("garbage")+frameLen/2)
+	}
+
+	// A reopened spool counts only the readable records.
+	sp, err = openSpool(dir, SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := sp.stats(); st.Records != 2 {
+		t.Errorf("reopened stats() = %+v, want 2 records", st)
+	}
+}
+
+func TestSpoolLimits(t *testing.T) {
+	frame, err := encodeFrame(nil, testVital("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := int64(len(frame))
+
+	dir := t.TempDir()
+	sp, err := openSpool(dir, SpoolOptions{MaxBytes: 3 * n, SegmentBytes: n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if err := sp.append([]Event{testVital("a")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sp.append([]Event{testVital("a")}); !errors.Is(err, errSpoolFull) {
+		t.Errorf("append() over MaxBytes error = %v, want errSpoolFull", err)
+	}
+	if st := sp.stats(); st.Segments != 3 || st.Records != 3 || st.Bytes != 3*n {
+		t.Errorf("stats() = %+v, want 3 one-record segments", st)
+	}
+	if err := sp.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening resumes numbering after the existing segments.
+	sp, err = openSpool(dir, SpoolOptions{SegmentBytes: n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.append([]Event{testVital("a")}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = sp.close() }()
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(files) != 4 {
+		t.Errorf("got %d segment files, want 4", len(files))
+	}
+}
+
+func TestFanoutSpoolsAndReplays(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	flaky := &stubSink{name: "flaky", write: func(context.Context, []Event) error {
+		if down.Load() {
+			return errors.New("unavailable")
+		}
+		return nil
+	}}
+	rejecting := &stubSink{name: "rejecting", write: func(context.Context, []Event) error {
+		return Permanent(errors.New("bad row"))
+	}}
+
+	dir := t.TempDir()
+	f := NewFanout(flaky, rejecting)
+	if err := f.EnableSpool(SpoolOptions{Dir: dir, ReplayInterval: 5 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	f.Publish(context.Background(), testVital("a"), testVital("b"))
+
+	waitFor(t, func() bool { return f.SpoolStats()[0].Records == 2 })
+	if st := f.SpoolStats()[1]; st.Records != 0 {
+		t.Errorf("rejected events were spooled: %+v", st)
+	}
+
+	down.Store(false)
+	waitFor(t, func() bool { return f.SpoolStats()[0].Records == 0 })
+	if err := f.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Two failed writes, then the two replayed events.
+	if len(flaky.got) != 4 {
+		t.Errorf("flaky sink saw %d events, want 4", len(flaky.got))
+	}
+}
+
+func TestBigQuerySinkSpillsOnShutdown(t *testing.T) {
+	s := newBigQuerySink(BigQueryConfig{
+		Project:        "p",
+		Dataset:        "d",
+		AnalyticsTable: "analytics",
+		Batch:          BatchOptions{FlushInterval: time.Hour, InitialBackoff: time.Hour},
+	})
+	// Stand in for BigQuery being down.
+	s.batchers[KindWebVital].flush = func(context.Context, []Event) error { return errors.New("unavailable") }
+
+	f := NewFanout(s)
+	if err := f.EnableSpool(SpoolOptions{Dir: t.TempDir(), ReplayInterval: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	f.Publish(context.Background(), testVital("a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := f.Close(ctx); err == nil {
+		t.Error("expected Close to report the abandoned flush")
+	}
+	if st := f.SpoolStats()[0]; st.Sink != "bigquery" || st.Records != 1 {
+		t.Errorf("SpoolStats() = %+v, want the unflushed event spooled", st)
+	}
+}
+
+func TestSpoolNames(t *testing.T) {
+	got := spoolNames([]Sink{&stubSink{name: "webhook"}, &stubSink{name: "file"}, &stubSink{name: "webhook"}})
+	if want := []string{"webhook", "file", "webhook-2"}; !slices.Equal(got, want) {
+		t.Errorf("spoolNames() = %v, want %v", got, want)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met within 2s")
+}
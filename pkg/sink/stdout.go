@@ -0,0 +1,32 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+)
+
+func init() {
+	Register("stdout", func(context.Context, string, Config) (Sink, error) {
+		return &writerSink{name: "stdout", w: os.Stdout}, nil
+	})
+}
+
+// writerSink writes events as NDJSON to w, one batch at a time.
+type writerSink struct {
+	name string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *writerSink) Name() string { return s.name }
+
+func (s *writerSink) Write(_ context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeNDJSON(s.w, events)
+}
+
+func (s *writerSink) Close(context.Context) error { return nil }
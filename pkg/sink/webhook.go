@@ -0,0 +1,60 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+func init() {
+	Register("webhook", func(_ context.Context, arg string, _ Config) (Sink, error) {
+		u, err := url.Parse(arg)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook sink needs an http(s) URL, got %q", arg)
+		}
+		return &webhookSink{url: u.String(), client: &http.Client{Timeout: 10 * time.Second}}, nil
+	})
+}
+
+// webhookSink POSTs each batch as application/x-ndjson and treats any
+// non-2xx response as a failure.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) Name() string { return "webhook" }
+
+func (s *webhookSink) Write(ctx context.Context, events []Event) error {
+	var body bytes.Buffer
+	if err := writeNDJSON(&body, events); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return fmt.Errorf("building webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting to webhook: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close(context.Context) error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,167 @@
+// Package useragent classifies browser User-Agent strings so reports and
+// Web Vitals can be filtered and grouped by browser, OS and device.
+package useragent
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Browser families returned by Family.
+const (
+	Chrome          = "chrome"
+	Edge            = "edge"
+	Firefox         = "firefox"
+	Opera           = "opera"
+	Safari          = "safari"
+	SamsungInternet = "samsung-internet"
+	Other           = "other"
+)
+
+// Operating systems, besides Other, set by Parse.
+const (
+	Android  = "android"
+	ChromeOS = "chromeos"
+	IOS      = "ios"
+	Linux    = "linux"
+	MacOS    = "macos"
+	Windows  = "windows"
+)
+
+// Device classes set by Parse.
+const (
+	Desktop = "desktop"
+	Mobile  = "mobile"
+	Tablet  = "tablet"
+	Bot     = "bot"
+)
+
+// Agent is what Parse makes of a User-Agent string.
+type Agent struct {
+	// Raw is the User-Agent string as sent.
+	Raw string `json:"raw"`
+	// Family is one of the browser family constants.
+	Family string `json:"family"`
+	// Major is the browser's major version, or 0 if it has none we know.
+	Major int `json:"major,omitempty"`
+	// OS is one of the operating system constants, or Other.
+	OS string `json:"os"`
+	// Device is Desktop, Mobile, Tablet or Bot.
+	Device string `json:"device"`
+}
+
+// familyTokens are checked in order, since most browsers also claim to be
+// the ones before them: Edge and Opera send Chrome's token, and Chrome
+// sends Safari's. Safari's own token is its WebKit build, so its version
+// is read from Version/ instead.
+var familyTokens = []struct {
+	family  string
+	tokens  []string
+	version string
+}{
+	{Edge, []string{"Edg/", "EdgA/", "EdgiOS/", "Edge/"}, ""},
+	{Opera, []string{"OPR/", "OPT/", "Opera"}, ""},
+	{SamsungInternet, []string{"SamsungBrowser/"}, ""},
+	{Firefox, []string{"Firefox/", "FxiOS/"}, ""},
+	{Chrome, []string{"Chrome/", "CriOS/", "Chromium/"}, ""},
+	{Safari, []string{"Safari/", "AppleWebKit/"}, "Version/"},
+}
+
+// osTokens are checked in order: iOS claims to be "like Mac OS X",
+// Android and ChromeOS to be Linux.
+var osTokens = []struct {
+	os     string
+	tokens []string
+}{
+	{IOS, []string{"iPhone", "iPad", "iPod"}},
+	{Android, []string{"Android"}},
+	{ChromeOS, []string{"CrOS"}},
+	{Windows, []string{"Windows"}},
+	{MacOS, []string{"Macintosh", "Mac OS X"}},
+	{Linux, []string{"Linux", "X11"}},
+}
+
+// botTokens mark crawlers and monitoring agents. Matched lowercased.
+var botTokens = []string{"bot/", "bot;", "bot)", "crawler", "spider", "headlesschrome", "lighthouse"}
+
+// Parse classifies ua, or returns nil if it is empty.
+func Parse(ua string) *Agent {
+	if strings.TrimSpace(ua) == "" {
+		return nil
+	}
+	a := &Agent{Raw: ua, Family: Other, OS: Other, Device: Desktop}
+
+family:
+	for _, f := range familyTokens {
+		for _, tok := range f.tokens {
+			if i := strings.Index(ua, tok); i >= 0 {
+				a.Family = f.family
+				if f.version != "" {
+					a.Major = majorAfter(ua, f.version)
+				} else if strings.HasSuffix(tok, "/") {
+					a.Major = majorAt(ua[i+len(tok):])
+				}
+				break family
+			}
+		}
+	}
+
+os:
+	for _, o := range osTokens {
+		for _, tok := range o.tokens {
+			if strings.Contains(ua, tok) {
+				a.OS = o.os
+				break os
+			}
+		}
+	}
+
+	lower := strings.ToLower(ua)
+	switch {
+	case containsAny(lower, botTokens):
+		a.Device = Bot
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		(a.OS == Android && !strings.Contains(ua, "Mobile")):
+		a.Device = Tablet
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod"):
+		a.Device = Mobile
+	}
+	return a
+}
+
+// Family returns the browser family of ua, Other if it is not recognised,
+// or "" if ua is empty.
+func Family(ua string) string {
+	if a := Parse(ua); a != nil {
+		return a.Family
+	}
+	return ""
+}
+
+// majorAfter reads the major version following token in ua.
+func majorAfter(ua, token string) int {
+	_, rest, ok := strings.Cut(ua, token)
+	if !ok {
+		return 0
+	}
+	return majorAt(rest)
+}
+
+// majorAt reads the leading integer of s, such as 124 from "124.0.6367".
+func majorAt(s string) int {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
+
+func containsAny(s string, tokens []string) bool {
+	for _, tok := range tokens {
+		if strings.Contains(s, tok) {
+			return true
+		}
+	}
+	return false
+}
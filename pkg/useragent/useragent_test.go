@@ -0,0 +1,52 @@
+package useragent
+
+import "testing"
+
+func TestFamily(t *testing.T) {
+	for _, tc := range []struct {
+		ua, want string
+	}{
+		{"", ""},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", Chrome},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1", Chrome},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.67", Edge},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 OPR/109.0.0.0", Opera},
+		{"Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36", SamsungInternet},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.4; rv:125.0) Gecko/20100101 Firefox/125.0", Firefox},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4_1) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15", Safari},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148", Safari},
+		{"curl/8.7.1", Other},
+	} {
+		if got := Family(tc.ua); got != tc.want {
+			t.Errorf("Family(%q) = %q, want %q", tc.ua, got, tc.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	if a := Parse("  "); a != nil {
+		t.Errorf("Parse(blank) = %+v, want nil", a)
+	}
+	for _, tc := range []struct {
+		ua   string
+		want Agent
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", Agent{Family: Chrome, Major: 124, OS: Linux, Device: Desktop}},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1", Agent{Family: Chrome, Major: 124, OS: IOS, Device: Mobile}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/125.0.2478.67", Agent{Family: Edge, Major: 125, OS: Windows, Device: Desktop}},
+		{"Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36", Agent{Family: SamsungInternet, Major: 24, OS: Android, Device: Mobile}},
+		{"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36", Agent{Family: Chrome, Major: 123, OS: Android, Device: Tablet}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.4; rv:125.0) Gecko/20100101 Firefox/125.0", Agent{Family: Firefox, Major: 125, OS: MacOS, Device: Desktop}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4_1) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15", Agent{Family: Safari, Major: 17, OS: MacOS, Device: Desktop}},
+		{"Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", Agent{Family: Safari, Major: 17, OS: IOS, Device: Tablet}},
+		{"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", Agent{Family: Chrome, Major: 124, OS: ChromeOS, Device: Desktop}},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", Agent{Family: Other, OS: Other, Device: Bot}},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/124.0.0.0 Safari/537.36", Agent{Family: Chrome, Major: 124, OS: Linux, Device: Bot}},
+		{"curl/8.7.1", Agent{Family: Other, OS: Other, Device: Desktop}},
+	} {
+		tc.want.Raw = tc.ua
+		if got := Parse(tc.ua); got == nil || *got != tc.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tc.ua, got, tc.want)
+		}
+	}
+}